@@ -3,12 +3,15 @@ package eddwise
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"golang.org/x/exp/slices"
 )
 
 type AuthChallenge struct {
 	Methods []string `json:"methods"`
+	// Nonces holds the nonce of each multi-step method, by method name
+	Nonces map[string]string `json:"nonces,omitempty"`
 }
 
 func (*AuthChallenge) GetEventName() string {
@@ -39,6 +42,8 @@ type UserState[T any] struct {
 type Auth struct {
 	Id   string      `json:"id"`
 	Data interface{} `json:"data"`
+	// ExpiresAt, if set, makes the server ask the client to refresh the auth, see SetAuthRefresh
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type StateManager[T any] struct{}
@@ -66,12 +71,15 @@ type ConnManager struct {
 	mx              sync.RWMutex
 	clients         map[uint64]Client
 	userConnections map[string]map[uint64]Client
+	// userIds are the users the clients joined as, they do not follow later changes of the client auth
+	userIds map[uint64]string
 }
 
 func (cm *ConnManager) connManagerInit() {
 	//cm.auths = map[uint64]*Auth{}
 	cm.clients = map[uint64]Client{}
 	cm.userConnections = map[string]map[uint64]Client{}
+	cm.userIds = map[uint64]string{}
 }
 
 func (cm *ConnManager) setAuth(client Client, a *Auth) bool {
@@ -80,7 +88,11 @@ func (cm *ConnManager) setAuth(client Client, a *Auth) bool {
 
 	client.setRawAuth(a)
 
+	if id, ok := cm.userIds[client.GetId()]; ok && id != a.Id {
+		cm.removeUserConnection(id, client.GetId())
+	}
 	cm.clients[client.GetId()] = client
+	cm.userIds[client.GetId()] = a.Id
 
 	if _, ok := cm.userConnections[a.Id]; !ok {
 		cm.userConnections[a.Id] = map[uint64]Client{}
@@ -93,11 +105,20 @@ func (cm *ConnManager) setAuth(client Client, a *Auth) bool {
 func (cm *ConnManager) removeAuth(clientId uint64) bool {
 	cm.mx.Lock()
 	defer cm.mx.Unlock()
-	auth := cm.clients[clientId].GetRawAuth()
+	if _, ok := cm.clients[clientId]; !ok {
+		return true
+	}
+	var id = cm.userIds[clientId]
 	delete(cm.clients, clientId)
-	delete(cm.userConnections[auth.Id], clientId)
-	if len(cm.userConnections[auth.Id]) == 0 {
-		delete(cm.userConnections, auth.Id)
+	delete(cm.userIds, clientId)
+	return cm.removeUserConnection(id, clientId)
+}
+
+// removeUserConnection reports whether the user has other connections, cm.mx must be held
+func (cm *ConnManager) removeUserConnection(id string, clientId uint64) bool {
+	delete(cm.userConnections[id], clientId)
+	if len(cm.userConnections[id]) == 0 {
+		delete(cm.userConnections, id)
 		return false
 	}
 	return true
@@ -108,7 +129,7 @@ func (cm *ConnManager) GetAuthorizedUserClients(exceptUserIds ...string) []Clien
 	defer cm.mx.RUnlock()
 	var ret = make([]Client, 0, len(cm.userConnections))
 	for clientId, client := range cm.clients {
-		if slices.Contains(exceptUserIds, cm.clients[clientId].GetRawAuth().Id) {
+		if slices.Contains(exceptUserIds, cm.userIds[clientId]) {
 			continue
 		}
 		ret = append(ret, client)
@@ -162,10 +183,14 @@ func (*BasicAuth) ProtocolAlias() string {
 	return "edd:auth:basic"
 }
 
+// ChannelAuthMethods returns the names of the built-in auth methods supported by the channel.
+// Deprecated: the server may register more methods or replace the built-in ones, use ServerSocket.ChannelAuthMethods
 func ChannelAuthMethods(ch ImplChannel) []string {
 	var methods []string
-	if _, ok := ch.(ImplChannelBasicAuth); ok {
-		methods = append(methods, "edd:auth:basic")
+	for _, m := range defaultAuthMethods() {
+		if m.Supports(ch) {
+			methods = append(methods, m.Name)
+		}
 	}
 	return methods
 }
@@ -174,20 +199,34 @@ type ImplChannelBasicAuth interface {
 	OnBasicAuth(Context, *BasicAuth) (*Auth, error)
 }
 
+// CheckAuth challenges the client on every channel requiring an auth, reading the answers from its connection,
+// a client authenticated by its upgrade request is not challenged.
+// Deprecated: the channels challenge their clients when they subscribe
 func (s *ServerSocket) CheckAuth(ctx Context, client *ClientSocket) error {
 
 	for _, ch := range s.RegisteredChannels {
-		if authMethods := ChannelAuthMethods(ch); len(authMethods) > 0 {
-			if err := client.Send(ch.Alias(), &AuthChallenge{Methods: authMethods}); err != nil {
+		if client.authedOnUpgrade {
+			if len(s.channelAuthMethods(ch)) > 0 {
+				if err := s.acceptAuth(ctx, ch); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		challenge, err := s.authChallenge(ctx, ch)
+		if err != nil {
+			return err
+		}
+		if challenge != nil {
+			if err := client.Send(ch.Alias(), challenge); err != nil {
 				return fmt.Errorf("unable to send auth challenge to %d: %w", client.id, err)
 			}
 			var msg []byte
-			var err error
-			if _, msg, err = client.Conn.ReadMessage(); err != nil {
+			if _, msg, err = client.Conn.ReadFrame(); err != nil {
 				return fmt.Errorf("auth read: %w", err)
 
 			}
-			if err := s.ProcessEventAuth(ctx, ch, msg); err != nil {
+			if err := s.processEventAuth(ctx, ch, msg, challenge.Nonces); err != nil {
 				return err
 			}
 			if err := client.Send(ch.Alias(), &AuthPass{Id: ctx.GetClient().GetRawAuth().Id}); err != nil {
@@ -200,22 +239,37 @@ func (s *ServerSocket) CheckAuth(ctx Context, client *ClientSocket) error {
 
 func (s *ServerSocket) RevokeAuth(ctx Context, client *ClientSocket) error {
 	for _, ch := range s.RegisteredChannels {
-		if chAuth, ok := ch.(ImplConnManager); ok {
-			if !chAuth.removeAuth(client.id) {
-				if chLeft, ok := ch.(ImplChannelWithUserLeft); ok {
-					if err := chLeft.onLeft(ch, ctx.GetClient()); err != nil {
-						return err
-					}
-				}
-			}
+		if err := s.revokeChannelAuth(ctx, ch, client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeChannelAuth forgets the auth of the client on the channel, peers are notified when its user left
+func (s *ServerSocket) revokeChannelAuth(ctx Context, ch ImplChannel, client Client) error {
+	chAuth, ok := ch.(ImplConnManager)
+	if !ok {
+		return nil
+	}
+	if s.cluster != nil && client.GetRawAuth() != nil {
+		s.cluster.publishAuth(ch.Alias(), client, nil)
+	}
+	if !chAuth.removeAuth(client.GetId()) {
+		if chLeft, ok := ch.(ImplChannelWithUserLeft); ok {
+			return chLeft.onLeft(ch, ctx.GetClient())
 		}
 	}
 	return nil
 }
 
 func (s *ServerSocket) ProcessEventAuth(ctx Context, chAuth ImplChannel, rawEvent []byte) error {
-	var event = &EventMessage{}
-	if err := s.Codec().Decode(rawEvent, event); err != nil {
+	return s.processEventAuth(ctx, chAuth, rawEvent, nil)
+}
+
+func (s *ServerSocket) processEventAuth(ctx Context, chAuth ImplChannel, rawEvent []byte, nonces map[string]string) error {
+	var event = &EventMessage{codec: s.clientCodec(ctx.GetClient())}
+	if err := event.codec.Decode(rawEvent, event); err != nil {
 		return fmt.Errorf("decoding error: %w", err)
 	}
 	if len(event.Channel) == 0 {
@@ -228,27 +282,69 @@ func (s *ServerSocket) ProcessEventAuth(ctx Context, chAuth ImplChannel, rawEven
 	if ch != chAuth {
 		return fmt.Errorf("ch auth mismatch")
 	}
-	switch event.Name {
-	case "edd:auth:basic":
-		chBasic, ok := ch.(ImplChannelBasicAuth)
-		if !ok {
-			return fmt.Errorf("basic auth not supported")
-		}
-		var ba = &BasicAuth{}
-		if err := s.codec.Decode(event.Body, ba); err != nil {
-			return err
-		}
-		auth, err := chBasic.OnBasicAuth(ctx, ba)
-		if err != nil {
-			return err
+	return s.processAuth(ctx, ch, event, nonces)
+}
+
+// processAuth authenticates the client with the auth event sent on the channel, nonces are the ones of the
+// challenge it answers
+func (s *ServerSocket) processAuth(ctx Context, ch ImplChannel, event *EventMessage, nonces map[string]string) error {
+	auth, err := s.verifyAuth(ctx, ch, event, nonces)
+	if err != nil {
+		return err
+	}
+	if prev := ctx.GetClient().GetRawAuth(); prev != nil && prev.Id != auth.Id && s.identityBound(ctx.GetClient(), ch) {
+		return fmt.Errorf("already authenticated as another user")
+	}
+	ctx.GetClient().setRawAuth(auth)
+	s.scheduleAuthExpiry(ctx.GetClient())
+	return s.acceptAuth(ctx, ch)
+}
+
+// identityBound reports whether the auth of the client comes from its upgrade request or from a channel other than
+// ch: a client holds a single auth, it cannot change its identity while authenticated elsewhere
+func (s *ServerSocket) identityBound(client Client, ch ImplChannel) bool {
+	if upgradeAuthenticated(client) {
+		return true
+	}
+	for _, alias := range client.GetChannels() {
+		if c, ok := s.RegisteredChannels[alias]; ok && c != ch && len(s.channelAuthMethods(c)) > 0 {
+			return true
 		}
-		ctx.GetClient().setRawAuth(auth)
-	default:
-		return fmt.Errorf("unknown auth method")
 	}
+	return false
+}
 
+// verifyAuth returns the auth granted by the method of the event
+func (s *ServerSocket) verifyAuth(ctx Context, ch ImplChannel, event *EventMessage, nonces map[string]string) (*Auth, error) {
+	var method = s.channelAuthMethod(ch, event.Name)
+	if method == nil {
+		return nil, fmt.Errorf("unknown auth method %s", event.Name)
+	}
+	var nonce = nonces[method.Name]
+	if method.Nonce != nil && len(nonce) == 0 {
+		return nil, fmt.Errorf("no nonce sent for %s", method.Name)
+	}
+	var body = method.NewBody()
+	if err := event.DecodeBody(body); err != nil {
+		return nil, err
+	}
+	auth, err := method.Verify(ctx, ch, nonce, body)
+	if err != nil {
+		return nil, err
+	}
+	if auth == nil {
+		return nil, fmt.Errorf("no auth returned by %s", method.Name)
+	}
+	return auth, nil
+}
+
+// acceptAuth registers the auth of the client on the channel, peers are notified when its user joined
+func (s *ServerSocket) acceptAuth(ctx Context, ch ImplChannel) error {
 	if chAuthMan, ok := ch.(ImplConnManager); ok {
 		var first = chAuthMan.setAuth(ctx.GetClient(), ctx.GetClient().GetRawAuth())
+		if s.cluster != nil {
+			s.cluster.publishAuth(ch.Alias(), ctx.GetClient(), ctx.GetClient().GetRawAuth())
+		}
 		if chJoin, ok := ch.(ImplChannelWithUserJoin); ok {
 			return chJoin.onJoin(ch, ctx.GetClient(), first)
 		}
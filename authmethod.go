@@ -0,0 +1,135 @@
+package eddwise
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// AuthMethod answers the auth challenge of the channels supporting it, the client sends an event named after it,
+// such as edd:auth:basic, on the channel
+type AuthMethod struct {
+	Name string
+	// Supports reports whether the channel accepts the method, every channel of the server does when nil
+	Supports func(ch ImplChannel) bool
+	// NewBody returns the body the answer of the client is decoded into
+	NewBody func() interface{}
+	// Verify authenticates the client from the decoded body, nonce is the one sent along with the challenge
+	Verify func(ctx Context, ch ImplChannel, nonce string, body interface{}) (*Auth, error)
+	// Nonce, if set, makes the method multi-step: the nonce is sent to the client in the challenge and the
+	// answer of the client must prove it, for example by signing it
+	Nonce func(ctx Context, ch ImplChannel) (string, error)
+}
+
+// NewAuthMethod returns an AuthMethod decoding the answers of the clients into a T
+func NewAuthMethod[T any](name string, verify func(ctx Context, ch ImplChannel, nonce string, body *T) (*Auth, error)) *AuthMethod {
+	return &AuthMethod{
+		Name:    name,
+		NewBody: func() interface{} { return new(T) },
+		Verify: func(ctx Context, ch ImplChannel, nonce string, body interface{}) (*Auth, error) {
+			return verify(ctx, ch, nonce, body.(*T))
+		},
+	}
+}
+
+// RandomNonce returns 32 random bytes base64url encoded, it fits AuthMethod.Nonce
+func RandomNonce(Context, ImplChannel) (string, error) {
+	var b = make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// defaultAuthMethods returns the methods of the channels implementing ImplChannelBasicAuth or ImplChannelTokenAuth
+func defaultAuthMethods() []*AuthMethod {
+	var basic = NewAuthMethod("edd:auth:basic", func(ctx Context, ch ImplChannel, _ string, ba *BasicAuth) (*Auth, error) {
+		return ch.(ImplChannelBasicAuth).OnBasicAuth(ctx, ba)
+	})
+	basic.Supports = func(ch ImplChannel) bool {
+		_, ok := ch.(ImplChannelBasicAuth)
+		return ok
+	}
+	var token = NewAuthMethod("edd:auth:token", func(ctx Context, ch ImplChannel, _ string, ta *TokenAuth) (*Auth, error) {
+		return tokenAuth(ctx, ch.(ImplChannelTokenAuth), ta)
+	})
+	token.Supports = func(ch ImplChannel) bool {
+		_, ok := ch.(ImplChannelTokenAuth)
+		return ok
+	}
+	return []*AuthMethod{basic, token}
+}
+
+// RegisterAuthMethod adds an auth method to the challenges of the channels supporting it, or replaces the method
+// with the same name, such as edd:auth:basic. Names must start with edd:auth:
+func (s *ServerSocket) RegisterAuthMethod(m *AuthMethod) error {
+	if !isAuthEvent(m.Name) {
+		return fmt.Errorf("auth method '%s' must start with edd:auth:", m.Name)
+	}
+	if m.NewBody == nil || m.Verify == nil {
+		return errors.New("auth method requires NewBody and Verify")
+	}
+	for i, am := range s.authMethods {
+		if am.Name == m.Name {
+			s.authMethods[i] = m
+			return nil
+		}
+	}
+	s.authMethods = append(s.authMethods, m)
+	return nil
+}
+
+// ChannelAuthMethods returns the names of the auth methods supported by the channel, the ones registered with
+// RegisterAuthMethod included, in the order of the challenges
+func (s *ServerSocket) ChannelAuthMethods(ch ImplChannel) []string {
+	var names []string
+	for _, m := range s.channelAuthMethods(ch) {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+// channelAuthMethods returns the auth methods supported by the channel, in order of registration
+func (s *ServerSocket) channelAuthMethods(ch ImplChannel) []*AuthMethod {
+	var ret []*AuthMethod
+	for _, m := range s.authMethods {
+		if m.Supports == nil || m.Supports(ch) {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+func (s *ServerSocket) channelAuthMethod(ch ImplChannel, name string) *AuthMethod {
+	for _, m := range s.channelAuthMethods(ch) {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// authChallenge returns the challenge of the channel along with its nonces, nil when the channel requires no auth
+func (s *ServerSocket) authChallenge(ctx Context, ch ImplChannel) (*AuthChallenge, error) {
+	var methods = s.channelAuthMethods(ch)
+	if len(methods) == 0 {
+		return nil, nil
+	}
+	var challenge = &AuthChallenge{}
+	for _, m := range methods {
+		challenge.Methods = append(challenge.Methods, m.Name)
+		if m.Nonce == nil {
+			continue
+		}
+		nonce, err := m.Nonce(ctx, ch)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create the nonce of %s: %w", m.Name, err)
+		}
+		if challenge.Nonces == nil {
+			challenge.Nonces = map[string]string{}
+		}
+		challenge.Nonces[m.Name] = nonce
+	}
+	return challenge, nil
+}
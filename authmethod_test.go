@@ -0,0 +1,92 @@
+package eddwise
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type hmacAnswer struct {
+	User      string `json:"user"`
+	Signature string `json:"signature"`
+}
+
+func signNonce(key, nonce string) string {
+	var mac = hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthMethodNonce(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var ch, private = &TestChannel{}, &authTestChannel{}
+	for _, c := range []ImplChannel{ch, private} {
+		if err := s.Register(c); err != nil {
+			t.Fatalf("unable to register channel: %s\n", err)
+		}
+	}
+	var method = NewAuthMethod("edd:auth:hmac", func(_ Context, _ ImplChannel, nonce string, body *hmacAnswer) (*Auth, error) {
+		if !hmac.Equal([]byte(body.Signature), []byte(signNonce("key-"+body.User, nonce))) {
+			return nil, errors.New("bad signature")
+		}
+		return &Auth{Id: body.User}, nil
+	})
+	method.Nonce = RandomNonce
+	method.Supports = func(ch ImplChannel) bool { return ch.Alias() == "private" }
+	if err := s.RegisterAuthMethod(&AuthMethod{Name: "hmac", NewBody: method.NewBody, Verify: method.Verify}); err == nil {
+		t.Fatalf("expecting an error registering a method outside of edd:auth:\n")
+	}
+	if err := s.RegisterAuthMethod(method); err != nil {
+		t.Fatalf("unable to register auth method: %s\n", err)
+	}
+	if methods := s.ChannelAuthMethods(private); len(methods) != 2 || methods[0] != "edd:auth:basic" || methods[1] != "edd:auth:hmac" {
+		t.Fatalf("unexpected auth methods %v of the private channel\n", methods)
+	}
+	if methods := s.ChannelAuthMethods(ch); len(methods) != 0 {
+		t.Fatalf("unexpected auth methods %v of the test channel\n", methods)
+	}
+
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(serverConn, nil)
+	defer func() { _ = conn.Close() }()
+
+	// the method is not supported by the test channel, which requires no auth
+	subscribeJSON(t, conn, "test")
+
+	var subscribe = EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: "private"}}
+	var challenge = func() string {
+		sendJSON(t, conn, subscribe)
+		var msg = expectJSON(t, conn, "private", "edd:auth:challenge")
+		var c AuthChallenge
+		if err := json.Unmarshal(msg.Body, &c); err != nil {
+			t.Fatalf("unable to decode the challenge: %s\n", err)
+		}
+		if len(c.Methods) != 2 || c.Methods[0] != "edd:auth:basic" || c.Methods[1] != "edd:auth:hmac" || len(c.Nonces["edd:auth:hmac"]) == 0 {
+			t.Fatalf("unexpected challenge %s\n", msg.Body)
+		}
+		return c.Nonces["edd:auth:hmac"]
+	}
+
+	var nonce = challenge()
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:hmac", Body: &hmacAnswer{User: "user", Signature: signNonce("key-user", "another nonce")}})
+	expectErrorJSON(t, conn, ErrCodeAuthFailed)
+
+	// the nonce of a previous challenge is not accepted anymore
+	if next := challenge(); next == nonce {
+		t.Fatalf("the nonce was reused\n")
+	}
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:hmac", Body: &hmacAnswer{User: "user", Signature: signNonce("key-user", nonce)}})
+	expectErrorJSON(t, conn, ErrCodeAuthFailed)
+
+	nonce = challenge()
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:hmac", Body: &hmacAnswer{User: "user", Signature: signNonce("key-user", nonce)}})
+	expectJSON(t, conn, "private", "edd:auth:pass")
+	expectJSON(t, conn, SystemChannel, "edd:channel:subscribed")
+	if ids := private.GetAuthorizedUserIds(); len(ids) != 1 || ids[0] != "user" {
+		t.Fatalf("unexpected authorized users %v\n", ids)
+	}
+}
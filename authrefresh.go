@@ -0,0 +1,176 @@
+package eddwise
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultAuthRefreshNotice is how long before the expiry of its auth a client is asked to refresh it
+const DefaultAuthRefreshNotice = 30 * time.Second
+
+// AuthRefreshRequired is sent on the channels requiring an auth before the auth of the client expires, the client
+// answers it as a challenge with a new credential, without reconnecting
+type AuthRefreshRequired struct {
+	Methods []string          `json:"methods"`
+	Nonces  map[string]string `json:"nonces,omitempty"`
+	// ExpiresAt is the expiry of the current auth, in unix seconds
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+func (*AuthRefreshRequired) GetEventName() string {
+	return "edd:auth:refresh_required"
+}
+
+func (*AuthRefreshRequired) ProtocolAlias() string {
+	return "edd:auth:refresh_required"
+}
+
+// SetAuthRefresh sets how long before the expiry of its auth a client is asked to refresh it, and how long after
+// the expiry a client that did not is disconnected. Only the auths with an ExpiresAt expire
+func (s *ServerSocket) SetAuthRefresh(notice, grace time.Duration) {
+	s.authRefreshNotice, s.authRefreshGrace = notice, grace
+}
+
+// scheduleAuthExpiry arms the expiry of the current auth of the client, replacing the previous one
+func (s *ServerSocket) scheduleAuthExpiry(client Client) {
+	cs, ok := client.(*ClientSocket)
+	if !ok {
+		return
+	}
+	var auth = cs.GetRawAuth()
+	cs.authMx.Lock()
+	defer cs.authMx.Unlock()
+	if cs.authTimer != nil {
+		cs.authTimer.Stop()
+	}
+	cs.authTimer, cs.expiringAuth, cs.refreshNonces = nil, nil, nil
+	if auth == nil || auth.ExpiresAt.IsZero() {
+		return
+	}
+	cs.expiringAuth = auth
+	var notice = time.Until(auth.ExpiresAt.Add(-s.authRefreshNotice))
+	if notice < 0 {
+		notice = 0
+	}
+	cs.authTimer = time.AfterFunc(notice, func() {
+		s.requireAuthRefresh(cs, auth)
+	})
+}
+
+// stopAuthExpiry disarms the expiry of the auth of a disconnected client
+func (s *ServerSocket) stopAuthExpiry(client *ClientSocket) {
+	client.authMx.Lock()
+	defer client.authMx.Unlock()
+	if client.authTimer != nil {
+		client.authTimer.Stop()
+	}
+	client.authTimer, client.expiringAuth, client.refreshNonces = nil, nil, nil
+}
+
+// requireAuthRefresh challenges the client again on its channels requiring an auth, then waits for the grace period
+func (s *ServerSocket) requireAuthRefresh(client *ClientSocket, auth *Auth) {
+	client.authMx.Lock()
+	if client.expiringAuth != auth {
+		// the auth was refreshed meanwhile
+		client.authMx.Unlock()
+		return
+	}
+	client.authTimer = time.AfterFunc(time.Until(auth.ExpiresAt.Add(s.authRefreshGrace)), func() {
+		s.expireAuth(client, auth)
+	})
+	client.authMx.Unlock()
+
+	s.logger.Debug("client auth refresh required", client.logArgs("expires_at", auth.ExpiresAt)...)
+	var ctx = NewDefaultContext(context.Background(), s, client)
+	for _, alias := range client.GetChannels() {
+		ch, ok := s.RegisteredChannels[alias]
+		if !ok {
+			continue
+		}
+		challenge, err := s.authChallenge(ctx, ch)
+		if err != nil {
+			s.logger.Warn("unable to create auth refresh challenge", client.logArgs("channel", alias, "error", err)...)
+			continue
+		}
+		if challenge == nil {
+			continue
+		}
+		client.authMx.Lock()
+		if client.refreshNonces == nil {
+			client.refreshNonces = map[string]map[string]string{}
+		}
+		client.refreshNonces[alias] = challenge.Nonces
+		client.authMx.Unlock()
+		var refresh = &AuthRefreshRequired{Methods: challenge.Methods, Nonces: challenge.Nonces, ExpiresAt: auth.ExpiresAt.Unix()}
+		if err := client.Send(alias, refresh); err != nil {
+			s.logger.Warn("unable to write auth refresh required", client.logArgs("channel", alias, "error", err)...)
+		}
+	}
+}
+
+// expireAuth disconnects the client whose auth was not refreshed during the grace period
+func (s *ServerSocket) expireAuth(client *ClientSocket, auth *Auth) {
+	client.authMx.Lock()
+	if client.expiringAuth != auth {
+		client.authMx.Unlock()
+		return
+	}
+	client.authTimer, client.expiringAuth, client.refreshNonces = nil, nil, nil
+	client.authMx.Unlock()
+
+	s.logger.Info("closing client with expired auth", client.logArgs()...)
+	_ = SendError(client, NewError(ErrCodeAuthExpired, "auth expired"))
+	_ = client.Close()
+	if client.session != nil {
+		// a parked client does not read anymore, end its session right away
+		s.expireSession(client)
+	}
+}
+
+// refreshAuth replaces the auth of a subscribed client with the credential it sent on the channel. The identity
+// can change only when no other channel authenticated the client, it then leaves the users of the channel with
+// the previous one and joins with the new one
+func (s *ServerSocket) refreshAuth(ctx Context, ch ImplChannel, event *EventMessage) error {
+	var client = ctx.GetClient()
+	var nonces map[string]string
+	if cs, ok := client.(*ClientSocket); ok {
+		cs.authMx.Lock()
+		nonces = cs.refreshNonces[ch.Alias()]
+		delete(cs.refreshNonces, ch.Alias())
+		cs.authMx.Unlock()
+	}
+	auth, err := s.verifyAuth(ctx, ch, event, nonces)
+	if err != nil {
+		s.metrics.AuthFailures.Inc()
+		s.logger.Info("client auth refresh failed", clientLogArgs(client, "channel", ch.Alias(), "error", err)...)
+		return asError(err, ErrCodeAuthFailed)
+	}
+
+	var prev = client.GetRawAuth()
+	var changed = prev == nil || prev.Id != auth.Id
+	if changed && s.identityBound(client, ch) {
+		// the other channels did not verify the new identity
+		s.metrics.AuthFailures.Inc()
+		s.logger.Info("client auth refresh refused", clientLogArgs(client, "channel", ch.Alias(), "id", auth.Id)...)
+		return NewError(ErrCodeAuthFailed, "already authenticated as another user").withOrigin(ch.Alias(), event.Name)
+	}
+	if changed {
+		_ = s.revokeChannelAuth(ctx, ch, client)
+	}
+	client.setRawAuth(auth)
+	if changed {
+		_ = s.acceptAuth(ctx, ch)
+	} else if s.cluster != nil {
+		// the other nodes keep a copy of the auth for each channel
+		for _, alias := range client.GetChannels() {
+			if c, ok := s.RegisteredChannels[alias]; ok {
+				if _, ok := c.(ImplConnManager); ok {
+					s.cluster.publishAuth(alias, client, auth)
+				}
+			}
+		}
+	}
+	s.scheduleAuthExpiry(client)
+	s.logger.Debug("client auth refreshed", clientLogArgs(client, "channel", ch.Alias(), "id", auth.Id)...)
+	return client.Send(ch.Alias(), &AuthPass{Id: auth.Id})
+}
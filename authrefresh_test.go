@@ -0,0 +1,160 @@
+package eddwise
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+)
+
+// refreshTestChannel grants an auth expiring shortly to the users whose password is "expiring"
+type refreshTestChannel struct {
+	authTestChannel
+}
+
+func (ch *refreshTestChannel) OnBasicAuth(ctx Context, ba *BasicAuth) (*Auth, error) {
+	if ba.Password == "expiring" {
+		return &Auth{Id: ba.Username, ExpiresAt: time.Now().Add(400 * time.Millisecond)}, nil
+	}
+	return ch.authTestChannel.OnBasicAuth(ctx, ba)
+}
+
+// expectSkippingUsers is expectJSON ignoring the user join and left events
+func expectSkippingUsers(t *testing.T, conn Conn, channel, name string) EventMessageTest {
+	t.Helper()
+	for {
+		var msg = EventMessageTest{}
+		receiveJSON(t, conn, &msg)
+		if msg.Name == "edd:user:join" || msg.Name == "edd:user:left" {
+			continue
+		}
+		if msg.Channel != channel || msg.Name != name {
+			t.Fatalf("unexpected message %s %s %s, expecting %s %s\n", msg.Channel, msg.Name, msg.Body, channel, name)
+		}
+		return msg
+	}
+}
+
+func TestAuthRefresh(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetAuthRefresh(300*time.Millisecond, 100*time.Millisecond)
+	var private = &refreshTestChannel{}
+	if err := s.Register(private); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var connect = func(username, password string) Conn {
+		var serverConn, conn = NewPipe()
+		go s.ServeConn(serverConn, nil)
+		sendJSON(t, conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: "private"}})
+		expectJSON(t, conn, "private", "edd:auth:challenge")
+		sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:basic", Body: &BasicAuth{Username: username, Password: password}})
+		expectSkippingUsers(t, conn, "private", "edd:auth:pass")
+		expectJSON(t, conn, SystemChannel, "edd:channel:subscribed")
+		return conn
+	}
+
+	var observer = connect("carol", "secret")
+	defer func() { _ = observer.Close() }()
+	var conn = connect("alice", "expiring")
+	defer func() { _ = conn.Close() }()
+	expectJSON(t, observer, "private", "edd:user:join")
+
+	// the refresh changes the identity of the client
+	var msg = expectSkippingUsers(t, conn, "private", "edd:auth:refresh_required")
+	var refresh AuthRefreshRequired
+	if err := json.Unmarshal(msg.Body, &refresh); err != nil || len(refresh.Methods) != 1 || refresh.ExpiresAt == 0 {
+		t.Fatalf("unexpected refresh required %s\n", msg.Body)
+	}
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:basic", Body: &BasicAuth{Username: "bob", Password: "secret"}})
+	if msg = expectSkippingUsers(t, conn, "private", "edd:auth:pass"); string(msg.Body) != `{"id":"bob"}` {
+		t.Fatalf("unexpected auth pass %s\n", msg.Body)
+	}
+	expectJSON(t, observer, "private", "edd:user:left")
+	expectJSON(t, observer, "private", "edd:user:join")
+	var ids = private.GetAuthorizedUserIds()
+	sort.Strings(ids)
+	if len(ids) != 2 || ids[0] != "bob" || ids[1] != "carol" {
+		t.Fatalf("unexpected authorized users after the refresh %v\n", ids)
+	}
+
+	// a client not refreshing its auth is disconnected after the grace period
+	var expiring = connect("dave", "expiring")
+	defer func() { _ = expiring.Close() }()
+	expectSkippingUsers(t, expiring, "private", "edd:auth:refresh_required")
+	expectErrorJSON(t, expiring, ErrCodeAuthExpired)
+	var done = make(chan error, 1)
+	go func() {
+		_, _, err := expiring.ReadFrame()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expecting the connection to be closed\n")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the connection was not closed\n")
+	}
+	// bob did not expire, dave leaves once its connection is closed
+	for deadline := time.Now().Add(time.Second); len(private.GetAuthorizedUserIds()) != 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected authorized users after the expiry %v\n", private.GetAuthorizedUserIds())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAuthRefreshIdentity(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetAuthRefresh(300*time.Millisecond, time.Second)
+	var admin, private = &adminTestChannel{}, &refreshTestChannel{}
+	for _, c := range []ImplChannel{admin, private} {
+		if err := s.Register(c); err != nil {
+			t.Fatalf("unable to register channel: %s\n", err)
+		}
+	}
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(serverConn, nil)
+	defer func() { _ = conn.Close() }()
+	subscribeAuthJSON(t, conn, "admin", "root")
+	expectSkippingUsers(t, conn, "admin", "edd:auth:pass")
+	expectJSON(t, conn, SystemChannel, "edd:channel:subscribed")
+	sendJSON(t, conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: "private"}})
+	expectJSON(t, conn, "private", "edd:auth:challenge")
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:basic", Body: &BasicAuth{Username: "root", Password: "expiring"}})
+	expectSkippingUsers(t, conn, "private", "edd:auth:pass")
+	expectJSON(t, conn, SystemChannel, "edd:channel:subscribed")
+
+	// both channels ask for the refresh
+	var channels = map[string]bool{}
+	for i := 0; i < 2; i++ {
+		var msg = EventMessageTest{}
+		receiveJSON(t, conn, &msg)
+		if msg.Name != "edd:auth:refresh_required" {
+			t.Fatalf("unexpected message %s %s, expecting a refresh required\n", msg.Channel, msg.Name)
+		}
+		channels[msg.Channel] = true
+	}
+	if !channels["admin"] || !channels["private"] {
+		t.Fatalf("unexpected refresh required channels %v\n", channels)
+	}
+
+	// private accepts mallory, but admin did not verify it
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:basic", Body: &BasicAuth{Username: "mallory", Password: "secret"}})
+	expectErrorJSON(t, conn, ErrCodeAuthFailed)
+	if ids := admin.GetAuthorizedUserIds(); len(ids) != 1 || ids[0] != "root" {
+		t.Fatalf("unexpected authorized users on admin %v\n", ids)
+	}
+	if ids := private.GetAuthorizedUserIds(); len(ids) != 1 || ids[0] != "root" {
+		t.Fatalf("unexpected authorized users on private %v\n", ids)
+	}
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:basic", Body: &BasicAuth{Username: "root", Password: "secret"}})
+	if msg := expectSkippingUsers(t, conn, "private", "edd:auth:pass"); string(msg.Body) != `{"id":"root"}` {
+		t.Fatalf("unexpected auth pass %s\n", msg.Body)
+	}
+	if ids := admin.GetAuthorizedUserIds(); len(ids) != 1 || ids[0] != "root" {
+		t.Fatalf("unexpected authorized users on admin after the refresh %v\n", ids)
+	}
+}
@@ -0,0 +1,117 @@
+package eddwise
+
+import (
+	"errors"
+	"sync"
+)
+
+// Broker delivers messages between the nodes of a cluster, messages published on a topic must be delivered
+// in order to every subscriber of the topic, including the ones of the publishing node
+type Broker interface {
+	Publish(topic string, data []byte) error
+	Subscribe(topic string, handler func(data []byte)) (unsubscribe func(), err error)
+	Close() error
+}
+
+var _ Broker = (*MemoryBroker)(nil)
+
+// MemoryBroker is a Broker for servers living in the same process, mainly useful for tests
+type MemoryBroker struct {
+	mx     sync.RWMutex
+	subs   map[string]map[*memorySubscription]struct{}
+	closed bool
+}
+
+// memorySubscription has an unbounded queue, so handlers can publish without deadlocking
+type memorySubscription struct {
+	mx      sync.Mutex
+	pending [][]byte
+	signal  chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (sub *memorySubscription) push(data []byte) {
+	sub.mx.Lock()
+	sub.pending = append(sub.pending, data)
+	sub.mx.Unlock()
+	select {
+	case sub.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *memorySubscription) run(handler func(data []byte)) {
+	for {
+		select {
+		case <-sub.signal:
+		case <-sub.done:
+			return
+		}
+		sub.mx.Lock()
+		var pending = sub.pending
+		sub.pending = nil
+		sub.mx.Unlock()
+		for _, msg := range pending {
+			handler(msg)
+		}
+	}
+}
+
+func (sub *memorySubscription) stop() {
+	sub.once.Do(func() { close(sub.done) })
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{subs: map[string]map[*memorySubscription]struct{}{}}
+}
+
+func (b *MemoryBroker) Publish(topic string, data []byte) error {
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+	if b.closed {
+		return errors.New("broker is closed")
+	}
+	for sub := range b.subs[topic] {
+		var msg = make([]byte, len(data))
+		copy(msg, data)
+		sub.push(msg)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(topic string, handler func(data []byte)) (func(), error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.closed {
+		return nil, errors.New("broker is closed")
+	}
+	var sub = &memorySubscription{
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	if b.subs[topic] == nil {
+		b.subs[topic] = map[*memorySubscription]struct{}{}
+	}
+	b.subs[topic][sub] = struct{}{}
+	go sub.run(handler)
+	return func() {
+		b.mx.Lock()
+		delete(b.subs[topic], sub)
+		b.mx.Unlock()
+		sub.stop()
+	}, nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.closed = true
+	for _, subs := range b.subs {
+		for sub := range subs {
+			sub.stop()
+		}
+	}
+	b.subs = map[string]map[*memorySubscription]struct{}{}
+	return nil
+}
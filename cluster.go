@@ -0,0 +1,489 @@
+package eddwise
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	clusterTopic    = "edd:cluster"
+	nodeTopicPrefix = "edd:node:"
+)
+
+// clusterCodec keeps the integer types of the bodies relayed between nodes
+var clusterCodec = newClusterCodec()
+
+func newClusterCodec() *HandleCodec {
+	var h = &codec.MsgpackHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.RawToString = true
+	h.Raw = true
+	h.WriteExt = true
+	return NewHandleCodec("", h)
+}
+
+type clusterKind string
+
+const (
+	clusterHello       clusterKind = "hello"
+	clusterBye         clusterKind = "bye"
+	clusterClientJoin  clusterKind = "client_join"
+	clusterClientLeft  clusterKind = "client_left"
+	clusterAuth        clusterKind = "auth"
+	clusterUnauth      clusterKind = "unauth"
+	clusterSubscribe   clusterKind = "subscribe"
+	clusterUnsubscribe clusterKind = "unsubscribe"
+	clusterRoomCreate  clusterKind = "room_create"
+	clusterRoomJoin    clusterKind = "room_join"
+	clusterRoomLeft    clusterKind = "room_left"
+	clusterDeliver     clusterKind = "deliver"
+)
+
+type clusterMessage struct {
+	Kind      clusterKind `codec:"kind"`
+	Node      string      `codec:"node"`
+	Clients   []uint64    `codec:"clients,omitempty"`
+	Channel   string      `codec:"channel,omitempty"`
+	Room      string      `codec:"room,omitempty"`
+	Public    bool        `codec:"public,omitempty"`
+	Auth      *Auth       `codec:"auth,omitempty"`
+	Event     string      `codec:"event,omitempty"`
+	RequestId uint64      `codec:"request_id,omitempty"`
+	Body      codec.Raw   `codec:"body,omitempty"`
+}
+
+// cluster replicates the clients, the auths and the rooms of the local server to the other nodes
+type cluster struct {
+	s           *ServerSocket
+	broker      Broker
+	node        string
+	mx          sync.RWMutex
+	remote      map[uint64]*RemoteClient
+	unsubscribe []func()
+}
+
+// SetBroker joins the cluster of the servers sharing the broker, broadcasts, rooms and user presence are
+// replicated across the nodes. It must be called after the channels are registered and before starting the server
+func (s *ServerSocket) SetBroker(b Broker) error {
+	if s.cluster != nil {
+		return errors.New("broker is already set")
+	}
+	var rnd [8]byte
+	if _, err := rand.Read(rnd[:]); err != nil {
+		return err
+	}
+	var c = &cluster{
+		s:      s,
+		broker: b,
+		node:   hex.EncodeToString(rnd[:]),
+		remote: map[uint64]*RemoteClient{},
+	}
+	// client ids must be unique across the nodes
+	s.ClientAutoInc = uint64(binary.BigEndian.Uint32(rnd[:4])) << 32
+
+	for topic, handler := range map[string]func([]byte){
+		clusterTopic:             c.onClusterMessage,
+		nodeTopicPrefix + c.node: c.onNodeMessage,
+	} {
+		unsubscribe, err := b.Subscribe(topic, handler)
+		if err != nil {
+			c.stop()
+			return fmt.Errorf("unable to subscribe to %s: %w", topic, err)
+		}
+		c.unsubscribe = append(c.unsubscribe, unsubscribe)
+	}
+	s.cluster = c
+	return c.publish(&clusterMessage{Kind: clusterHello})
+}
+
+// NodeId returns the id of the server in the cluster, empty if no broker is set
+func (s *ServerSocket) NodeId() string {
+	if s.cluster == nil {
+		return ""
+	}
+	return s.cluster.node
+}
+
+func (c *cluster) stop() {
+	_ = c.publish(&clusterMessage{Kind: clusterBye})
+	for _, unsubscribe := range c.unsubscribe {
+		unsubscribe()
+	}
+	c.unsubscribe = nil
+}
+
+func (c *cluster) publish(msg *clusterMessage) error {
+	return c.publishTo(clusterTopic, msg)
+}
+
+func (c *cluster) publishTo(topic string, msg *clusterMessage) error {
+	msg.Node = c.node
+	data, err := clusterCodec.Encode(msg)
+	if err != nil {
+		return err
+	}
+	if err := c.broker.Publish(topic, data); err != nil {
+		c.s.logger.Warn("unable to publish cluster message", "kind", msg.Kind, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (c *cluster) decode(data []byte) *clusterMessage {
+	var msg = &clusterMessage{}
+	if err := clusterCodec.Decode(data, msg); err != nil {
+		c.s.logger.Warn("unable to decode cluster message", "error", err)
+		return nil
+	}
+	return msg
+}
+
+// deliver sends the event to clients connected to another node
+func (c *cluster) deliver(node string, clientIds []uint64, channel string, requestId uint64, event Event) error {
+	body, err := clusterCodec.Encode(event)
+	if err != nil {
+		return fmt.Errorf("cannot encode message: %w", err)
+	}
+	return c.publishTo(nodeTopicPrefix+node, &clusterMessage{
+		Kind:      clusterDeliver,
+		Clients:   clientIds,
+		Channel:   channel,
+		Event:     event.ProtocolAlias(),
+		RequestId: requestId,
+		Body:      body,
+	})
+}
+
+func (c *cluster) onNodeMessage(data []byte) {
+	var msg = c.decode(data)
+	if msg == nil || msg.Kind != clusterDeliver {
+		return
+	}
+	var event = &remoteEvent{name: msg.Event}
+	if err := clusterCodec.Decode(msg.Body, &event.body); err != nil {
+		c.s.logger.Warn("unable to decode relayed event", "channel", msg.Channel, "event", msg.Event, "error", err)
+		return
+	}
+	for _, id := range msg.Clients {
+		c.s.ClientsMx.RLock()
+		var client = c.s.Clients[id]
+		c.s.ClientsMx.RUnlock()
+		if client == nil {
+			continue
+		}
+		if err := client.Reply(msg.Channel, msg.RequestId, event); err != nil {
+			c.s.logger.Debug("unable to deliver relayed event", "client_id", id, "channel", msg.Channel, "event", msg.Event, "error", err)
+		}
+	}
+}
+
+func (c *cluster) onClusterMessage(data []byte) {
+	var msg = c.decode(data)
+	if msg == nil || msg.Node == c.node {
+		return
+	}
+	switch msg.Kind {
+	case clusterHello:
+		c.announce()
+	case clusterBye:
+		c.dropNode(msg.Node)
+	case clusterClientJoin:
+		for _, id := range msg.Clients {
+			c.remoteClient(msg.Node, id)
+		}
+	case clusterClientLeft:
+		c.mx.Lock()
+		var clients = make([]*RemoteClient, 0, len(msg.Clients))
+		for _, id := range msg.Clients {
+			if client, ok := c.remote[id]; ok {
+				clients = append(clients, client)
+				delete(c.remote, id)
+			}
+		}
+		c.mx.Unlock()
+		for _, client := range clients {
+			c.unsubscribeAll(client)
+		}
+	case clusterSubscribe:
+		for _, id := range msg.Clients {
+			c.s.addSubscriber(msg.Channel, c.remoteClient(msg.Node, id))
+		}
+	case clusterUnsubscribe:
+		for _, id := range msg.Clients {
+			if client := c.getRemote(id); client != nil {
+				c.s.removeSubscriber(msg.Channel, client)
+			}
+		}
+	case clusterAuth:
+		chAuth, ok := c.s.RegisteredChannels[msg.Channel].(ImplConnManager)
+		if ok && len(msg.Clients) > 0 && msg.Auth != nil {
+			chAuth.setAuth(c.remoteClient(msg.Node, msg.Clients[0]), msg.Auth)
+		}
+	case clusterUnauth:
+		chAuth, ok := c.s.RegisteredChannels[msg.Channel].(ImplConnManager)
+		if ok && len(msg.Clients) > 0 {
+			chAuth.removeAuth(msg.Clients[0])
+		}
+	case clusterRoomCreate:
+		if rm, ok := c.s.RegisteredChannels[msg.Channel].(ImplRoomManager); ok {
+			rm.applyClusterRoom(msg.Kind, msg.Room, msg.Public, nil)
+		}
+	case clusterRoomJoin, clusterRoomLeft:
+		rm, ok := c.s.RegisteredChannels[msg.Channel].(ImplRoomManager)
+		if !ok || len(msg.Clients) == 0 {
+			return
+		}
+		var client Client = c.remoteClient(msg.Node, msg.Clients[0])
+		if msg.Kind == clusterRoomLeft {
+			if client = c.getRemote(msg.Clients[0]); client == nil {
+				return
+			}
+		}
+		rm.applyClusterRoom(msg.Kind, msg.Room, false, client)
+	}
+}
+
+// announce publishes the state of the local clients, so a joining node can catch up
+func (c *cluster) announce() {
+	var clients = c.s.localClients()
+	if len(clients) > 0 {
+		var ids = make([]uint64, 0, len(clients))
+		for _, client := range clients {
+			ids = append(ids, client.GetId())
+		}
+		_ = c.publish(&clusterMessage{Kind: clusterClientJoin, Clients: ids})
+	}
+	for _, client := range clients {
+		for _, alias := range client.GetChannels() {
+			c.publishSubscription(clusterSubscribe, alias, client)
+		}
+	}
+	for alias, ch := range c.s.RegisteredChannels {
+		if _, ok := ch.(ImplConnManager); ok {
+			for _, client := range clients {
+				if auth := client.GetRawAuth(); auth != nil {
+					c.publishAuth(alias, client, auth)
+				}
+			}
+		}
+		if rm, ok := ch.(ImplRoomManager); ok {
+			for _, room := range rm.Rooms() {
+				c.publishRoom(clusterRoomCreate, alias, room.id, room.public, nil)
+				for _, client := range room.Clients() {
+					if _, remote := client.(*RemoteClient); !remote {
+						c.publishRoom(clusterRoomJoin, alias, room.id, false, client)
+					}
+				}
+			}
+		}
+	}
+}
+
+// dropNode forgets every client of a node that left the cluster
+func (c *cluster) dropNode(node string) {
+	var clients []*RemoteClient
+	c.mx.Lock()
+	for id, client := range c.remote {
+		if client.node == node {
+			clients = append(clients, client)
+			delete(c.remote, id)
+		}
+	}
+	c.mx.Unlock()
+	for _, client := range clients {
+		for _, ch := range c.s.RegisteredChannels {
+			if rm, ok := ch.(ImplRoomManager); ok {
+				for _, room := range client.GetRooms() {
+					rm.applyClusterRoom(clusterRoomLeft, room.id, false, client)
+				}
+			}
+			if chAuth, ok := ch.(ImplConnManager); ok && client.GetRawAuth() != nil {
+				chAuth.removeAuth(client.id)
+			}
+		}
+		c.unsubscribeAll(client)
+	}
+}
+
+// unsubscribeAll forgets the subscriptions of a client that left the cluster
+func (c *cluster) unsubscribeAll(client *RemoteClient) {
+	for _, alias := range client.GetChannels() {
+		c.s.removeSubscriber(alias, client)
+	}
+}
+
+func (c *cluster) remoteClient(node string, id uint64) *RemoteClient {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if client, ok := c.remote[id]; ok {
+		return client
+	}
+	var client = &RemoteClient{
+		ClientContextMap: ClientContextMap{m: map[string]interface{}{}},
+		id:               id,
+		node:             node,
+		cluster:          c,
+	}
+	c.remote[id] = client
+	return client
+}
+
+func (c *cluster) remoteClients(exclude ...uint64) []Client {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	var ret = make([]Client, 0, len(c.remote))
+for1:
+	for id, client := range c.remote {
+		for _, e := range exclude {
+			if e == id {
+				continue for1
+			}
+		}
+		ret = append(ret, client)
+	}
+	return ret
+}
+
+func (c *cluster) getRemote(id uint64) Client {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	if client, ok := c.remote[id]; ok {
+		return client
+	}
+	return nil
+}
+
+func (c *cluster) publishClient(kind clusterKind, client Client) {
+	_ = c.publish(&clusterMessage{Kind: kind, Clients: []uint64{client.GetId()}})
+}
+
+func (c *cluster) publishAuth(channel string, client Client, auth *Auth) {
+	var msg = &clusterMessage{Kind: clusterUnauth, Channel: channel, Clients: []uint64{client.GetId()}}
+	if auth != nil {
+		msg.Kind = clusterAuth
+		msg.Auth = auth
+	}
+	_ = c.publish(msg)
+}
+
+func (c *cluster) publishSubscription(kind clusterKind, channel string, client Client) {
+	_ = c.publish(&clusterMessage{Kind: kind, Channel: channel, Clients: []uint64{client.GetId()}})
+}
+
+func (c *cluster) publishRoom(kind clusterKind, channel, room string, public bool, client Client) {
+	var msg = &clusterMessage{Kind: kind, Channel: channel, Room: room, Public: public}
+	if client != nil {
+		msg.Clients = []uint64{client.GetId()}
+	}
+	_ = c.publish(msg)
+}
+
+type remoteBatch struct {
+	cluster *cluster
+	node    string
+	ids     []uint64
+}
+
+// splitRemoteClients separates the local clients from the remote ones, grouped by node
+func splitRemoteClients(clients []Client) ([]Client, map[string]*remoteBatch) {
+	var local = make([]Client, 0, len(clients))
+	var remote map[string]*remoteBatch
+	for _, c := range clients {
+		rc, ok := c.(*RemoteClient)
+		if !ok {
+			local = append(local, c)
+			continue
+		}
+		if remote == nil {
+			remote = map[string]*remoteBatch{}
+		}
+		batch, ok := remote[rc.node]
+		if !ok {
+			batch = &remoteBatch{cluster: rc.cluster, node: rc.node}
+			remote[rc.node] = batch
+		}
+		batch.ids = append(batch.ids, rc.id)
+	}
+	return local, remote
+}
+
+// clusterOf returns the cluster of the server the channel is bound to, if any
+func clusterOf(ch ImplChannel) *cluster {
+	if s, ok := ch.GetServer().(*ServerSocket); ok {
+		return s.cluster
+	}
+	return nil
+}
+
+var _ Client = (*RemoteClient)(nil)
+
+// RemoteClient is a client connected to another node of the cluster, events sent to it are relayed by the broker
+type RemoteClient struct {
+	ClientContextMap
+	id      uint64
+	node    string
+	cluster *cluster
+}
+
+func (c *RemoteClient) GetId() uint64 {
+	return c.id
+}
+
+// Node returns the id of the node the client is connected to
+func (c *RemoteClient) Node() string {
+	return c.node
+}
+
+func (c *RemoteClient) Send(channel string, event Event) error {
+	return c.Reply(channel, 0, event)
+}
+
+func (c *RemoteClient) Reply(channel string, requestId uint64, event Event) error {
+	if ecf, ok := event.(EventCheckSendFields); ok {
+		if err := ecf.CheckSendFields(); err != nil {
+			return err
+		}
+	}
+	return c.cluster.deliver(c.node, []uint64{c.id}, channel, requestId, event)
+}
+
+func (c *RemoteClient) SendJSON(interface{}) error {
+	return errors.New("raw messages cannot be sent to a remote client")
+}
+
+func (c *RemoteClient) Close() error {
+	return errors.New("a remote client cannot be closed")
+}
+
+func (c *RemoteClient) Closed() bool {
+	return false
+}
+
+// remoteEvent carries the generic body of an event relayed by another node
+type remoteEvent struct {
+	name string
+	body interface{}
+}
+
+func (e *remoteEvent) GetEventName() string {
+	return e.name
+}
+
+func (e *remoteEvent) ProtocolAlias() string {
+	return e.name
+}
+
+func (e *remoteEvent) CodecEncodeSelf(enc *codec.Encoder) {
+	enc.MustEncode(e.body)
+}
+
+func (e *remoteEvent) CodecDecodeSelf(dec *codec.Decoder) {
+	dec.MustDecode(&e.body)
+}
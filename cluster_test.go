@@ -0,0 +1,133 @@
+package eddwise
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type clusterTestChannel struct {
+	RoomManager
+	ChannelBroadcastUserJoinLeft
+	s Server
+}
+
+func (ch *clusterTestChannel) Connected(Client) error             { return nil }
+func (ch *clusterTestChannel) Disconnected(Client) error          { return nil }
+func (ch *clusterTestChannel) SetReceiver(ImplChannel) error      { return nil }
+func (ch *clusterTestChannel) Bind(s Server) error                { ch.s = s; return nil }
+func (ch *clusterTestChannel) GetServer() Server                  { return ch.s }
+func (ch *clusterTestChannel) Name() string                       { return "game" }
+func (ch *clusterTestChannel) Alias() string                      { return "game" }
+func (ch *clusterTestChannel) Route(Context, *EventMessage) error { return nil }
+
+type recorderClient struct {
+	ClientContextMap
+	id     uint64
+	mx     sync.Mutex
+	events []string
+}
+
+func (c *recorderClient) GetId() uint64 { return c.id }
+func (c *recorderClient) Send(channel string, event Event) error {
+	return c.Reply(channel, 0, event)
+}
+func (c *recorderClient) Reply(channel string, _ uint64, event Event) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.events = append(c.events, fmt.Sprintf("%s/%s", channel, event.ProtocolAlias()))
+	return nil
+}
+func (c *recorderClient) SendJSON(interface{}) error { return nil }
+func (c *recorderClient) Close() error               { return nil }
+func (c *recorderClient) Closed() bool               { return false }
+
+func (c *recorderClient) waitFor(t *testing.T, event string) {
+	t.Helper()
+	var deadline = time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		c.mx.Lock()
+		for _, e := range c.events {
+			if e == event {
+				c.mx.Unlock()
+				return
+			}
+		}
+		c.mx.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("client %d did not receive %s, got %v\n", c.id, event, c.events)
+}
+
+func newClusterTestNode(t *testing.T, broker Broker) (*ServerSocket, *clusterTestChannel) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var ch = &clusterTestChannel{}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	if err := s.SetBroker(broker); err != nil {
+		t.Fatalf("unable to set broker: %s\n", err)
+	}
+	return s, ch
+}
+
+func TestClusterRoomsAndPresence(t *testing.T) {
+	var broker = NewMemoryBroker()
+	defer func() { _ = broker.Close() }()
+
+	s1, ch1 := newClusterTestNode(t, broker)
+	s2, ch2 := newClusterTestNode(t, broker)
+
+	var a = &recorderClient{id: s1.ClientAutoInc + 1, ClientContextMap: ClientContextMap{m: map[string]interface{}{}}}
+	var b = &recorderClient{id: s2.ClientAutoInc + 1, ClientContextMap: ClientContextMap{m: map[string]interface{}{}}}
+	s1.AddClient(a)
+	s2.AddClient(b)
+	s1.addSubscriber(ch1.Alias(), a)
+	s2.addSubscriber(ch2.Alias(), b)
+
+	var deadline = time.Now().Add(time.Second)
+	for s1.GetClient(b.id) == nil || s2.GetClient(a.id) == nil || len(s1.GetChannelClients(ch1.Alias())) != 2 || len(s2.GetChannelClients(ch2.Alias())) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("clients and subscriptions were not replicated across nodes\n")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := ch2.onJoin(ch2, b, true); err != nil {
+		t.Fatalf("unexpected error on join: %s\n", err)
+	}
+	a.waitFor(t, "game/edd:user:join")
+
+	if err := ch1.OnRoomEvent(a, &RoomCreateRequest{Room: "lobby", Public: true}); err != nil {
+		t.Fatalf("unable to create room: %s\n", err)
+	}
+	b.waitFor(t, "game/edd:room:create")
+
+	deadline = time.Now().Add(time.Second)
+	for ch2.Room("lobby") == nil || len(ch2.Room("lobby").Clients()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("room was not replicated across nodes\n")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := ch2.OnRoomEvent(b, &RoomJoinRequest{Room: "lobby"}); err != nil {
+		t.Fatalf("unable to join room: %s\n", err)
+	}
+	a.waitFor(t, "game/edd:room:join")
+
+	if err := ch2.OnRoomEvent(b, &RoomLeftRequest{Room: "lobby"}); err != nil {
+		t.Fatalf("unable to leave room: %s\n", err)
+	}
+	a.waitFor(t, "game/edd:room:left")
+
+	s2.cluster.stop()
+	deadline = time.Now().Add(time.Second)
+	for s1.GetClient(b.id) != nil || len(s1.GetChannelClients(ch1.Alias())) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("clients of a leaving node were not dropped\n")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
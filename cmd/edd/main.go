@@ -105,6 +105,17 @@ func gen() {
 		log.Fatalln(err)
 	}
 
+	var lockPath = protoLockPath(design.Name)
+	lock, err := eddgen.ReadProtoLock(lockPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	design.ApplyProtoLock(lock)
+	fmt.Println(lockPath)
+	if err := lock.Write(lockPath); err != nil {
+		log.Fatalln("unable to write lock file:", err)
+	}
+
 	if genCodeServerPath != "-" {
 		{
 			var serverPath = genCodeServerPath + "/" + design.Name
@@ -123,6 +134,32 @@ func gen() {
 			}
 			_ = serverWriter.Close()
 		}
+		{
+			var fileName = genCodeServerPath + "/" + design.Name + "/proto.go"
+			fmt.Println(fileName)
+			protoWriter, err := os.Create(fileName)
+			if err != nil {
+				log.Fatalln("unable to write server protobuf file:", err)
+			}
+
+			if err := design.GenerateServerProto(protoWriter); err != nil {
+				log.Fatalln(err)
+			}
+			_ = protoWriter.Close()
+		}
+		{
+			var fileName = genCodeServerPath + "/" + design.Name + "/" + design.Name + ".proto"
+			fmt.Println(fileName)
+			schemaWriter, err := os.Create(fileName)
+			if err != nil {
+				log.Fatalln("unable to write protobuf schema:", err)
+			}
+
+			if err := design.GenerateProto(schemaWriter); err != nil {
+				log.Fatalln(err)
+			}
+			_ = schemaWriter.Close()
+		}
 		{
 			var serverPath = genCodeServerPath + "/" + design.Name + "/behave"
 			if err := os.MkdirAll(serverPath, os.ModePerm); err != nil && os.IsExist(err) {
@@ -162,6 +199,15 @@ func gen() {
 	}
 }
 
+// protoLockPath returns the lock file keeping the protobuf field numbers, it is saved beside the design
+func protoLockPath(name string) string {
+	var dir = designPath
+	if info, err := os.Stat(designPath); err == nil && !info.IsDir() {
+		dir = filepath.Dir(designPath)
+	}
+	return filepath.Join(dir, name+".edd.lock")
+}
+
 func getValidFilesInDesignPath() ([]string, error) {
 	var filesName []string
 	var err = filepath.Walk(designPath, func(path string, info fs.FileInfo, err error) error {
@@ -0,0 +1,269 @@
+package eddwise
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Subprotocols negotiated through Sec-WebSocket-Protocol, each one selects the codec of the connection
+const (
+	ProtocolJSON    = "edd.json"
+	ProtocolMsgPack = "edd.msgpack"
+	ProtocolCBOR    = "edd.cbor"
+)
+
+// protocolQueryKey selects the codec of the transports that cannot negotiate a subprotocol, such as the sse fallback
+const protocolQueryKey = "edd_protocol"
+
+// ProtocolConn is implemented by connections that negotiated a subprotocol, such as websockets
+type ProtocolConn interface {
+	Subprotocol() string
+}
+
+// Codec encodes the messages exchanged with the clients negotiating the subprotocol returned by Name.
+// Decoding an *EventMessage must leave its Body raw, in the codec format: it is decoded later with Decode,
+// once the channel knows the type of the event
+type Codec interface {
+	Name() string
+	FrameType() FrameType
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+var _ Codec = (*HandleCodec)(nil)
+
+// HandleCodec implements Codec with an ugorji handle, json handles write text frames, the others binary frames.
+// Encoders and decoders are pooled and reused across messages
+type HandleCodec struct {
+	name      string
+	handle    codec.Handle
+	frameType FrameType
+	encoders  sync.Pool
+	decoders  sync.Pool
+	// sizeHint is the size of the last encoded message, it sizes the buffer of the next one
+	sizeHint uint32
+}
+
+func NewHandleCodec(name string, handle codec.Handle) *HandleCodec {
+	var ft = BinaryFrame
+	if _, ok := handle.(*codec.JsonHandle); ok {
+		ft = TextFrame
+	}
+	return &HandleCodec{name: name, handle: handle, frameType: ft}
+}
+
+func (hc *HandleCodec) Name() string {
+	return hc.name
+}
+
+func (hc *HandleCodec) FrameType() FrameType {
+	return hc.frameType
+}
+
+// Encode returns a new buffer on each call, so that it can be queued while the encoder is reused
+func (hc *HandleCodec) Encode(v interface{}) ([]byte, error) {
+	var buf = make([]byte, 0, atomic.LoadUint32(&hc.sizeHint)+64)
+	enc, _ := hc.encoders.Get().(*codec.Encoder)
+	if enc == nil {
+		enc = codec.NewEncoderBytes(&buf, hc.handle)
+	} else {
+		enc.ResetBytes(&buf)
+	}
+	var err = enc.Encode(v)
+	hc.encoders.Put(enc)
+	atomic.StoreUint32(&hc.sizeHint, uint32(len(buf)))
+	return buf, err
+}
+
+func (hc *HandleCodec) Decode(data []byte, v interface{}) error {
+	if msg, ok := v.(*EventMessage); ok && msg.newEvent != nil {
+		var env = &envelope{}
+		env.Body.env = env
+		env.Body.newEvent = msg.newEvent
+		if err := hc.decode(data, env); err != nil {
+			return err
+		}
+		msg.Channel, msg.Name, msg.Id = env.Channel, env.Name, env.Id
+		msg.Body, msg.event = env.Body.raw, env.Body.event
+		return nil
+	}
+	return hc.decode(data, v)
+}
+
+func (hc *HandleCodec) decode(data []byte, v interface{}) error {
+	dec, _ := hc.decoders.Get().(*codec.Decoder)
+	if dec == nil {
+		dec = codec.NewDecoderBytes(data, hc.handle)
+	} else {
+		dec.ResetBytes(data)
+	}
+	var err = dec.Decode(v)
+	// do not retain the frame in the pool
+	dec.ResetBytes(nil)
+	hc.decoders.Put(dec)
+	return err
+}
+
+func NewJSONCodec() *HandleCodec {
+	return NewHandleCodec(ProtocolJSON, &codec.JsonHandle{})
+}
+
+// NewMsgPackCodec decodes the untyped maps as map[string]interface{} and writes the binary and string types
+// of the current msgpack spec
+func NewMsgPackCodec() *HandleCodec {
+	var h = &codec.MsgpackHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.RawToString = true
+	h.WriteExt = true
+	return NewHandleCodec(ProtocolMsgPack, h)
+}
+
+// NewCBORCodec decodes the untyped maps as map[string]interface{}
+func NewCBORCodec() *HandleCodec {
+	var h = &codec.CborHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return NewHandleCodec(ProtocolCBOR, h)
+}
+
+// envelope decodes an EventMessage in a single pass, see envelopeBody
+type envelope struct {
+	Channel string       `json:"channel"`
+	Name    string       `json:"name"`
+	Id      uint64       `json:"id,omitempty"`
+	Body    envelopeBody `json:"body"`
+}
+
+// envelopeBody decodes the body straight into the event resolved from the channel and the name decoded before it,
+// the body is kept raw when the event is unknown or when the body comes first
+type envelopeBody struct {
+	env      *envelope
+	newEvent func(channel, name string) interface{}
+	event    interface{}
+	raw      codec.Raw
+}
+
+func (b *envelopeBody) CodecEncodeSelf(e *codec.Encoder) {
+	if b.event != nil {
+		e.MustEncode(b.event)
+		return
+	}
+	e.MustEncode(b.raw)
+}
+
+func (b *envelopeBody) CodecDecodeSelf(d *codec.Decoder) {
+	if len(b.env.Channel) > 0 && len(b.env.Name) > 0 {
+		if b.event = b.newEvent(b.env.Channel, b.env.Name); b.event != nil {
+			d.MustDecode(b.event)
+			return
+		}
+	}
+	d.MustDecode(&b.raw)
+}
+
+// handleProtocol names the codec of a bare handle after the protocol using the same format
+func handleProtocol(handle codec.Handle) string {
+	switch handle.(type) {
+	case *codec.JsonHandle:
+		return ProtocolJSON
+	case *codec.MsgpackHandle:
+		return ProtocolMsgPack
+	case *codec.CborHandle:
+		return ProtocolCBOR
+	default:
+		return ""
+	}
+}
+
+func defaultCodecs() map[string]Codec {
+	var ret = map[string]Codec{}
+	for _, c := range []Codec{NewJSONCodec(), NewMsgPackCodec(), NewCBORCodec(), NewProtobufCodec()} {
+		ret[c.Name()] = c
+	}
+	return ret
+}
+
+// SetCodec registers the codec used by the connections negotiating its name as subprotocol, it replaces the
+// default one of edd.json, edd.msgpack, edd.cbor and edd.protobuf
+func (s *ServerSocket) SetCodec(c Codec) {
+	s.codecs[c.Name()] = c
+}
+
+// codecOf returns the codec of a subprotocol, an empty protocol selects the default codec of the server
+func (s *ServerSocket) codecOf(protocol string) (Codec, bool) {
+	if len(protocol) == 0 {
+		return s.codec, true
+	}
+	cs, ok := s.codecs[protocol]
+	return cs, ok
+}
+
+// selectProtocol returns the first protocol requested by the client having a codec
+func (s *ServerSocket) selectProtocol(requested []string) string {
+	for _, protocol := range requested {
+		if _, ok := s.codecs[protocol]; ok {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// parseProtocols splits the value of a Sec-WebSocket-Protocol header
+func parseProtocols(header string) []string {
+	var ret []string
+	for _, protocol := range strings.Split(header, ",") {
+		if protocol = strings.TrimSpace(protocol); len(protocol) > 0 {
+			ret = append(ret, protocol)
+		}
+	}
+	return ret
+}
+
+// connCodec picks the codec negotiated by the connection or requested in the query
+func (s *ServerSocket) connCodec(c Conn, query url.Values) Codec {
+	var protocol = query.Get(protocolQueryKey)
+	if pc, ok := c.(ProtocolConn); ok && len(pc.Subprotocol()) > 0 {
+		protocol = pc.Subprotocol()
+	}
+	cs, ok := s.codecOf(protocol)
+	if !ok {
+		s.logger.Warn("unknown protocol, using the default codec", "protocol", protocol, "remote_addr", c.RemoteAddr())
+		return s.codec
+	}
+	return cs
+}
+
+// encodeOnce encodes a message once per codec, it is shared by the recipients of a broadcast
+type encodeOnce struct {
+	msg    *EventMessageToSend
+	mx     sync.Mutex
+	frames map[Codec]*encodedFrame
+}
+
+type encodedFrame struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+func newEncodeOnce(msg *EventMessageToSend) *encodeOnce {
+	return &encodeOnce{msg: msg, frames: map[Codec]*encodedFrame{}}
+}
+
+func (e *encodeOnce) encode(cs Codec) ([]byte, error) {
+	e.mx.Lock()
+	var f, ok = e.frames[cs]
+	if !ok {
+		f = &encodedFrame{}
+		e.frames[cs] = f
+	}
+	e.mx.Unlock()
+	f.once.Do(func() {
+		f.data, f.err = cs.Encode(e.msg)
+	})
+	return f.data, f.err
+}
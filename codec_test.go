@@ -0,0 +1,366 @@
+package eddwise
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fasthttp/websocket"
+)
+
+func TestCodecNegotiation(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	if err := s.Register(&TestChannel{}); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var srv = httptest.NewServer(s.Handler())
+	defer srv.Close()
+	defer func() { _ = s.Close(0) }()
+	var url = "ws" + strings.TrimPrefix(srv.URL, "http") + "/test"
+
+	var protocols = []string{ProtocolJSON, ProtocolMsgPack, ProtocolCBOR, ProtocolProtobuf}
+	var conns = make([]*websocket.Conn, len(protocols))
+	for i, protocol := range protocols {
+		var dialer = websocket.Dialer{Subprotocols: []string{"unknown", protocol}}
+		conn, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("unable to dial with %s: %s\n", protocol, err)
+		}
+		defer func() { _ = conn.Close() }()
+		if conn.Subprotocol() != protocol {
+			t.Fatalf("unexpected negotiated protocol %q, expecting %q\n", conn.Subprotocol(), protocol)
+		}
+		conns[i] = conn
+	}
+
+	for i, conn := range conns {
+		var cs, _ = s.codecOf(protocols[i])
+		for _, msg := range []EventMessageToSend{
+			{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: "test"}},
+			{Channel: "test", Name: "testRequest", Body: "important message"},
+		} {
+			request, err := cs.Encode(msg)
+			if err != nil {
+				t.Fatalf("unable to encode request: %s\n", err)
+			}
+			if err := conn.WriteMessage(int(cs.FrameType()), request); err != nil {
+				t.Fatalf("unable to send request: %s\n", err)
+			}
+		}
+		// skip the subscribe confirmation
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatalf("unable to read subscribe response: %s\n", err)
+		}
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("unable to read response: %s\n", err)
+		}
+		var response = EventMessage{}
+		if err := cs.Decode(data, &response); err != nil || FrameType(mt) != cs.FrameType() {
+			t.Fatalf("unable to decode %s response: %v\n", protocols[i], err)
+		}
+		var body string
+		if err := cs.Decode(response.Body, &body); err != nil || response.Name != "testResponse" || body != "B" {
+			t.Fatalf("unexpected %s response %s %q %v\n", protocols[i], response.Name, body, err)
+		}
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		codec     Codec
+		name      string
+		frameType FrameType
+		// header tells whether the first byte of a frame starts a map in the encoding of the codec
+		header func(b byte) bool
+	}{
+		{NewJSONCodec(), ProtocolJSON, TextFrame, func(b byte) bool { return b == '{' }},
+		{NewMsgPackCodec(), ProtocolMsgPack, BinaryFrame, func(b byte) bool { return b&0xf0 == 0x80 || b == 0xde || b == 0xdf }},
+		{NewCBORCodec(), ProtocolCBOR, BinaryFrame, func(b byte) bool { return b>>5 == 5 }},
+	} {
+		if tc.codec.Name() != tc.name || tc.codec.FrameType() != tc.frameType {
+			t.Fatalf("unexpected codec %s with frame type %d, expecting %s with %d\n", tc.codec.Name(), tc.codec.FrameType(), tc.name, tc.frameType)
+		}
+		var body = &benchEvent{Id: 42, Name: "room update", Scores: []int{1, -2, 3}, Tags: []string{"a"}}
+		data, err := tc.codec.Encode(&EventMessageToSend{Channel: "test", Name: "bench", Id: 7, Body: body})
+		if err != nil {
+			t.Fatalf("unable to encode with %s: %s\n", tc.name, err)
+		}
+		if len(data) == 0 || !tc.header(data[0]) {
+			t.Fatalf("unexpected %s frame %x\n", tc.name, data)
+		}
+
+		// the body is kept raw by the envelope, then decoded by the codec into the type of the event
+		var event = &EventMessage{codec: tc.codec}
+		if err := tc.codec.Decode(data, event); err != nil {
+			t.Fatalf("unable to decode with %s: %s\n", tc.name, err)
+		}
+		if event.Channel != "test" || event.Name != "bench" || event.Id != 7 || len(event.Body) == 0 {
+			t.Fatalf("unexpected %s envelope %+v\n", tc.name, event)
+		}
+		var decoded = &benchEvent{}
+		if err := event.DecodeBody(decoded); err != nil || !reflect.DeepEqual(decoded, body) {
+			t.Fatalf("unexpected %s body %+v, expecting %+v: %v\n", tc.name, decoded, body, err)
+		}
+		var wrong []string
+		if err := event.DecodeBody(&wrong); err == nil {
+			t.Fatalf("expecting an error decoding the %s body into another type\n", tc.name)
+		}
+	}
+}
+
+// countingCodec is a json codec under another name counting the frames it decodes
+type countingCodec struct {
+	*HandleCodec
+	decoded int
+}
+
+func (c *countingCodec) Name() string {
+	return "edd.counting"
+}
+
+func (c *countingCodec) Decode(data []byte, v interface{}) error {
+	c.decoded++
+	return c.HandleCodec.Decode(data, v)
+}
+
+func TestCustomCodec(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var cs = &countingCodec{HandleCodec: NewJSONCodec()}
+	s.SetCodec(cs)
+	if err := s.Register(&TestChannel{}); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	if protocol := s.selectProtocol([]string{"unknown", cs.Name(), ProtocolJSON}); protocol != cs.Name() {
+		t.Fatalf("unexpected selected protocol %q, expecting %q\n", protocol, cs.Name())
+	}
+
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(serverConn, url.Values{protocolQueryKey: {cs.Name()}})
+	defer func() { _ = conn.Close() }()
+	subscribeJSON(t, conn, "test")
+	sendJSON(t, conn, EventMessageToSend{Channel: "test", Name: "testRequest", Body: "important message"})
+	expectJSON(t, conn, "test", "testResponse")
+	var client = s.GetClients()[0].(*ClientSocket)
+	if client.Codec() != Codec(cs) || cs.decoded == 0 {
+		t.Fatalf("the connection did not use the codec it requested\n")
+	}
+}
+
+func TestBroadcastEncodeOnce(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var codecs = map[Codec]int{}
+	s.UseOutbound(func(client Client, msg *EventMessageToSend, next SendHandler) error {
+		return next(client, msg)
+	})
+	var clients []Client
+	var pipes []*PipeConn
+	for _, protocol := range []string{ProtocolJSON, ProtocolJSON, ProtocolMsgPack} {
+		var cs, _ = s.codecOf(protocol)
+		codecs[cs]++
+		serverConn, conn := NewPipe()
+		defer func() { _ = conn.Close() }()
+		var c = &ClientSocket{Server: s, Conn: serverConn, codec: cs, queue: newOutboundQueue(8)}
+		c.attach()
+		clients = append(clients, c)
+		pipes = append(pipes, conn)
+	}
+	var shared = newEncodeOnce(&EventMessageToSend{Channel: "test", Name: "testResponse", Body: TestResponse("B")})
+	for _, c := range clients {
+		if err := c.(*ClientSocket).broadcast(shared); err != nil {
+			t.Fatalf("unable to broadcast: %s\n", err)
+		}
+	}
+	if len(shared.frames) != len(codecs) {
+		t.Fatalf("expecting one encoding per codec, got %d\n", len(shared.frames))
+	}
+	for i, conn := range pipes {
+		ft, _, err := conn.ReadFrame()
+		if err != nil || ft != clients[i].(*ClientSocket).codec.FrameType() {
+			t.Fatalf("unexpected frame %d %v\n", ft, err)
+		}
+	}
+}
+
+// discardConn drops the written frames, the reads block until it is closed
+type discardConn struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (dc *discardConn) ReadFrame() (FrameType, []byte, error) {
+	<-dc.closed
+	return 0, nil, io.EOF
+}
+
+func (dc *discardConn) WriteFrame(FrameType, []byte) error {
+	return nil
+}
+
+func (dc *discardConn) Close() error {
+	dc.once.Do(func() { close(dc.closed) })
+	return nil
+}
+
+func (dc *discardConn) RemoteAddr() string {
+	return "discard"
+}
+
+type benchEvent struct {
+	Id     uint64   `json:"id"`
+	Name   string   `json:"name"`
+	Scores []int    `json:"scores"`
+	Tags   []string `json:"tags"`
+}
+
+func (*benchEvent) GetEventName() string {
+	return "bench"
+}
+
+func (*benchEvent) ProtocolAlias() string {
+	return "bench"
+}
+
+func benchClients(b *testing.B, s *ServerSocket, n int) []Client {
+	var clients = make([]Client, n)
+	for i := range clients {
+		var c = &ClientSocket{Server: s, Conn: &discardConn{closed: make(chan struct{})}, codec: s.codec, queue: newOutboundQueue(1024)}
+		c.attach()
+		clients[i] = c
+	}
+	b.Cleanup(func() {
+		for _, c := range clients {
+			_ = c.(*ClientSocket).Close()
+		}
+	})
+	return clients
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	var event = &benchEvent{Id: 42, Name: "room update", Scores: []int{1, 2, 3, 4, 5, 6, 7, 8}, Tags: []string{"a", "b", "c"}}
+	for _, n := range []int{100, 5000} {
+		var s = NewServer()
+		s.SetLogger(NopLogger{})
+		s.SetOutboundQueue(1024, DropOldest)
+		var clients = benchClients(b, s, n)
+		b.Run(fmt.Sprintf("shared/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := Broadcast("test", event, clients); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("per_client/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, c := range clients {
+					if err := c.Send("test", event); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCodecEncode(b *testing.B) {
+	var msg = &EventMessageToSend{Channel: "test", Name: "bench", Body: &benchEvent{Id: 42, Name: "room update", Scores: []int{1, 2, 3}}}
+	for _, cs := range []Codec{NewJSONCodec(), NewMsgPackCodec(), NewCBORCodec()} {
+		b.Run(cs.Name(), func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := cs.Encode(msg); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// typedChannel knows the type of its events, they are decoded along with the envelope
+type typedChannel struct {
+	TestChannel
+	created  *testProto
+	received *testProto
+}
+
+func (ch *typedChannel) Name() string {
+	return "typed"
+}
+
+func (ch *typedChannel) Alias() string {
+	return "typed"
+}
+
+func (ch *typedChannel) SetReceiver(ImplChannel) error {
+	return nil
+}
+
+func (ch *typedChannel) NewEvent(name string) Event {
+	if name != "proto" {
+		return nil
+	}
+	ch.created = &testProto{}
+	return ch.created
+}
+
+func (ch *typedChannel) Route(_ Context, event *EventMessage) error {
+	msg, err := DecodeEvent(event, func() *testProto { return &testProto{} })
+	ch.received = msg
+	return err
+}
+
+func TestSinglePassDecoding(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var ch = &typedChannel{}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	for _, protocol := range []string{ProtocolJSON, ProtocolMsgPack, ProtocolCBOR, ProtocolProtobuf} {
+		ch.created, ch.received = nil, nil
+		var cs, _ = s.codecOf(protocol)
+		var client = &ClientSocket{Server: s, codec: cs}
+		s.addSubscriber(ch.Alias(), client)
+		var ctx = NewDefaultContextFromBackground(s, client)
+		data, err := cs.Encode(EventMessageToSend{Channel: "typed", Name: "proto", Body: &testProto{Id: 7, Score: -3}})
+		if err != nil {
+			t.Fatalf("unable to encode with %s: %s\n", protocol, err)
+		}
+		if err := s.ProcessEvent(ctx, data); err != nil {
+			t.Fatalf("unable to process %s event: %s\n", protocol, err)
+		}
+		if ch.created == nil || ch.received != ch.created || ch.received.Id != 7 || ch.received.Score != -3 {
+			t.Fatalf("%s event not decoded along with the envelope: %+v\n", protocol, ch.received)
+		}
+	}
+
+	// the body comes before the name, it is decoded once the event is routed
+	ch.created, ch.received = nil, nil
+	var client = &ClientSocket{Server: s, codec: s.codec}
+	s.addSubscriber(ch.Alias(), client)
+	var ctx = NewDefaultContextFromBackground(s, client)
+	if err := s.ProcessEvent(ctx, []byte(`{"body":{"Id":7,"Score":-3},"channel":"typed","name":"proto"}`)); err != nil {
+		t.Fatalf("unable to process event: %s\n", err)
+	}
+	if ch.received == nil || ch.received == ch.created || ch.received.Id != 7 {
+		t.Fatalf("unexpected decoding of a leading body: %+v\n", ch.received)
+	}
+
+	// a malformed body is reported by the channel, along with the request id
+	var err = s.ProcessEvent(ctx, []byte(`{"channel":"typed","name":"proto","id":3,"body":{"Id":"x"}}`))
+	if err == nil || AsError(err).RequestId != 3 {
+		t.Fatalf("expecting an error for request 3, got %v\n", err)
+	}
+}
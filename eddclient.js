@@ -1,8 +1,9 @@
 /**
- * @typedef {{encode(any): any, decode(any): any}} EddCodec
+ * @typedef {{protocol: string|undefined, encode(any): any, decode(any): any}} EddCodec - protocol is the websocket subprotocol selecting the codec on the server
  */
 
 const EddCodecJson = {
+    protocol: "edd.json",
     encode(obj) {
         return JSON.stringify(obj)
     },
@@ -11,12 +12,837 @@ const EddCodecJson = {
     }
 }
 
+class EddBinWriter {
+    constructor() {
+        this.buf = new Uint8Array(128)
+        this.view = new DataView(this.buf.buffer)
+        this.len = 0
+    }
+
+    _grow(n) {
+        if(this.len + n <= this.buf.length) {
+            return
+        }
+        const buf = new Uint8Array(Math.max(this.buf.length * 2, this.len + n))
+        buf.set(this.buf)
+        this.buf = buf
+        this.view = new DataView(buf.buffer)
+    }
+
+    u8(v) {
+        this._grow(1)
+        this.view.setUint8(this.len, v)
+        this.len += 1
+    }
+
+    u16(v) {
+        this._grow(2)
+        this.view.setUint16(this.len, v)
+        this.len += 2
+    }
+
+    u32(v) {
+        this._grow(4)
+        this.view.setUint32(this.len, v)
+        this.len += 4
+    }
+
+    u64(v) {
+        this.u32(Math.floor(v / 4294967296))
+        this.u32(v >>> 0)
+    }
+
+    f64(v) {
+        this._grow(8)
+        this.view.setFloat64(this.len, v)
+        this.len += 8
+    }
+
+    bytes(b) {
+        this._grow(b.length)
+        this.buf.set(b, this.len)
+        this.len += b.length
+    }
+
+    result() {
+        return this.buf.slice(0, this.len)
+    }
+}
+
+class EddBinReader {
+    constructor(data) {
+        this.buf = data instanceof Uint8Array ? data : new Uint8Array(data)
+        this.view = new DataView(this.buf.buffer, this.buf.byteOffset, this.buf.byteLength)
+        this.pos = 0
+    }
+
+    u8() {
+        return this.view.getUint8(this.pos++)
+    }
+
+    u16() {
+        const v = this.view.getUint16(this.pos)
+        this.pos += 2
+        return v
+    }
+
+    u32() {
+        const v = this.view.getUint32(this.pos)
+        this.pos += 4
+        return v
+    }
+
+    u64() {
+        return this.u32() * 4294967296 + this.u32()
+    }
+
+    i64() {
+        const hi = this.view.getInt32(this.pos)
+        const lo = this.view.getUint32(this.pos + 4)
+        this.pos += 8
+        return hi * 4294967296 + lo
+    }
+
+    f16() {
+        const h = this.u16()
+        const exp = (h & 0x7c00) >> 10
+        const frac = h & 0x03ff
+        const sign = h & 0x8000 ? -1 : 1
+        if(exp === 0) {
+            return sign * Math.pow(2, -14) * (frac / 1024)
+        }
+        if(exp === 0x1f) {
+            return frac ? NaN : sign * Infinity
+        }
+        return sign * Math.pow(2, exp - 15) * (1 + frac / 1024)
+    }
+
+    f32() {
+        const v = this.view.getFloat32(this.pos)
+        this.pos += 4
+        return v
+    }
+
+    f64() {
+        const v = this.view.getFloat64(this.pos)
+        this.pos += 8
+        return v
+    }
+
+    bytes(n) {
+        const v = this.buf.slice(this.pos, this.pos + n)
+        this.pos += n
+        return v
+    }
+
+    str(n) {
+        return new TextDecoder().decode(this.bytes(n))
+    }
+}
+
+function eddBinaryOf(v) {
+    if(v instanceof Uint8Array) {
+        return v
+    }
+    if(v instanceof ArrayBuffer) {
+        return new Uint8Array(v)
+    }
+    return null
+}
+
+function eddEntries(obj) {
+    return Object.keys(obj).filter((k) => obj[k] !== undefined && typeof obj[k] !== "function")
+}
+
+/**
+ * MessagePack codec, binary values are decoded as Uint8Array
+ * @type {EddCodec}
+ */
+const EddCodecMsgPack = {
+    protocol: "edd.msgpack",
+    encode(obj) {
+        const w = new EddBinWriter()
+        this._encode(w, obj)
+        return w.result()
+    },
+    _encode(w, v) {
+        if(v === null || v === undefined) {
+            w.u8(0xc0)
+        } else if(typeof v === "boolean") {
+            w.u8(v ? 0xc3 : 0xc2)
+        } else if(typeof v === "number" || typeof v === "bigint") {
+            this._encodeNumber(w, Number(v))
+        } else if(typeof v === "string") {
+            const b = new TextEncoder().encode(v)
+            if(b.length < 32) {
+                w.u8(0xa0 | b.length)
+            } else if(b.length < 0x100) {
+                w.u8(0xd9)
+                w.u8(b.length)
+            } else if(b.length < 0x10000) {
+                w.u8(0xda)
+                w.u16(b.length)
+            } else {
+                w.u8(0xdb)
+                w.u32(b.length)
+            }
+            w.bytes(b)
+        } else if(eddBinaryOf(v)) {
+            const b = eddBinaryOf(v)
+            if(b.length < 0x100) {
+                w.u8(0xc4)
+                w.u8(b.length)
+            } else if(b.length < 0x10000) {
+                w.u8(0xc5)
+                w.u16(b.length)
+            } else {
+                w.u8(0xc6)
+                w.u32(b.length)
+            }
+            w.bytes(b)
+        } else if(Array.isArray(v)) {
+            this._header(w, v.length, 0x90, 0xdc)
+            v.forEach((item) => this._encode(w, item))
+        } else if(typeof v.toJSON === "function") {
+            this._encode(w, v.toJSON())
+        } else {
+            const keys = eddEntries(v)
+            this._header(w, keys.length, 0x80, 0xde)
+            keys.forEach((k) => {
+                this._encode(w, k)
+                this._encode(w, v[k])
+            })
+        }
+    },
+    _header(w, n, fix, code16) {
+        if(n < 16) {
+            w.u8(fix | n)
+        } else if(n < 0x10000) {
+            w.u8(code16)
+            w.u16(n)
+        } else {
+            w.u8(code16 + 1)
+            w.u32(n)
+        }
+    },
+    _encodeNumber(w, n) {
+        if(!Number.isSafeInteger(n)) {
+            w.u8(0xcb)
+            w.f64(n)
+        } else if(n >= 0) {
+            if(n < 128) {
+                w.u8(n)
+            } else if(n < 0x100) {
+                w.u8(0xcc)
+                w.u8(n)
+            } else if(n < 0x10000) {
+                w.u8(0xcd)
+                w.u16(n)
+            } else if(n < 0x100000000) {
+                w.u8(0xce)
+                w.u32(n)
+            } else {
+                w.u8(0xcf)
+                w.u64(n)
+            }
+        } else {
+            if(n >= -32) {
+                w.u8(n & 0xff)
+            } else if(n >= -0x80) {
+                w.u8(0xd0)
+                w.u8(n & 0xff)
+            } else if(n >= -0x8000) {
+                w.u8(0xd1)
+                w.u16(n & 0xffff)
+            } else if(n >= -0x80000000) {
+                w.u8(0xd2)
+                w.u32(n >>> 0)
+            } else {
+                w.u8(0xd3)
+                w.u32(Math.floor(n / 4294967296) >>> 0)
+                w.u32(n >>> 0)
+            }
+        }
+    },
+    decode(msg) {
+        return this._decode(new EddBinReader(msg))
+    },
+    _decode(r) {
+        const b = r.u8()
+        if(b < 0x80) {
+            return b
+        }
+        if(b >= 0xe0) {
+            return b - 0x100
+        }
+        if(b < 0x90) {
+            return this._map(r, b & 0x0f)
+        }
+        if(b < 0xa0) {
+            return this._array(r, b & 0x0f)
+        }
+        if(b < 0xc0) {
+            return r.str(b & 0x1f)
+        }
+        switch (b) {
+            case 0xc0: return null
+            case 0xc2: return false
+            case 0xc3: return true
+            case 0xc4: return r.bytes(r.u8())
+            case 0xc5: return r.bytes(r.u16())
+            case 0xc6: return r.bytes(r.u32())
+            case 0xc7: return this._ext(r, r.u8())
+            case 0xc8: return this._ext(r, r.u16())
+            case 0xc9: return this._ext(r, r.u32())
+            case 0xca: return r.f32()
+            case 0xcb: return r.f64()
+            case 0xcc: return r.u8()
+            case 0xcd: return r.u16()
+            case 0xce: return r.u32()
+            case 0xcf: return r.u64()
+            case 0xd0: return r.view.getInt8(r.pos++)
+            case 0xd1: { const v = r.view.getInt16(r.pos); r.pos += 2; return v }
+            case 0xd2: { const v = r.view.getInt32(r.pos); r.pos += 4; return v }
+            case 0xd3: return r.i64()
+            case 0xd4: return this._ext(r, 1)
+            case 0xd5: return this._ext(r, 2)
+            case 0xd6: return this._ext(r, 4)
+            case 0xd7: return this._ext(r, 8)
+            case 0xd8: return this._ext(r, 16)
+            case 0xd9: return r.str(r.u8())
+            case 0xda: return r.str(r.u16())
+            case 0xdb: return r.str(r.u32())
+            case 0xdc: return this._array(r, r.u16())
+            case 0xdd: return this._array(r, r.u32())
+            case 0xde: return this._map(r, r.u16())
+            case 0xdf: return this._map(r, r.u32())
+            default: throw new Error("msgpack: unexpected byte 0x" + b.toString(16))
+        }
+    },
+    _array(r, n) {
+        const ret = new Array(n)
+        for (let i = 0; i < n; i++) {
+            ret[i] = this._decode(r)
+        }
+        return ret
+    },
+    _map(r, n) {
+        const ret = {}
+        for (let i = 0; i < n; i++) {
+            const k = this._decode(r)
+            ret[k] = this._decode(r)
+        }
+        return ret
+    },
+    _ext(r, n) {
+        const type = r.view.getInt8(r.pos++)
+        return {type: type, data: r.bytes(n)}
+    }
+}
+
+/**
+ * CBOR codec, binary values are decoded as Uint8Array and tags are ignored
+ * @type {EddCodec}
+ */
+const EddCodecCbor = {
+    protocol: "edd.cbor",
+    encode(obj) {
+        const w = new EddBinWriter()
+        this._encode(w, obj)
+        return w.result()
+    },
+    _header(w, major, n) {
+        major <<= 5
+        if(n < 24) {
+            w.u8(major | n)
+        } else if(n < 0x100) {
+            w.u8(major | 24)
+            w.u8(n)
+        } else if(n < 0x10000) {
+            w.u8(major | 25)
+            w.u16(n)
+        } else if(n < 0x100000000) {
+            w.u8(major | 26)
+            w.u32(n)
+        } else {
+            w.u8(major | 27)
+            w.u64(n)
+        }
+    },
+    _encode(w, v) {
+        if(v === null || v === undefined) {
+            w.u8(0xf6)
+        } else if(typeof v === "boolean") {
+            w.u8(v ? 0xf5 : 0xf4)
+        } else if(typeof v === "number" || typeof v === "bigint") {
+            const n = Number(v)
+            if(!Number.isSafeInteger(n)) {
+                w.u8(0xfb)
+                w.f64(n)
+            } else if(n >= 0) {
+                this._header(w, 0, n)
+            } else {
+                this._header(w, 1, -1 - n)
+            }
+        } else if(typeof v === "string") {
+            const b = new TextEncoder().encode(v)
+            this._header(w, 3, b.length)
+            w.bytes(b)
+        } else if(eddBinaryOf(v)) {
+            const b = eddBinaryOf(v)
+            this._header(w, 2, b.length)
+            w.bytes(b)
+        } else if(Array.isArray(v)) {
+            this._header(w, 4, v.length)
+            v.forEach((item) => this._encode(w, item))
+        } else if(typeof v.toJSON === "function") {
+            this._encode(w, v.toJSON())
+        } else {
+            const keys = eddEntries(v)
+            this._header(w, 5, keys.length)
+            keys.forEach((k) => {
+                this._encode(w, k)
+                this._encode(w, v[k])
+            })
+        }
+    },
+    decode(msg) {
+        return this._decode(new EddBinReader(msg))
+    },
+    _length(r, info) {
+        if(info < 24) {
+            return info
+        }
+        switch (info) {
+            case 24: return r.u8()
+            case 25: return r.u16()
+            case 26: return r.u32()
+            case 27: return r.u64()
+            case 31: return -1
+            default: throw new Error("cbor: unexpected additional info " + info)
+        }
+    },
+    _decode(r) {
+        const b = r.u8()
+        const major = b >> 5
+        const info = b & 0x1f
+        if(major === 7) {
+            switch (info) {
+                case 20: return false
+                case 21: return true
+                case 22: return null
+                case 23: return undefined
+                case 25: return r.f16()
+                case 26: return r.f32()
+                case 27: return r.f64()
+                default: throw new Error("cbor: unexpected simple value " + info)
+            }
+        }
+        const n = this._length(r, info)
+        switch (major) {
+            case 0: return n
+            case 1: return -1 - n
+            case 2:
+            case 3: {
+                let bytes
+                if(n >= 0) {
+                    bytes = r.bytes(n)
+                } else {
+                    // indefinite length, a sequence of definite chunks ended by a break
+                    const chunks = []
+                    while (r.buf[r.pos] !== 0xff) {
+                        const c = r.u8()
+                        chunks.push(r.bytes(this._length(r, c & 0x1f)))
+                    }
+                    r.pos++
+                    bytes = new Uint8Array(chunks.reduce((l, c) => l + c.length, 0))
+                    chunks.reduce((off, c) => { bytes.set(c, off); return off + c.length }, 0)
+                }
+                return major === 2 ? bytes : new TextDecoder().decode(bytes)
+            }
+            case 4: {
+                const ret = []
+                for (let i = 0; n < 0 ? r.buf[r.pos] !== 0xff : i < n; i++) {
+                    ret.push(this._decode(r))
+                }
+                if(n < 0) {
+                    r.pos++
+                }
+                return ret
+            }
+            case 5: {
+                const ret = {}
+                for (let i = 0; n < 0 ? r.buf[r.pos] !== 0xff : i < n; i++) {
+                    const k = this._decode(r)
+                    ret[k] = this._decode(r)
+                }
+                if(n < 0) {
+                    r.pos++
+                }
+                return ret
+            }
+            case 6:
+                return this._decode(r)
+        }
+    }
+}
+
+class EddProtoWriter extends EddBinWriter {
+    tag(num, type) {
+        this.varint(num * 8 + type)
+    }
+
+    varint(v) {
+        v = Number(v)
+        while(v >= 0x80) {
+            this.u8(v % 0x80 | 0x80)
+            v = Math.floor(v / 0x80)
+        }
+        this.u8(v)
+    }
+
+    zigzag(v) {
+        v = Number(v)
+        this.varint(v >= 0 ? v * 2 : -v * 2 - 1)
+    }
+
+    bool(v) {
+        this.u8(v ? 1 : 0)
+    }
+
+    float(v) {
+        this._grow(4)
+        this.view.setFloat32(this.len, v, true)
+        this.len += 4
+    }
+
+    double(v) {
+        this._grow(8)
+        this.view.setFloat64(this.len, v, true)
+        this.len += 8
+    }
+
+    bytes(b) {
+        this.varint(b.length)
+        super.bytes(b)
+    }
+
+    string(v) {
+        this.bytes(new TextEncoder().encode(v))
+    }
+}
+
+class EddProtoReader extends EddBinReader {
+    /**
+     * @return {{num: number, type: number}|null} the next field, null at the end of the message
+     */
+    next() {
+        if(this.pos >= this.buf.length) {
+            return null
+        }
+        const tag = this.varint()
+        return {num: Math.floor(tag / 8), type: tag % 8}
+    }
+
+    varint() {
+        let v = 0, mul = 1, c
+        do {
+            c = this.u8()
+            v += (c & 0x7f) * mul
+            mul *= 0x80
+        } while(c >= 0x80)
+        return v
+    }
+
+    zigzag() {
+        const v = this.varint()
+        return v % 2 ? -(v + 1) / 2 : v / 2
+    }
+
+    bool() {
+        return this.varint() !== 0
+    }
+
+    float() {
+        const v = this.view.getFloat32(this.pos, true)
+        this.pos += 4
+        return v
+    }
+
+    double() {
+        const v = this.view.getFloat64(this.pos, true)
+        this.pos += 8
+        return v
+    }
+
+    bytes(n) {
+        return super.bytes(n === undefined ? this.varint() : n)
+    }
+
+    string() {
+        return new TextDecoder().decode(this.bytes())
+    }
+
+    skip(type) {
+        switch(type) {
+            case 0:
+                this.varint()
+                break
+            case 1:
+                this.pos += 8
+                break
+            case 2:
+                this.bytes()
+                break
+            case 5:
+                this.pos += 4
+                break
+            default:
+                throw "unsupported protobuf wire type " + type
+        }
+    }
+
+    packed(type, read) {
+        if(type !== 2) {
+            read(this)
+            return
+        }
+        const r = new EddProtoReader(this.bytes())
+        while(r.pos < r.buf.length) {
+            read(r)
+        }
+    }
+}
+
+/**
+ * Protobuf codec, the bodies of the events registered on it are protobuf encoded, the others, such as the system
+ * events, are sent as json. Each codec keeps its own events, the generated registerProtobuf(codec) registers the
+ * ones of a design
+ * @implements {EddCodec}
+ */
+class EddCodecProtobuf {
+    constructor() {
+        this.protocol = "edd.protobuf"
+        this._messages = {}
+    }
+
+    /**
+     * @param {string} channel - alias of the channel
+     * @param {string} name - alias of the event
+     * @param {{encode(Object): Uint8Array, decode(Uint8Array): Object}} message
+     */
+    register(channel, name, message) {
+        this._messages[channel + "/" + name] = message
+    }
+
+    encode(obj) {
+        const message = this._messages[obj.channel + "/" + obj.name]
+        const w = new EddProtoWriter()
+        w.tag(1, 2)
+        w.string(obj.channel)
+        w.tag(2, 2)
+        w.string(obj.name)
+        if(obj.id) {
+            w.tag(3, 0)
+            w.varint(obj.id)
+        }
+        w.tag(4, 2)
+        if(message) {
+            w.bytes(message.encode(obj.body ?? {}))
+        } else {
+            w.string(JSON.stringify(obj.body ?? null))
+            w.tag(5, 0)
+            w.bool(true)
+        }
+        return w.result()
+    }
+
+    decode(msg) {
+        const r = new EddProtoReader(msg)
+        const ret = {channel: "", name: ""}
+        let body = new Uint8Array(0), json = false
+        for (let f = r.next(); f; f = r.next()) {
+            switch(f.num) {
+                case 1:
+                    ret.channel = r.string()
+                    break
+                case 2:
+                    ret.name = r.string()
+                    break
+                case 3:
+                    ret.id = r.varint()
+                    break
+                case 4:
+                    body = r.bytes()
+                    break
+                case 5:
+                    json = r.bool()
+                    break
+                default:
+                    r.skip(f.type)
+            }
+        }
+        if(json) {
+            ret.body = JSON.parse(new TextDecoder().decode(body))
+            return ret
+        }
+        const message = this._messages[ret.channel + "/" + ret.name]
+        if(!message) {
+            console.log("unregistered protobuf event", ret.name, "in channel", ret.channel)
+            ret.body = null
+            return ret
+        }
+        ret.body = message.decode(body)
+        return ret
+    }
+}
+
+/**
+ * Fallback transport exposing the subset of the WebSocket interface used by EddClient:
+ * events are streamed with Server-Sent Events and messages are sent as HTTP POSTs
+ */
+class EddSseConn {
+    constructor(url) {
+        const base = new URL(url, typeof location !== "undefined" ? location.href : undefined)
+        base.protocol = base.protocol.replace(/^ws/, "http")
+        base.pathname = base.pathname.replace(/\/$/, "")
+        const stream = new URL(base.toString())
+        stream.pathname += "/edd/sse"
+        this._send = new URL(base.toString())
+        this._send.pathname += "/edd/send"
+        this._send.search = ""
+        this._queue = Promise.resolve()
+        this._closed = false
+        this.onopen = null
+        this.onmessage = null
+        this.onclose = null
+        this.onerror = null
+
+        this._es = new EventSource(stream.toString())
+        this._es.addEventListener("edd:open", (evt) => {
+            this._send.searchParams.set("edd_conn", evt.data)
+            this.onopen && this.onopen()
+        })
+        this._es.onmessage = (evt) => {
+            this.onmessage && this.onmessage({data: evt.data})
+        }
+        this._es.addEventListener("binary", (evt) => {
+            const bin = atob(evt.data)
+            const buf = new Uint8Array(bin.length)
+            for (let i = 0; i < bin.length; i++) {
+                buf[i] = bin.charCodeAt(i)
+            }
+            this.onmessage && this.onmessage({data: buf.buffer})
+        })
+        // EventSource would reconnect on its own, but the server side of the stream is gone
+        this._es.onerror = () => this._lost()
+    }
+
+    send(data) {
+        const headers = {"content-type": typeof data === "string" ? "text/plain" : "application/octet-stream"}
+        // one post at a time, so the server receives the messages in order
+        this._queue = this._queue.then(() => fetch(this._send.toString(), {method: "POST", body: data, headers: headers}))
+            .then((res) => {
+                if(!res.ok) {
+                    this._lost()
+                }
+            }, () => this._lost())
+    }
+
+    close() {
+        if(this._closed) {
+            return
+        }
+        if(this._send.searchParams.has("edd_conn")) {
+            fetch(this._send.toString(), {method: "DELETE"}).catch(() => {})
+        }
+        this._lost()
+    }
+
+    _lost() {
+        if(this._closed) {
+            return
+        }
+        this._closed = true
+        this._es.close()
+        this.onclose && this.onclose()
+    }
+}
+
+/**
+ * Error notified by the server on the "errors" channel
+ */
+class EddError extends Error {
+    constructor(body) {
+        super(body.message)
+        this.name = "EddError"
+        /** @type {string} */
+        this.code = body.code
+        /** @type {string|undefined} */
+        this.channel = body.channel
+        /** @type {string|undefined} */
+        this.event = body.event
+        /** @type {number|undefined} */
+        this.requestId = body.request_id
+        this.details = body.details
+    }
+}
+
 class EddClient {
     constructor(url) {
         this.channels = {}
         this.url = url
         this.is_connected = false;
         this.codec = EddCodecJson
+        this._requestId = 0
+        this._pending = {}
+        this.keepAliveTimeout = 75000
+        this._lastSeen = 0
+        this._keepAliveTimer = null
+        this.resumeDelay = 1000
+        this._session = null
+        this._received = 0
+        this._connReceived = 0
+        this._onSession = null
+        this.transport = "auto"
+        this._sse = false
+    }
+
+    /**
+     * @function EddClient#setTransport
+     * @param {"auto"|"websocket"|"sse"} transport - auto falls back to sse when the websocket connection fails
+     */
+    setTransport(transport){
+        this.transport = transport
+        this._sse = transport === "sse"
+    }
+
+    /**
+     * @function EddClient#setResumeDelay
+     * @param {number} delay - milliseconds to wait before resuming a lost session, 0 to disable the resumption
+     */
+    setResumeDelay(delay){
+        this.resumeDelay = delay
+    }
+
+    /**
+     * @callback onSessionCb
+     * @param {{token: string, resumed: boolean, lost: number|undefined, grace: number}} session - lost is the number of missed messages that could not be replayed
+     */
+    /**
+     * @function EddClient#onSession
+     * @param {onSessionCb} callback - called when a session starts or is resumed
+     */
+    onSession(callback){
+        this._onSession = callback
+    }
+
+    /**
+     * @function EddClient#setKeepAliveTimeout
+     * @param {number} timeout - milliseconds without any message from server before considering it dead, 0 to disable
+     */
+    setKeepAliveTimeout(timeout){
+        this.keepAliveTimeout = timeout
     }
 
     /**
@@ -45,12 +871,21 @@ class EddClient {
             client._onChanErr("ws connection timeout")
             // client.conn?.close();
         }, timeout);
+        let url = this.url
+        if(this._session) {
+            url += (url.indexOf("?") === -1 ? "?" : "&") + "edd_session=" + encodeURIComponent(this._session.token) + "&edd_received=" + this._received
+        }
+        const protocol = this.codec.protocol
+        if(this._sse && protocol) {
+            url += (url.indexOf("?") === -1 ? "?" : "&") + "edd_protocol=" + encodeURIComponent(protocol)
+        }
         try {
-            this.conn = new WebSocket(this.url);
+            this.conn = this._sse ? new EddSseConn(url) : (protocol ? new WebSocket(url, protocol) : new WebSocket(url));
         } catch(err){
-            this._onChanErr("error while dialing ws " + this.url + " : " + err)
+            this._onChanErr("error while dialing " + this.url + " : " + err)
             return
         }
+        let opened = false
         this.conn.onerror = (event) => {
             var reason;
             // See https://www.rfc-editor.org/rfc/rfc6455#section-7.4.1
@@ -84,9 +919,22 @@ class EddClient {
                 reason = "Unknown reason";
             this._onChanErr("error in socket communication: " + reason)
         }
-        this.conn.onclose = function() { client.disconnected() }
+        this.conn.onclose = function() {
+            if(!opened && !client._sse && client.transport === "auto") {
+                // the upgrade may be blocked by a proxy, retry with the fallback transport
+                clearTimeout(timer)
+                client._sse = true
+                client.start(timeout)
+                return
+            }
+            client.disconnected()
+            client._resume()
+        }
         this.conn.onopen = function() {
+            opened = true
             clearTimeout(timer)
+            client._connReceived = 0
+            client._watchKeepAlive()
             client.connected()
         }
 
@@ -102,9 +950,30 @@ class EddClient {
             if(raw instanceof Blob) {
                 raw = await raw.arrayBuffer()
             }
+            client._lastSeen = Date.now()
             let data = client.codec.decode(raw)
+            // keepalives are not part of the session history, see _resume
+            if(data.channel !== "edd" || data.name !== "edd:keepalive") {
+                client._received++
+                client._connReceived++
+            }
+            if(data.channel === "edd") {
+                client._routeSystem(data)
+                return
+            }
+            if(data.id && client._pending.hasOwnProperty(data.id)) {
+                const pending = client._pending[data.id]
+                delete client._pending[data.id]
+                clearTimeout(pending.timer)
+                if(data.channel === "errors") {
+                    pending.reject(new EddError(data.body))
+                } else {
+                    pending.resolve(data)
+                }
+                return
+            }
             if(data.channel === "errors") {
-                client._onChanErr(data.body)
+                client._onChanErr(new EddError(data.body))
                 return
             }
             if(!client.channels.hasOwnProperty(data.channel)){
@@ -117,39 +986,157 @@ class EddClient {
         }
     }
 
+    _watchKeepAlive(){
+        this._lastSeen = Date.now()
+        clearInterval(this._keepAliveTimer)
+        if(!this.keepAliveTimeout) {
+            return
+        }
+        const client = this
+        this._keepAliveTimer = setInterval(function() {
+            if(Date.now() - client._lastSeen < client.keepAliveTimeout) {
+                return
+            }
+            // the server is not answering anymore, the socket may never fire onclose on a half-open connection
+            client._onChanErr("server is not responding, closing connection")
+            client.conn.onclose = null
+            client.conn.close()
+            client.disconnected()
+            client._resume()
+        }, 1000)
+    }
+
+    _resume(){
+        const session = this._session
+        if(!session || !this.resumeDelay) {
+            return
+        }
+        session.lostAt = session.lostAt || Date.now()
+        if(Date.now() - session.lostAt + this.resumeDelay >= session.grace) {
+            this._session = null
+            return
+        }
+        const client = this
+        setTimeout(function() {
+            if(client._session === session && !client.is_connected) {
+                client.start()
+            }
+        }, this.resumeDelay)
+    }
+
+    _routeSystem(data){
+        switch (data.name) {
+            case "edd:keepalive":
+                this.send({channel: "edd", name: "edd:keepalive", body: {}})
+                break
+            case "edd:channel:subscribed":
+                this._setSubscribed(data.body.channel, true)
+                break
+            case "edd:channel:unsubscribed":
+                this._setSubscribed(data.body.channel, false)
+                if(this.channels.hasOwnProperty(data.body.channel) && this.channels[data.body.channel]._unregistered) {
+                    delete this.channels[data.body.channel]
+                }
+                break
+            case "edd:session":
+                if(!data.body.resumed) {
+                    // a new session counts only the messages of this connection
+                    this._received = this._connReceived
+                }
+                this._session = {token: data.body.token, grace: data.body.grace, lostAt: 0}
+                if(this._onSession) {
+                    this._onSession(data.body)
+                }
+                break
+            default:
+                console.log("unexpected system event", data.name)
+        }
+    }
+
     stop(){
+        this._session = null
         if(this.is_connected) {
             this.is_connected = false;
             this.conn.close();
         }
     }
 
+    /**
+     * @function EddClient#register
+     * @param {EddChannel} channel - subscribed now if connected, otherwise on connection
+     */
     register(channel) {
         this.channels[channel.getAlias()] = channel
+        channel._unregistered = false
         if(this.conn) {
             channel.setClient(this)
         }
+        if(this.is_connected) {
+            this._subscribe(channel)
+        }
+    }
 
+    /**
+     * @function EddClient#unregister
+     * @param {EddChannel} channel - unsubscribed from the server, its disconnected callback is called once confirmed
+     */
+    unregister(channel) {
+        const alias = channel.getAlias()
+        if(!this.is_connected || !channel._subscribed) {
+            delete this.channels[alias]
+            return
+        }
+        channel._unregistered = true
+        this.send({channel: "edd", name: "edd:channel:unsubscribe", body: {channel: alias}})
+    }
+
+    _subscribe(channel) {
+        this.send({channel: "edd", name: "edd:channel:subscribe", body: {channel: channel.getAlias()}})
+    }
+
+    _setSubscribed(alias, subscribed) {
+        if(!this.channels.hasOwnProperty(alias)) {
+            return
+        }
+        const ch = this.channels[alias]
+        if(ch._subscribed === subscribed) {
+            return
+        }
+        ch._subscribed = subscribed
+        const fn = subscribed ? ch._connectedFn : ch._disconnectedFn
+        if(fn != null) {
+            fn()
+        }
     }
 
     connected(){
         this.is_connected = true;
+        // a resumed session keeps its subscriptions, the server confirms them again
         for (let i in this.channels) {
-            if(this.channels.hasOwnProperty(i)) {
-                if(this.channels[i]._connectedFn != null) {
-                    this.channels[i]._connectedFn()
-                }
+            if(!this.channels.hasOwnProperty(i)) {
+                continue
+            }
+            if(this.channels[i]._unregistered) {
+                delete this.channels[i]
+                continue
             }
+            this._subscribe(this.channels[i])
         }
     }
 
     disconnected(){
         this.is_connected = false;
+        clearInterval(this._keepAliveTimer)
+        for (let id in this._pending) {
+            if(this._pending.hasOwnProperty(id)) {
+                clearTimeout(this._pending[id].timer)
+                this._pending[id].reject("connection closed before receiving the reply")
+            }
+        }
+        this._pending = {}
         for (let i in this.channels) {
             if(this.channels.hasOwnProperty(i)) {
-                if(this.channels[i]._disconnectedFn != null) {
-                    this.channels[i]._disconnectedFn()
-                }
+                this._setSubscribed(i, false)
             }
         }
     }
@@ -164,6 +1151,30 @@ class EddClient {
         return true
     }
 
+    /**
+     * @function EddClient#request
+     * @param {{channel: string, name: string, body: any}} obj
+     * @param {number} [timeout] - milliseconds to wait for the reply, default 10000
+     * @return {Promise<{channel: string, name: string, body: any}>} resolved with the reply message or rejected with the server error
+     */
+    request(obj, timeout){
+        const client = this
+        return new Promise(function(resolve, reject) {
+            const id = ++client._requestId
+            obj.id = id
+            const timer = setTimeout(function() {
+                delete client._pending[id]
+                reject("request timeout")
+            }, timeout ?? 10000)
+            client._pending[id] = {resolve: resolve, reject: reject, timer: timer}
+            if(!client.send(obj)) {
+                clearTimeout(timer)
+                delete client._pending[id]
+                reject("attempting to send request on inactive connection")
+            }
+        })
+    }
+
     sendraw(msg){
         if(!this.is_connected) {
             this._onChanErr('attempting to send message on inactive connection')
@@ -175,7 +1186,7 @@ class EddClient {
 
     /**
      * @callback onChanErrCb
-     * @param {string} error
+     * @param {EddError|string} error - EddError when notified by the server, string for local errors
      */
     /**
      * @function EddClient#onChanErr
@@ -190,6 +1201,14 @@ class EddClient {
 /**
  * @typedef auth_challenge
  * @property {string[]} methods
+ * @property {Object<string, string>} [nonces] the nonce to answer of each multi-step method
+ */
+
+/**
+ * @typedef auth_refresh_required
+ * @property {string[]} methods
+ * @property {Object<string, string>} [nonces] the nonce to answer of each multi-step method
+ * @property {number} expires_at the expiry of the current auth, in unix seconds
  */
 
 /**
@@ -244,12 +1263,17 @@ class EddChannel {
     constructor(alias) {
         this.alias = alias
         this.client = null
+        this._subscribed = false
+        this._unregistered = false
         this._authChallenged = () => {
             console.log("edd auth challenge was received from server, but no handler was configured")
         }
         this._authPassed = () => {
             console.log("edd auth pass was received from server, but no handler was configured")
         }
+        this._authRefreshRequired = () => {
+            console.log("edd auth refresh required was received from server, but no handler was configured")
+        }
         this._userJoin = () => {
             console.log("edd user join was received from server, but no handler was configured")
         }
@@ -281,6 +1305,9 @@ class EddChannel {
             case "edd:auth:pass":
                 this._authPassed(body)
                 break
+            case "edd:auth:refresh_required":
+                this._authRefreshRequired(body)
+                break
             case "edd:user:join":
                 this._userJoin(body)
                 break
@@ -304,6 +1331,14 @@ class EddChannel {
         this.client.send( {channel:this.alias, name:"edd:auth:basic", body: {username:username, password:password }} );
     }
 
+    sendAuthToken(token){
+        this.client.send( {channel:this.alias, name:"edd:auth:token", body: {token:token}} );
+    }
+
+    sendAuth(method, body){
+        this.client.send( {channel:this.alias, name:method, body: body} );
+    }
+
     sendRoomJoinRequest(room) {
         this.client.send({channel: this.alias, name: "edd:room:join_request", body: {room: room}})
     }
@@ -341,6 +1376,19 @@ class EddChannel {
         this._authPassed = callback
     }
 
+    /**
+     * @callback authRefreshRequiredCb
+     * @param {auth_refresh_required} event
+     */
+    /**
+     * The callback answers with a new credential, as for a challenge, before the current one expires
+     * @function eddwiseChannel#authRefreshRequired
+     * @param {authRefreshRequiredCb} callback
+     */
+    authRefreshRequired(callback) {
+        this._authRefreshRequired = callback
+    }
+
     /**
      * @callback userJoinCb
      * @param {user_join} event
@@ -403,4 +1451,4 @@ class EddChannel {
 
 }
 
-export {EddClient, EddChannel};
+export {EddClient, EddChannel, EddError, EddCodecJson, EddCodecMsgPack, EddCodecCbor, EddCodecProtobuf, EddProtoReader, EddProtoWriter};
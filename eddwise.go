@@ -4,16 +4,16 @@ import (
 	"bytes"
 	"context"
 	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net"
+	"net/url"
+	"reflect"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/websocket/v2"
 	"github.com/ugorji/go/codec"
 )
 
@@ -28,9 +28,9 @@ func ClientJS() []byte {
 
 func ErrMissingServerHandler(chName, eventName string) error {
 	if len(eventName) == 0 {
-		return fmt.Errorf("empty event name")
+		return NewError(ErrCodeBadRequest, "empty event name")
 	}
-	return fmt.Errorf("handler for event '%s' on channel '%s' was not expected", eventName, chName)
+	return NewError(ErrCodeUnknownEvent, "handler for event '%s' on channel '%s' was not expected", eventName, chName)
 }
 
 type ClientContext interface {
@@ -44,13 +44,23 @@ type ClientContext interface {
 	GetRooms() []*Room
 	addRoom(*Room)
 	delRoom(*Room)
+	Subscribed(channel string) bool
+	GetChannels() []string
+	subscription(channel string) (subscription, bool)
+	setSubscription(channel string, sub subscription)
+	delSubscription(channel string)
+	allow(key string, limit RateLimit) bool
+	refund(key string, limit RateLimit)
 }
 
 type ClientContextMap struct {
-	auth  *Auth
-	rooms sync.Map
-	state interface{}
-	m     map[string]interface{}
+	logger   Logger
+	auth     *Auth
+	rooms    sync.Map
+	channels sync.Map
+	state    interface{}
+	m        map[string]interface{}
+	buckets  sync.Map
 }
 
 func (cc *ClientContextMap) Has(key string) bool {
@@ -71,8 +81,8 @@ func (cc *ClientContextMap) setRawAuth(auth *Auth) {
 }
 
 func (cc *ClientContextMap) GetRawAuth() *Auth {
-	if cc.auth == nil {
-		log.Println("an empty auth was requested")
+	if cc.auth == nil && cc.logger != nil {
+		cc.logger.Debug("an empty auth was requested")
 	}
 	return cc.auth
 }
@@ -105,6 +115,7 @@ type Client interface {
 	ClientContext
 	GetId() uint64
 	Send(channel string, event Event) error
+	Reply(channel string, requestId uint64, event Event) error
 	SendJSON(interface{}) error
 	Close() error
 	Closed() bool
@@ -112,11 +123,29 @@ type Client interface {
 
 type ClientSocket struct {
 	ClientContextMap
-	id      uint64
-	Server  *ServerSocket
-	Conn    *websocket.Conn
-	WriteMx sync.Mutex
-	closed  bool
+	id         uint64
+	Server     *ServerSocket
+	Conn       Conn
+	WriteMx    sync.Mutex
+	closed     bool
+	queue      chan outboundFrame
+	writerDone chan struct{}
+	dropped    uint64
+	idleTimer  *time.Timer
+	detached   chan struct{}
+	session    *session
+	remoteAddr string
+	codec      Codec
+	// authedOnUpgrade is set when the auth of the client comes from its upgrade request, see SetUpgradeAuth
+	authedOnUpgrade bool
+	// slowConsumer is the policy of the server when the client connected, see SetOutboundQueue
+	slowConsumer SlowConsumerPolicy
+
+	// authMx guards the expiry of the auth, see scheduleAuthExpiry
+	authMx        sync.Mutex
+	authTimer     *time.Timer
+	expiringAuth  *Auth
+	refreshNonces map[string]map[string]string
 }
 
 func (c *ClientSocket) GetId() uint64 {
@@ -124,84 +153,113 @@ func (c *ClientSocket) GetId() uint64 {
 }
 
 func (c *ClientSocket) Send(channel string, event Event) error {
+	return c.Reply(channel, 0, event)
+}
+
+// Reply sends the event as response of the request identified by requestId, a zero requestId sends a plain event
+func (c *ClientSocket) Reply(channel string, requestId uint64, event Event) error {
 	if ecf, ok := event.(EventCheckSendFields); ok {
 		if err := ecf.CheckSendFields(); err != nil {
 			return err
 		}
 	}
-	if c.closed {
+	if c.Closed() {
 		return errors.New("writing to closed client")
 	}
 	var evt = &EventMessageToSend{
 		Channel: channel,
 		Name:    event.ProtocolAlias(),
+		Id:      requestId,
 		Body:    event,
 	}
-	//var err error
-	//evt.Body, err = c.Server.Codec().Encode(event)
-	//if err != nil {
-	//	return err
-	//}
-
-	m, err := c.Server.Codec().Encode(evt)
-	if err != nil {
-		return fmt.Errorf("cannot encode message: %w", err)
-	}
-	c.WriteMx.Lock()
-	defer c.WriteMx.Unlock()
-	var mt int
-	switch c.Server.codec.handle.(type) {
-	case *codec.JsonHandle:
-		mt = websocket.TextMessage
-	case *codec.MsgpackHandle:
-		mt = websocket.BinaryMessage
-	}
-	if err := c.Conn.WriteMessage(mt, m); err != nil {
+	if err := chainOutboundInterceptors(c.Server.outboundInterceptors, sendToClientSocket)(c, evt); err != nil {
+		c.Server.metrics.SendErrors.Inc(channel)
 		return err
 	}
 	return nil
 }
 
-func (c *ClientSocket) SendJSON(v interface{}) error {
-	c.WriteMx.Lock()
-	defer c.WriteMx.Unlock()
-	return c.Conn.WriteJSON(v)
+func sendToClientSocket(client Client, msg *EventMessageToSend) error {
+	c, ok := client.(*ClientSocket)
+	if !ok {
+		return fmt.Errorf("unexpected client type %T", client)
+	}
+	return c.write(msg)
 }
 
-func (c *ClientSocket) Closed() bool {
-	return c.closed
+func (c *ClientSocket) write(msg *EventMessageToSend) error {
+	m, err := c.codec.Encode(msg)
+	if err != nil {
+		return fmt.Errorf("cannot encode message: %w", err)
+	}
+	return c.enqueue(c.codec.FrameType(), m)
 }
 
-func (c *ClientSocket) Close() error {
-	c.closed = true
-	return c.Conn.Close()
+// Codec returns the codec negotiated by the connection of the client
+func (c *ClientSocket) Codec() Codec {
+	return c.codec
 }
 
-type CodecSerializer struct {
-	handle codec.Handle
+// broadcast sends a message shared with other recipients, reusing its encoding when no interceptor replaced it
+func (c *ClientSocket) broadcast(shared *encodeOnce) error {
+	if c.Closed() {
+		return errors.New("writing to closed client")
+	}
+	var err error
+	if len(c.Server.outboundInterceptors) == 0 {
+		err = c.writeShared(shared)
+	} else {
+		err = chainOutboundInterceptors(c.Server.outboundInterceptors, func(client Client, msg *EventMessageToSend) error {
+			if msg != shared.msg || client != Client(c) {
+				return sendToClientSocket(client, msg)
+			}
+			return c.writeShared(shared)
+		})(c, shared.msg)
+	}
+	if err != nil {
+		c.Server.metrics.SendErrors.Inc(shared.msg.Channel)
+		return err
+	}
+	return nil
 }
 
-func NewCodecSerializer(handle codec.Handle) *CodecSerializer {
-	return &CodecSerializer{handle}
+func (c *ClientSocket) writeShared(shared *encodeOnce) error {
+	m, err := shared.encode(c.codec)
+	if err != nil {
+		return fmt.Errorf("cannot encode message: %w", err)
+	}
+	return c.enqueue(c.codec.FrameType(), m)
 }
 
-func (cs *CodecSerializer) Encode(v interface{}) ([]byte, error) {
-	var buf = make([]byte, 128)
-	var err = codec.NewEncoderBytes(&buf, cs.handle).Encode(v)
-	return buf, err
+func (c *ClientSocket) SendJSON(v interface{}) error {
+	m, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("cannot encode message: %w", err)
+	}
+	return c.enqueue(TextFrame, m)
+}
+
+func (c *ClientSocket) Closed() bool {
+	c.WriteMx.Lock()
+	defer c.WriteMx.Unlock()
+	return c.closed
 }
 
-func (cs *CodecSerializer) Decode(data []byte, v interface{}) error {
-	var err = codec.NewDecoderBytes(data, cs.handle).Decode(v)
-	return err
+// Close stops accepting new messages, the already queued ones are still written before closing the connection
+func (c *ClientSocket) Close() error {
+	c.WriteMx.Lock()
+	defer c.WriteMx.Unlock()
+	c.closeLocked()
+	return nil
 }
 
 type Server interface {
 	AddClient(Client)
 	GetClients(...uint64) []Client
+	GetChannelClients(channel string, exclude ...uint64) []Client
 	GetClient(uint64) Client
 	RemoveClient(Client)
-	Codec() *CodecSerializer
+	Codec() Codec
 }
 
 var _ Server = (*ServerSocket)(nil)
@@ -210,215 +268,229 @@ type ServerSocket struct {
 	Conn               net.Conn
 	registeredStatic   map[string]string
 	RegisteredChannels map[string]ImplChannel
-	codec              *CodecSerializer
+	codec              Codec
+	codecs             map[string]Codec
 	ClientAutoInc      uint64
 	Clients            map[uint64]Client
 	ClientsMx          sync.RWMutex
 	App                *fiber.App
+
+	subscribers   map[string]map[uint64]Client
+	subscribersMx sync.RWMutex
+
+	outboundQueueSize  int
+	slowConsumerPolicy SlowConsumerPolicy
+	writeWait          time.Duration
+	maxMessageSize     int64
+	pingInterval       time.Duration
+	pongWait           time.Duration
+	idleTimeout        time.Duration
+
+	interceptors         []Interceptor
+	outboundInterceptors []OutboundInterceptor
+
+	authMethods       []*AuthMethod
+	upgradeAuth       UpgradeAuth
+	authRefreshNotice time.Duration
+	authRefreshGrace  time.Duration
+	policyHook        PolicyHook
+
+	logger Logger
+
+	metrics     *Metrics
+	metricsPath string
+
+	cluster *cluster
+
+	sessionGrace   time.Duration
+	sessionHistory int
+	sessions       map[string]*ClientSocket
+	sessionsMx     sync.RWMutex
+
+	rateLimits rateLimits
+
+	sseConns sync.Map
+
+	endpoints []*endpoint
+	// parent is the server of an endpoint, see Endpoint
+	parent *ServerSocket
 }
 
 func NewServer() *ServerSocket {
-	return NewServerWithCustomCodec(&codec.JsonHandle{})
+	return NewServerWithCodec(NewJSONCodec())
+}
+
+// NewServerWithCustomCodec creates a server using the handle as default codec.
+// Deprecated: use NewServerWithCodec
+func NewServerWithCustomCodec(handle codec.Handle) *ServerSocket {
+	return NewServerWithCodec(NewHandleCodec(handleProtocol(handle), handle))
 }
 
-func NewServerWithCustomCodec(codec codec.Handle) *ServerSocket {
-	return &ServerSocket{
-		codec:              NewCodecSerializer(codec),
+// NewServerWithCodec creates a server using c for the connections that do not negotiate a subprotocol
+func NewServerWithCodec(c Codec) *ServerSocket {
+	var s = &ServerSocket{
+		codec:              c,
 		registeredStatic:   make(map[string]string),
 		RegisteredChannels: make(map[string]ImplChannel),
 		Clients:            make(map[uint64]Client),
+		subscribers:        make(map[string]map[uint64]Client),
+		codecs:             defaultCodecs(),
+		authMethods:        defaultAuthMethods(),
+		sessions:           make(map[string]*ClientSocket),
+		outboundQueueSize:  DefaultOutboundQueueSize,
+		slowConsumerPolicy: DisconnectSlowConsumer,
+		writeWait:          DefaultWriteWait,
+		maxMessageSize:     DefaultMaxMessageSize,
+		pingInterval:       DefaultPingInterval,
+		pongWait:           DefaultPongWait,
+		authRefreshNotice:  DefaultAuthRefreshNotice,
+		logger:             NewStdLogger(LevelInfo),
 	}
+	s.metrics = newMetrics(s)
+	return s
 }
 
 func (s *ServerSocket) AddClient(c Client) {
 	s.ClientsMx.Lock()
-	defer s.ClientsMx.Unlock()
 	s.Clients[c.GetId()] = c
+	s.ClientsMx.Unlock()
+	if s.cluster != nil {
+		s.cluster.publishClient(clusterClientJoin, c)
+	}
 }
 
+// GetClient returns a client connected to this server or, in a cluster, to another node
 func (s *ServerSocket) GetClient(id uint64) Client {
 	s.ClientsMx.RLock()
-	defer s.ClientsMx.RUnlock()
-	return s.Clients[id]
+	var c = s.Clients[id]
+	s.ClientsMx.RUnlock()
+	if c == nil && s.cluster != nil {
+		return s.cluster.getRemote(id)
+	}
+	return c
 }
 
 func (s *ServerSocket) RemoveClient(c Client) {
 	s.ClientsMx.Lock()
-	defer s.ClientsMx.Unlock()
 	delete(s.Clients, c.GetId())
+	s.ClientsMx.Unlock()
+	if s.cluster != nil {
+		s.cluster.publishClient(clusterClientLeft, c)
+	}
 }
 
 func (s *ServerSocket) RegisterStatic(path, dir string) {
 	s.registeredStatic[path] = dir
 }
 
-func (s *ServerSocket) CustomFiberApp(app *fiber.App) {
-	s.App = app
+// ServeConn runs a client on the connection until it disconnects, query holds the parameters of the
+// connection request, such as the session to resume
+func (s *ServerSocket) ServeConn(c Conn, query url.Values) {
+	s.serveConn(c, query, nil)
 }
 
-func (s *ServerSocket) initWS(wsPath string) {
-	if s.App == nil {
-		s.App = fiber.New()
-	}
-
-	for path, dir := range s.registeredStatic {
-		s.App.Static(path, dir)
-	}
-
-	s.App.Use(wsPath, func(c *fiber.Ctx) error {
-		if bytes.HasSuffix(c.Request().URI().Path(), []byte("/edd.js")) {
-			c.Response().Header.Add("content-type", "application/javascript")
-			return c.Send(eddclientJS)
-		}
-		log.Println("mw", c.Request().URI(), c.IP())
-		if websocket.IsWebSocketUpgrade(c) {
-			c.Locals("allowed", true)
-			return c.Next()
-		}
-		return fiber.ErrUpgradeRequired
-	})
-
-	s.App.Get(wsPath, websocket.New(func(c *websocket.Conn) {
-		defer func() { _ = c.Close() }()
-
-		log.Println("new client is connecting", c.RemoteAddr().String())
-		var client = &ClientSocket{
-			ClientContextMap: ClientContextMap{auth: nil, m: map[string]interface{}{}},
+// serveConn runs the client, auth is the one granted to the upgrade request, see SetUpgradeAuth
+func (s *ServerSocket) serveConn(c Conn, query url.Values, auth *Auth) {
+	var cs = s.connCodec(c, query)
+	var client = s.resumeSession(c, query, cs)
+	if client == nil {
+		client = &ClientSocket{
+			ClientContextMap: ClientContextMap{logger: s.logger, auth: nil, m: map[string]interface{}{}},
 			Conn:             c,
 			Server:           s,
-			id:               atomic.AddUint64(&s.ClientAutoInc, 1),
+			id:               s.nextClientId(),
+			queue:            newOutboundQueue(s.outboundQueueSize),
+			slowConsumer:     s.slowConsumerPolicy,
+			remoteAddr:       c.RemoteAddr(),
+			codec:            cs,
 		}
-		var ctx = NewDefaultContext(context.Background(), s, client)
-
-		if err := s.CheckAuth(ctx, client); err != nil {
-			var ee = EventMessageToSend{
-				Channel: "errors",
-				Name:    "error",
-				Body:    fmt.Sprintf("auth error: %s", err),
-			}
-			if err := client.SendJSON(ee); err != nil {
-				log.Println("unable to write err json on auth: ", err)
-			}
-			return
+		if auth != nil {
+			client.setRawAuth(auth)
+			client.authedOnUpgrade = true
 		}
-
-		defer func() {
-			_ = s.RevokeAuth(ctx, client)
-		}()
-
-		//check if it is able to connect to all channels
-		for _, ch := range s.RegisteredChannels {
-			if connRecv, ok := ch.(ImplChannelConnected); ok {
-				if err := connRecv.Connected(client); err != nil {
-					var ee = EventMessageToSend{
-						Channel: "errors",
-						Name:    "error",
-						Body:    fmt.Sprintf("error while connecting on %s: %s", ch.Name(), err),
-					}
-
-					if err := client.SendJSON(ee); err != nil {
-						log.Println("unable to write err json on connected: ", err)
-					}
-					return
-				}
-			}
+		s.logger.Info("client connecting", client.logArgs()...)
+		client.attach()
+		s.connectClient(client)
+		s.scheduleAuthExpiry(client)
+	}
+	var ctx = NewDefaultContext(context.Background(), s, client)
+
+	var (
+		//mt  int
+		msg []byte
+		err error
+	)
+	for {
+		if _, msg, err = c.ReadFrame(); err != nil {
+			s.logger.Info("client disconnected", client.logArgs("reason", err)...)
+			break
 		}
-
-		s.AddClient(client)
-		defer func() {
-			s.RemoveClient(client)
-		}()
-
-		defer func() {
-			for _, ch := range s.RegisteredChannels {
-				if connRecv, ok := ch.(ImplChannelDisconnected); ok {
-					_ = connRecv.Disconnected(client)
-				}
+		client.extendReadDeadline()
+
+		if err := s.ProcessEvent(ctx, msg); err != nil && !client.Closed() {
+			var e = AsError(err)
+			if e.Code == ErrCodeInternal {
+				s.logger.Error("event error", client.logArgs("channel", e.Channel, "event", e.Event, "error", e)...)
+			} else {
+				s.logger.Debug("event error", client.logArgs("channel", e.Channel, "event", e.Event, "code", e.Code, "error", e.Message)...)
 			}
-		}()
-
-		//Auto broadcast Join
-		for _, ch := range s.RegisteredChannels {
-			if _, ok := ch.(ImplConnManager); !ok {
-				if chUser, ok := ch.(ImplChannelWithUserJoin); ok {
-					_ = chUser.onJoin(ch, client, true)
-				}
+			if err := SendError(client, e); err != nil {
+				s.logger.Warn("unable to write event error", client.logArgs("error", err)...)
 			}
 		}
 
-		//Auto broadcast Left
-		defer func() {
-			for _, ch := range s.RegisteredChannels {
-				if _, ok := ch.(ImplConnManager); !ok {
-					if chUser, ok := ch.(ImplChannelWithUserLeft); ok {
-						_ = chUser.onLeft(ch, client)
-					}
-				}
-			}
-		}()
-
-		//Auto broadcast RoomList
-		for _, ch := range s.RegisteredChannels {
-			if chRoom, ok := ch.(ImplRoomManager); ok {
-				_ = chRoom.SendPublicRooms(client)
-			}
-		}
+	}
 
-		//Auto broadcast RoomLeft
-		defer func() {
-			for _, ch := range s.RegisteredChannels {
-				if chRoom, ok := ch.(ImplRoomManager); ok {
-					_ = chRoom.RoomClientQuit(client)
-				}
-			}
-		}()
-
-		var (
-			//mt  int
-			msg []byte
-			err error
-		)
-		for {
-			if _, msg, err = c.ReadMessage(); err != nil {
-				log.Println("read:", err)
-				_ = client.Close()
-				break
-			}
+	if s.parkSession(client, err) {
+		return
+	}
+	_ = client.Close()
+	client.detach()
+	s.disconnectClient(ctx, client)
+}
 
-			if err := s.ProcessEvent(ctx, msg); err != nil {
-				var ee = EventMessageToSend{
-					Channel: "errors",
-					Name:    "error",
-					Body:    fmt.Sprintf("error while processing event: %s", err),
-				}
-				if err := client.SendJSON(ee); err != nil {
-					log.Println("unable to write err json: ", err)
-				}
-			}
+// connectClient registers the client, it subscribes to the channels afterwards, see subscribe
+func (s *ServerSocket) connectClient(client *ClientSocket) {
+	s.metrics.ConnectionsTotal.Inc()
+	s.AddClient(client)
 
+	if s.sessionGrace > 0 {
+		ss, err := newSession(s.sessionHistory)
+		if err != nil {
+			s.logger.Warn("unable to create session", client.logArgs("error", err)...)
+			return
 		}
-
-	}, websocket.Config{
-		EnableCompression: true,
-	}))
-}
-
-func (s *ServerSocket) StartWS(wsPath string, port int) error {
-	s.initWS(wsPath)
-	return s.App.Listen(fmt.Sprintf(":%d", port))
+		client.session = ss
+		s.registerSession(client)
+		if err := s.sessionStart(client, false, 0); err != nil {
+			s.logger.Warn("unable to write session start", client.logArgs("error", err)...)
+		}
+	}
 }
 
-func (s *ServerSocket) StartWSS(wsPath string, port int, certFile, keyFile string) error {
-	s.initWS(wsPath)
-	return s.App.ListenTLS(fmt.Sprintf(":%d", port), certFile, keyFile)
+// disconnectClient reverts connectClient, the client leaves its channels and peers are notified
+func (s *ServerSocket) disconnectClient(ctx Context, client *ClientSocket) {
+	s.unregisterSession(client)
+	s.stopAuthExpiry(client)
+	for _, alias := range client.GetChannels() {
+		if ch, ok := s.RegisteredChannels[alias]; ok {
+			s.leaveChannel(ctx, ch, client)
+		}
+	}
+	s.RemoveClient(client)
 }
 
 func (s *ServerSocket) Close(timeout time.Duration) error {
+	if s.cluster != nil {
+		s.cluster.stop()
+	}
+	s.expireSessions()
 	var chClose = make(chan error, 1)
 	if timeout > 0 {
-		time.AfterFunc(10*time.Second, func() {
-			chClose <- fmt.Errorf("graceful shotdown timeout")
+		var timer = time.AfterFunc(timeout, func() {
+			chClose <- fmt.Errorf("graceful shutdown timeout")
 		})
+		defer timer.Stop()
 	}
 
 	//close all clients
@@ -429,6 +501,13 @@ func (s *ServerSocket) Close(timeout time.Duration) error {
 			_ = cli.Close()
 		}
 	}()
+	for _, ep := range s.endpoints {
+		_ = ep.server.Close(0)
+	}
+
+	if s.App == nil {
+		return nil
+	}
 
 	//Close server
 	go func() {
@@ -463,47 +542,116 @@ func (s *ServerSocket) Register(ch ImplChannel) error {
 }
 
 func (s *ServerSocket) ProcessEvent(ctx Context, rawEvent []byte) error {
-	var event = &EventMessage{}
-	if err := s.Codec().Decode(rawEvent, event); err != nil {
-		return err
+	var event = &EventMessage{codec: s.clientCodec(ctx.GetClient()), newEvent: s.newEvent}
+	if err := event.codec.Decode(rawEvent, event); err != nil {
+		// decode the envelope alone, the body error is then reported along with the event
+		event = &EventMessage{codec: event.codec}
+		if event.codec.Decode(rawEvent, event) != nil {
+			return WrapError(ErrCodeBadRequest, err)
+		}
 	}
+	var start = time.Now()
+	var err = s.processEvent(ctx, event)
+	var channel, name = s.eventLabels(event, err)
+	s.metrics.observeEvent(channel, name, start, err)
+	if err != nil {
+		return AsError(err).withEvent(event)
+	}
+	return nil
+}
+
+func (s *ServerSocket) processEvent(ctx Context, event *EventMessage) error {
 	if len(event.Channel) == 0 {
-		return fmt.Errorf("empty channel")
+		return NewError(ErrCodeBadRequest, "empty channel")
+	}
+	// keepalive answers are neither activity, see SetIdleTimeout, nor charged to the rate limits
+	if event.Channel != SystemChannel || event.Name != (*KeepAlive)(nil).ProtocolAlias() {
+		if t, ok := ctx.GetClient().(interface{ touch() }); ok {
+			t.touch()
+		}
+		if err := s.limitRate(ctx, event); err != nil {
+			return err
+		}
+	}
+	if event.Channel == SystemChannel {
+		return s.processSystemEvent(ctx, event)
 	}
 	ch, ok := s.RegisteredChannels[event.Channel]
 	if !ok {
-		return fmt.Errorf("unknown channel %s", event.Channel)
+		return NewError(ErrCodeUnknownChannel, "unknown channel %s", event.Channel)
 	}
-
-	var roomEvent ClientRoomEvent
-	switch event.Name {
-	case "edd:room:join_request":
-		roomEvent = &RoomJoinRequest{}
-		if err := s.Codec().Decode(event.Body, roomEvent); err != nil {
-			return err
+	var handler EventHandler = func(ctx Context, event *EventMessage) error {
+		event.routed = true
+		return s.route(ctx, ch, event)
+	}
+	if sub, ok := ctx.GetClient().subscription(ch.Alias()); !ok || sub.pending {
+		if !ok || !isAuthEvent(event.Name) {
+			return NewError(ErrCodeNotSubscribed, "not subscribed to channel %s", event.Channel)
 		}
-	case "edd:room:left_request":
-		roomEvent = &RoomLeftRequest{}
-		if err := s.Codec().Decode(event.Body, roomEvent); err != nil {
-			return err
+		handler = func(ctx Context, event *EventMessage) error {
+			return s.processAuthEvent(ctx, ch, event)
 		}
-	case "edd:room:create_request":
-		roomEvent = &RoomCreateRequest{}
-		if err := s.Codec().Decode(event.Body, roomEvent); err != nil {
-			return err
+	} else if isAuthEvent(event.Name) && len(s.channelAuthMethods(ch)) > 0 {
+		handler = func(ctx Context, event *EventMessage) error {
+			return s.refreshAuth(ctx, ch, event)
 		}
 	}
-	if roomEvent != nil {
-		if rm, ok := ch.(ImplRoomManager); ok {
-			return rm.OnRoomEvent(ctx.GetClient(), roomEvent)
+	return chainInterceptors(s.interceptors, ch, handler)(ctx, event)
+}
+
+// route dispatches the event to the room manager or to the channel
+func (s *ServerSocket) route(ctx Context, ch ImplChannel, event *EventMessage) error {
+	if roomEvent := newClientRoomEvent(event.Name); roomEvent != nil {
+		rm, ok := ch.(ImplRoomManager)
+		if !ok {
+			return NewError(ErrCodeUnknownEvent, "edd room events not handled")
+		}
+		// room events do not reach Route, which guards the other events
+		if g, ok := ch.(ImplChannelGuard); ok {
+			if err := g.Guard(ctx); err != nil {
+				return err
+			}
 		}
-		return fmt.Errorf("edd room events not handled")
+		if decoded, ok := event.event.(ClientRoomEvent); ok {
+			roomEvent = decoded
+		} else if err := event.DecodeBody(roomEvent); err != nil {
+			return WrapError(ErrCodeBadRequest, err)
+		}
+		return rm.OnRoomEvent(ctx.GetClient(), roomEvent)
 	}
 
 	return ch.Route(ctx, event)
 }
 
+// newEvent returns the value the body of an event is decoded into along with its envelope, nil keeps the body raw
+func (s *ServerSocket) newEvent(channel, name string) interface{} {
+	ch, ok := s.RegisteredChannels[channel]
+	if !ok {
+		return nil
+	}
+	if _, ok := ch.(ImplRoomManager); ok {
+		if roomEvent := newClientRoomEvent(name); roomEvent != nil {
+			return roomEvent
+		}
+	}
+	if ce, ok := ch.(ImplChannelEvents); ok {
+		if event := ce.NewEvent(name); event != nil {
+			return event
+		}
+	}
+	return nil
+}
+
+// GetClients returns the clients of the whole cluster, see localClients for the ones connected to this server
 func (s *ServerSocket) GetClients(exclude ...uint64) []Client {
+	var ret = s.localClients(exclude...)
+	if s.cluster != nil {
+		ret = append(ret, s.cluster.remoteClients(exclude...)...)
+	}
+	return ret
+}
+
+func (s *ServerSocket) localClients(exclude ...uint64) []Client {
 	s.ClientsMx.RLock()
 	defer s.ClientsMx.RUnlock()
 	var ret = make([]Client, 0, len(s.Clients))
@@ -519,7 +667,16 @@ for1:
 	return ret
 }
 
-func (s *ServerSocket) Codec() *CodecSerializer {
+// Codec returns the default codec, used by the connections that did not negotiate a subprotocol
+func (s *ServerSocket) Codec() Codec {
+	return s.codec
+}
+
+// clientCodec returns the codec of the client connection, or the default one
+func (s *ServerSocket) clientCodec(client Client) Codec {
+	if c, ok := client.(*ClientSocket); ok && c.codec != nil {
+		return c.codec
+	}
 	return s.codec
 }
 
@@ -529,29 +686,33 @@ func Broadcast(channel string, event Event, clients []Client) error {
 			return err
 		}
 	}
-	var errCh = make(chan error, 1)
+	if s := serverOf(clients); s != nil {
+		s.metrics.BroadcastFanout.Observe(float64(len(clients)), channel)
+	}
 	var errs []error
-	var wgErr sync.WaitGroup
-	wgErr.Add(1)
-	go func() {
-		for err := range errCh {
+	clients, remote := splitRemoteClients(clients)
+	for _, batch := range remote {
+		if err := batch.cluster.deliver(batch.node, batch.ids, channel, 0, event); err != nil {
 			errs = append(errs, err)
 		}
-		wgErr.Done()
-	}()
-	var wg sync.WaitGroup
+	}
+	// the envelope is encoded once per codec and the bytes are queued to the writer of each client
+	var shared = newEncodeOnce(&EventMessageToSend{
+		Channel: channel,
+		Name:    event.ProtocolAlias(),
+		Body:    event,
+	})
 	for _, c := range clients {
-		wg.Add(1)
-		go func(c Client) {
-			if err := c.Send(channel, event); err != nil {
-				errCh <- err
-			}
-			wg.Done()
-		}(c)
+		var err error
+		if cs, ok := c.(*ClientSocket); ok {
+			err = cs.broadcast(shared)
+		} else {
+			err = c.Send(channel, event)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
-	wg.Wait()
-	close(errCh)
-	wgErr.Wait()
 	if len(errs) > 0 {
 		var errmsg = bytes.NewBuffer(nil)
 		_, _ = fmt.Fprintf(errmsg, "%d error(s) occurs while broadcasting:\n", len(errs))
@@ -601,7 +762,56 @@ func (ctx *DefaultContext) GetClient() Client {
 type EventMessage struct {
 	Channel string    `json:"channel"`
 	Name    string    `json:"name"`
+	Id      uint64    `json:"id,omitempty"` // request id, set when the client expects a reply
 	Body    codec.Raw `json:"body"`
+	codec   Codec
+	// newEvent resolves the type the body is decoded into while decoding the envelope, see ImplChannelEvents
+	newEvent func(channel, name string) interface{}
+	// event is the body decoded along with the envelope, Body is empty in that case
+	event interface{}
+	// routed is set once the interceptors passed the event to the channel, see eventLabels
+	routed bool
+}
+
+var defaultEventCodec = NewJSONCodec()
+
+// DecodeBody decodes the body with the codec of the connection it was received from, json if unknown
+func (e *EventMessage) DecodeBody(v interface{}) error {
+	if e.event != nil && len(e.Body) == 0 {
+		return e.copyEvent(v)
+	}
+	if e.codec == nil {
+		return defaultEventCodec.Decode(e.Body, v)
+	}
+	return e.codec.Decode(e.Body, v)
+}
+
+// copyEvent copies the event decoded along with the envelope into v
+func (e *EventMessage) copyEvent(v interface{}) error {
+	var src, dst = reflect.ValueOf(e.event), reflect.ValueOf(v)
+	if src.Type() == dst.Type() && dst.Kind() == reflect.Ptr {
+		dst.Elem().Set(src.Elem())
+		return nil
+	}
+	var cs = e.codec
+	if cs == nil {
+		cs = defaultEventCodec
+	}
+	data, err := cs.Encode(e.event)
+	if err != nil {
+		return err
+	}
+	return cs.Decode(data, v)
+}
+
+// DecodeEvent returns the event decoded along with the envelope when it has the type T, otherwise it decodes the
+// body into the value returned by acquire
+func DecodeEvent[T any](e *EventMessage, acquire func() *T) (*T, error) {
+	if v, ok := e.event.(*T); ok {
+		return v, nil
+	}
+	var v = acquire()
+	return v, e.DecodeBody(v)
 }
 
 type EventHandler func(Context, *EventMessage) error
@@ -609,6 +819,7 @@ type EventHandler func(Context, *EventMessage) error
 type EventMessageToSend struct {
 	Channel string      `json:"channel"`
 	Name    string      `json:"name"`
+	Id      uint64      `json:"id,omitempty"` // id of the request this message replies to
 	Body    interface{} `json:"body"`
 }
 
@@ -621,6 +832,12 @@ type ImplChannel interface {
 	SetReceiver(ImplChannel) error
 }
 
+// ImplChannelEvents is implemented by the channels knowing the type of their events: the body is decoded in the
+// same pass as the envelope, into the value returned by NewEvent. It returns nil for unknown events
+type ImplChannelEvents interface {
+	NewEvent(name string) Event
+}
+
 type ImplChannelConnected interface {
 	Connected(Client) error
 }
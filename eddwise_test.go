@@ -2,8 +2,8 @@ package eddwise
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"golang.org/x/net/websocket"
 	"sync"
 	"testing"
 	"time"
@@ -84,7 +84,8 @@ func (ch *TestChannel) Route(ctx Context, event *EventMessage) error {
 		return fmt.Errorf("unexpected event name '%s', expecting '%s'", event.Name, "testRequest")
 	}
 
-	if string(event.Body) != "\"important message\"" {
+	var body string
+	if err := event.DecodeBody(&body); err != nil || body != "important message" {
 		return fmt.Errorf("unexpected value for body: %s, expecting \"important message\"", event.Body)
 	}
 
@@ -98,9 +99,53 @@ func (ch *TestChannel) Route(ctx Context, event *EventMessage) error {
 type EventMessageTest struct {
 	Channel string          `json:"channel"`
 	Name    string          `json:"name"`
+	Id      uint64          `json:"id,omitempty"`
 	Body    json.RawMessage `json:"body"`
 }
 
+// receiveJSON reads the next frame of a pipe as a json message
+func receiveJSON(t *testing.T, conn Conn, v interface{}) {
+	t.Helper()
+	var done = make(chan error, 1)
+	go func() {
+		_, data, err := conn.ReadFrame()
+		if err == nil {
+			err = json.Unmarshal(data, v)
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unable to receive message: %s\n", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no message received\n")
+	}
+}
+
+func sendJSON(t *testing.T, conn Conn, v interface{}) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unable to encode message: %s\n", err)
+	}
+	if err := conn.WriteFrame(TextFrame, data); err != nil {
+		t.Fatalf("unable to send message: %s\n", err)
+	}
+}
+
+// subscribeJSON subscribes the pipe to the channel and waits for the confirmation
+func subscribeJSON(t *testing.T, conn Conn, channel string) {
+	t.Helper()
+	sendJSON(t, conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: channel}})
+	var response = EventMessageTest{}
+	receiveJSON(t, conn, &response)
+	if response.Channel != SystemChannel || response.Name != "edd:channel:subscribed" {
+		t.Fatalf("unexpected subscribe response %s %s %s\n", response.Channel, response.Name, response.Body)
+	}
+}
+
 func TestServer(t *testing.T) {
 	var s = NewServer()
 	var ch = &TestChannel{}
@@ -108,58 +153,45 @@ func TestServer(t *testing.T) {
 		t.Fatalf("unexpected error while registering server: %s\n", err)
 	}
 
-	//run client checks
-	time.AfterFunc(100*time.Millisecond, func() {
-
-		//close the server
-		defer func() {
-			<-time.After(100 * time.Millisecond)
-			if err := s.Close(); err != nil {
-				t.Fatalf("unable to close server: %s\n", err)
-			}
-		}()
+	var serverConn, conn = NewPipe()
+	var served = make(chan struct{})
+	go func() {
+		s.ServeConn(serverConn, nil)
+		close(served)
+	}()
+	subscribeJSON(t, conn, ch.Alias())
 
-		conn, err := websocket.Dial("ws://localhost:34362/test", "", "http://localhost")
-		if err != nil {
-			t.Fatalf("unable to init websocket client: %s\n", err)
-		}
-		defer func() { _ = conn.Close() }()
-
-		// send message
-		if err := websocket.JSON.Send(conn, EventMessageToSend{
-			Channel: "test",
-			Name:    "testRequest",
-			Body:    "important message",
-		}); err != nil {
-			t.Fatalf("unable to send message through socket: %s\n", err)
-		}
+	// send message
+	sendJSON(t, conn, EventMessageToSend{
+		Channel: "test",
+		Name:    "testRequest",
+		Body:    "important message",
+	})
 
-		var response = EventMessageTest{}
+	var response = EventMessageTest{}
+	receiveJSON(t, conn, &response)
+	if response.Channel == "errors" && response.Name == "error" {
+		t.Fatalf("an error occurred on server: %s", response.Body)
+	}
+	if response.Channel != ch.Name() {
+		t.Fatalf("unexpected channel name '%s', expecting '%s'\n", response.Channel, ch.Name())
+	}
+	if response.Name != "testResponse" {
+		t.Fatalf("unexpected event name '%s', expecting '%s'\n", response.Name, "testResponse")
+	}
+	if len(response.Body) != 3 {
+		t.Fatalf("unexpected body length != 3\n")
+	}
+	if string(response.Body) != "\"B\"" {
+		t.Fatalf("unexpected value for body: %s, expecting \"B\"\n", response.Body)
+	}
 
-		if err := websocket.JSON.Receive(conn, &response); err != nil {
-			// handle error
-			t.Fatalf("unable to receive message through socket: %s\n", err)
-		}
-		if response.Channel == "errors" && response.Name == "error" {
-			t.Fatalf("an error occurred on server: %s", response.Body)
-		}
-		if response.Channel != ch.Name() {
-			t.Fatalf("unexpected channel name '%s', expecting '%s'\n", response.Channel, ch.Name())
-		}
-		if response.Name != "testResponse" {
-			t.Fatalf("unexpected event name '%s', expecting '%s'\n", response.Name, "testResponse")
-		}
-		if len(response.Body) != 3 {
-			t.Fatalf("unexpected body length != 3\n")
-		}
-		if string(response.Body) != "\"B\"" {
-			t.Fatalf("unexpected value for body: %s, expecting \"B\"\n", response.Body)
-		}
-	})
-	if err := s.StartWS("/test", 34362); err != nil {
-		t.Fatalf("unable to start websocket server: %s\n", err)
+	_ = conn.Close()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatalf("the client was not disconnected\n")
 	}
-	<-time.After(1000 * time.Millisecond)
 
 	if !ch.GetConnected() {
 		t.Fatalf("Connect() method was not called\n")
@@ -168,10 +200,84 @@ func TestServer(t *testing.T) {
 	if !ch.GetDisconnected() {
 		t.Fatalf("Disconnect() method was not called\n")
 	}
+}
+
+func TestProcessEventError(t *testing.T) {
+	var s = NewServer()
+	var ctx = NewDefaultContextFromBackground(s, nil)
+	var err = s.ProcessEvent(ctx, []byte(`{"channel":"unknown","name":"get","id":7,"body":{}}`))
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expecting a coded error, got %v\n", err)
+	}
+	if e.RequestId != 7 {
+		t.Fatalf("unexpected request id %d, expecting 7\n", e.RequestId)
+	}
+	if e.Code != ErrCodeUnknownChannel || e.Channel != "unknown" || e.Event != "get" {
+		t.Fatalf("unexpected error envelope %+v\n", e)
+	}
 
-	//message := messageType{}
-	//if err := websocket.JSON.Receive(conn, &message); err != nil {
-	//	// handle error
-	//}
+	err = s.ProcessEvent(ctx, []byte(`{"channel":"unknown","name":"get","body":{}}`))
+	if !errors.As(err, &e) || e.RequestId != 0 {
+		t.Fatalf("expecting an error without request id, got %v\n", err)
+	}
+}
 
+// replyTestChannel answers testRequest as the generated Route does for a request declared as "event -> reply"
+type replyTestChannel struct {
+	TestChannel
+}
+
+func (ch *replyTestChannel) SetReceiver(ImplChannel) error {
+	return nil
+}
+
+func (ch *replyTestChannel) Route(ctx Context, event *EventMessage) error {
+	var body string
+	if err := event.DecodeBody(&body); err != nil {
+		return WrapError(ErrCodeBadRequest, err)
+	}
+	if body != "important message" {
+		return NewError(ErrCodeBadRequest, "unexpected body %s", body)
+	}
+	return ctx.GetClient().Reply(ch.Alias(), event.Id, TestResponse("B"))
+}
+
+func TestRequestReply(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var ch = &replyTestChannel{}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(serverConn, nil)
+	defer func() { _ = conn.Close() }()
+	subscribeJSON(t, conn, ch.Alias())
+
+	if err := conn.WriteFrame(TextFrame, []byte(`{"channel":"test","name":"testRequest","id":42,"body":"important message"}`)); err != nil {
+		t.Fatalf("unable to send request: %s\n", err)
+	}
+	var reply = expectJSON(t, conn, "test", "testResponse")
+	if reply.Id != 42 || string(reply.Body) != `"B"` {
+		t.Fatalf("unexpected reply id %d and body %s, expecting 42 and \"B\"\n", reply.Id, reply.Body)
+	}
+
+	// a plain event gets a reply without id
+	if err := conn.WriteFrame(TextFrame, []byte(`{"channel":"test","name":"testRequest","body":"important message"}`)); err != nil {
+		t.Fatalf("unable to send event: %s\n", err)
+	}
+	if reply = expectJSON(t, conn, "test", "testResponse"); reply.Id != 0 {
+		t.Fatalf("unexpected reply id %d for an event without id\n", reply.Id)
+	}
+
+	// a failed request is answered with an error carrying its id
+	if err := conn.WriteFrame(TextFrame, []byte(`{"channel":"test","name":"testRequest","id":43,"body":"other message"}`)); err != nil {
+		t.Fatalf("unable to send request: %s\n", err)
+	}
+	var msg = expectJSON(t, conn, ErrorsChannel, "error")
+	var e Error
+	if err := json.Unmarshal(msg.Body, &e); err != nil || msg.Id != 43 || e.RequestId != 43 || e.Code != ErrCodeBadRequest {
+		t.Fatalf("unexpected error reply %d %s\n", msg.Id, msg.Body)
+	}
 }
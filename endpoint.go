@@ -0,0 +1,63 @@
+package eddwise
+
+import (
+	"sync/atomic"
+)
+
+// endpoint is a namespace mounted on the app of its parent server
+type endpoint struct {
+	path   string
+	server *ServerSocket
+}
+
+// Endpoint returns a namespace served on path by the same fiber app and listener, for example /admin beside /game.
+// It has its own channels, and so its own auth requirements, and its own clients; it inherits the settings of s
+// at the time of the call and it is closed along with s. Endpoints take precedence over the main path, their
+// paths must not be nested in each other.
+// With net/http, mount the Handler of the endpoint on its path
+func (s *ServerSocket) Endpoint(path string) *ServerSocket {
+	for _, ep := range s.endpoints {
+		if ep.path == path {
+			return ep.server
+		}
+	}
+	var ep = NewServerWithCodec(s.codec)
+	for name, c := range s.codecs {
+		ep.codecs[name] = c
+	}
+	ep.outboundQueueSize, ep.slowConsumerPolicy, ep.writeWait = s.outboundQueueSize, s.slowConsumerPolicy, s.writeWait
+	ep.pingInterval, ep.pongWait, ep.idleTimeout = s.pingInterval, s.pongWait, s.idleTimeout
+	ep.maxMessageSize = s.maxMessageSize
+	ep.interceptors = append([]Interceptor(nil), s.interceptors...)
+	ep.outboundInterceptors = append([]OutboundInterceptor(nil), s.outboundInterceptors...)
+	ep.authMethods = append([]*AuthMethod(nil), s.authMethods...)
+	ep.upgradeAuth = s.upgradeAuth
+	ep.authRefreshNotice, ep.authRefreshGrace = s.authRefreshNotice, s.authRefreshGrace
+	ep.policyHook = s.policyHook
+	ep.logger = s.logger
+	ep.sessionGrace, ep.sessionHistory = s.sessionGrace, s.sessionHistory
+	ep.rateLimits.copyFrom(&s.rateLimits)
+	// the metrics of the endpoints are exposed along with the ones of s
+	ep.metrics = s.metrics
+	ep.parent = s
+	s.endpoints = append(s.endpoints, &endpoint{path: path, server: ep})
+	return ep
+}
+
+// nextClientId returns the id of a new client, endpoints share the counter of their parent so that the ids are
+// unique across the server
+func (s *ServerSocket) nextClientId() uint64 {
+	if s.parent != nil {
+		return s.parent.nextClientId()
+	}
+	return atomic.AddUint64(&s.ClientAutoInc, 1)
+}
+
+// sockets returns s and its endpoints
+func (s *ServerSocket) sockets() []*ServerSocket {
+	var ret = []*ServerSocket{s}
+	for _, ep := range s.endpoints {
+		ret = append(ret, ep.server.sockets()...)
+	}
+	return ret
+}
@@ -0,0 +1,97 @@
+package eddwise
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestEndpoints(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	if err := s.Register(&TestChannel{}); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var admin = s.Endpoint("/admin")
+	if admin != s.Endpoint("/admin") {
+		t.Fatalf("expecting the same endpoint for the same path\n")
+	}
+	if len(admin.RegisteredChannels) != 0 {
+		t.Fatalf("unexpected channels inherited by the endpoint\n")
+	}
+
+	s.CustomFiberApp(fiber.New(fiber.Config{DisableStartupMessage: true}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s\n", err)
+	}
+	s.initWS("/game")
+	go func() { _ = s.App.Listener(ln) }()
+
+	var dial = func(path string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+path, nil)
+		if err != nil {
+			t.Fatalf("unable to dial %s: %s\n", path, err)
+		}
+		return conn
+	}
+	var request = EventMessageToSend{Channel: "test", Name: "testRequest", Body: "important message"}
+	var receive = func(conn *websocket.Conn) EventMessageTest {
+		var response = EventMessageTest{}
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if err := conn.ReadJSON(&response); err != nil {
+			t.Fatalf("unable to read response: %s\n", err)
+		}
+		return response
+	}
+
+	var game, adm = dial("/game"), dial("/admin")
+	defer func() { _ = game.Close() }()
+	defer func() { _ = adm.Close() }()
+	var subscribe = EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: "test"}}
+	if err := game.WriteJSON(subscribe); err != nil {
+		t.Fatalf("unable to subscribe: %s\n", err)
+	}
+	if response := receive(game); response.Name != "edd:channel:subscribed" {
+		t.Fatalf("unexpected subscribe response on /game %s %s\n", response.Channel, response.Name)
+	}
+	if err := game.WriteJSON(request); err != nil {
+		t.Fatalf("unable to send request: %s\n", err)
+	}
+	if response := receive(game); response.Channel != "test" || response.Name != "testResponse" {
+		t.Fatalf("unexpected response on /game %s %s\n", response.Channel, response.Name)
+	}
+	if err := adm.WriteJSON(subscribe); err != nil {
+		t.Fatalf("unable to subscribe: %s\n", err)
+	}
+	var response = receive(adm)
+	var e Error
+	if err := json.Unmarshal(response.Body, &e); err != nil || response.Channel != ErrorsChannel || e.Code != ErrCodeUnknownChannel {
+		t.Fatalf("expecting an unknown channel error on /admin, got %s %s\n", response.Channel, response.Body)
+	}
+
+	s.ClientsMx.RLock()
+	var clients = len(s.Clients)
+	s.ClientsMx.RUnlock()
+	admin.ClientsMx.RLock()
+	var adminClients = len(admin.Clients)
+	admin.ClientsMx.RUnlock()
+	if clients != 1 || adminClients != 1 {
+		t.Fatalf("unexpected clients %d on /game and %d on /admin, expecting 1 and 1\n", clients, adminClients)
+	}
+	if gameId, adminId := s.GetClients()[0].GetId(), admin.GetClients()[0].GetId(); gameId == adminId {
+		t.Fatalf("the clients of /game and /admin share the id %d\n", gameId)
+	}
+
+	if err := s.Close(time.Second); err != nil {
+		t.Fatalf("unable to close server: %s\n", err)
+	}
+	_ = adm.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := adm.ReadMessage(); err == nil {
+		t.Fatalf("expecting the /admin client to be closed along with the server\n")
+	}
+}
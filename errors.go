@@ -0,0 +1,120 @@
+package eddwise
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorsChannel is the channel used to notify errors to clients
+const ErrorsChannel = "errors"
+
+type ErrorCode string
+
+const (
+	ErrCodeInternal       ErrorCode = "internal"
+	ErrCodeBadRequest     ErrorCode = "bad_request"
+	ErrCodeUnknownChannel ErrorCode = "unknown_channel"
+	ErrCodeUnknownEvent   ErrorCode = "unknown_event"
+	ErrCodeAuthFailed     ErrorCode = "auth_failed"
+	ErrCodeForbidden      ErrorCode = "forbidden"
+	ErrCodeConnect        ErrorCode = "connect_failed"
+	ErrCodeTimeout        ErrorCode = "timeout"
+	ErrCodeRateLimited    ErrorCode = "rate_limited"
+	ErrCodeNotSubscribed  ErrorCode = "not_subscribed"
+	ErrCodeAuthExpired    ErrorCode = "auth_expired"
+)
+
+// Error is the envelope sent to clients on the ErrorsChannel. Handlers can return it, directly or wrapped,
+// to control the code and the details notified to the client
+type Error struct {
+	Code      ErrorCode   `json:"code"`
+	Message   string      `json:"message"`
+	Channel   string      `json:"channel,omitempty"`
+	Event     string      `json:"event,omitempty"`
+	RequestId uint64      `json:"request_id,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+
+	err error
+}
+
+func NewError(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// WrapError creates an Error with the given code, keeping err in the chain
+func WrapError(code ErrorCode, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), err: err}
+}
+
+// AsError returns the Error in the chain of err, other errors are mapped to an ErrCodeInternal Error
+func AsError(err error) *Error {
+	return asError(err, ErrCodeInternal)
+}
+
+func asError(err error, defaultCode ErrorCode) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return WrapError(defaultCode, err)
+}
+
+// internalError hides err from the client, which is only notified of an internal error. AsError passes the
+// message of the other errors through, RecoverInterceptor uses it so that the panics are never disclosed
+func internalError(err error) *Error {
+	return &Error{Code: ErrCodeInternal, Message: "internal error", err: err}
+}
+
+func (e *Error) Error() string {
+	if e.err != nil && e.err.Error() != e.Message {
+		return fmt.Sprintf("%s: %s: %s", e.Code, e.Message, e.err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// WithDetails sets additional data about the error to be sent to the client
+func (e *Error) WithDetails(details interface{}) *Error {
+	e.Details = details
+	return e
+}
+
+// withEvent returns a copy of the error with its origin filled when not already set, handlers may return
+// a shared Error
+func (e *Error) withEvent(event *EventMessage) *Error {
+	var c = *e
+	if len(c.Channel) == 0 {
+		c.Channel = event.Channel
+	}
+	if len(c.Event) == 0 {
+		c.Event = event.Name
+	}
+	if c.RequestId == 0 {
+		c.RequestId = event.Id
+	}
+	return &c
+}
+
+// withOrigin returns a copy of the error raised on the channel, and the event if any
+func (e *Error) withOrigin(channel, event string) *Error {
+	var c = *e
+	c.Channel, c.Event = channel, event
+	return &c
+}
+
+func (*Error) GetEventName() string {
+	return "error"
+}
+
+func (*Error) ProtocolAlias() string {
+	return "error"
+}
+
+// SendError notifies the error to the client, as reply of the originating request if any
+func SendError(client Client, err error) error {
+	var e = AsError(err)
+	return client.Reply(ErrorsChannel, e.RequestId, e)
+}
@@ -0,0 +1,31 @@
+package eddwise
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestErrorSentinel(t *testing.T) {
+	var s = NewServer()
+	var ch = &TestChannel{}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unexpected error while registering server: %s\n", err)
+	}
+	var errBusy = NewError(ErrorCode("busy"), "try again later")
+	s.Use(func(ctx Context, ch ImplChannel, event *EventMessage, next EventHandler) error {
+		return errBusy
+	})
+	var client = &ClientSocket{Server: s, codec: s.codec}
+	s.addSubscriber(ch.Alias(), client)
+	var ctx = NewDefaultContextFromBackground(s, client)
+
+	for _, id := range []uint64{1, 2} {
+		var err = s.ProcessEvent(ctx, []byte(fmt.Sprintf(`{"channel":"test","name":"get","id":%d,"body":null}`, id)))
+		if e := AsError(err); e.Code != "busy" || e.RequestId != id || e.Channel != "test" || e.Event != "get" {
+			t.Fatalf("unexpected error %+v for request %d\n", e, id)
+		}
+	}
+	if errBusy.RequestId != 0 || len(errBusy.Channel) != 0 || len(errBusy.Event) != 0 {
+		t.Fatalf("the sentinel error was modified %+v\n", errBusy)
+	}
+}
@@ -0,0 +1,121 @@
+package eddwise
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	fiberws "github.com/gofiber/websocket/v2"
+)
+
+const fiberQueryKey = "edd_query"
+
+func (s *ServerSocket) CustomFiberApp(app *fiber.App) {
+	s.App = app
+}
+
+func (s *ServerSocket) initWS(wsPath string) {
+	if s.App == nil {
+		s.App = fiber.New()
+	}
+
+	for path, dir := range s.registeredStatic {
+		s.App.Static(path, dir)
+	}
+
+	if len(s.metricsPath) > 0 {
+		s.App.Get(s.metricsPath, func(c *fiber.Ctx) error {
+			c.Set(fiber.HeaderContentType, metricsContentType)
+			return s.metrics.WritePrometheus(c)
+		})
+	}
+
+	// the endpoints are mounted first, the main path would otherwise catch the nested ones
+	for _, ep := range s.endpoints {
+		ep.server.mountFiber(s.App, ep.path)
+	}
+	s.mountFiber(s.App, wsPath)
+}
+
+// mountFiber serves the client script, the sse fallback and the websockets of s on wsPath
+func (s *ServerSocket) mountFiber(app *fiber.App, wsPath string) {
+	app.Use(wsPath, func(c *fiber.Ctx) error {
+		if bytes.HasSuffix(c.Request().URI().Path(), []byte("/edd.js")) {
+			c.Response().Header.Add("content-type", "application/javascript")
+			return c.Send(eddclientJS)
+		}
+		s.logger.Debug("http request", "uri", c.Request().URI().String(), "remote_addr", c.IP())
+		var path = c.Request().URI().Path()
+		if bytes.HasSuffix(path, []byte(ssePath)) && c.Method() == fiber.MethodGet {
+			auth, uerr := s.authorizeUpgrade(func() *UpgradeRequest { return fiberUpgradeRequest(c) })
+			if uerr != nil {
+				return fiber.NewError(uerr.Status, uerr.Message)
+			}
+			return s.handleFiberSSE(c, auth)
+		}
+		if bytes.HasSuffix(path, []byte(sseSendPath)) {
+			return c.SendStatus(s.receiveSSE(c.Query(sseConnKey), c.Method(), c.Get(fiber.HeaderContentType), c.Body()))
+		}
+		if fiberws.IsWebSocketUpgrade(c) {
+			auth, uerr := s.authorizeUpgrade(func() *UpgradeRequest { return fiberUpgradeRequest(c) })
+			if uerr != nil {
+				return fiber.NewError(uerr.Status, uerr.Message)
+			}
+			c.Locals("allowed", true)
+			c.Locals(fiberAuthKey, auth)
+			c.Locals(fiberQueryKey, fiberQuery(c))
+			// the upgrader replies with the protocol found in the response headers
+			if protocol := s.selectProtocol(parseProtocols(c.Get(fiber.HeaderSecWebSocketProtocol))); len(protocol) > 0 {
+				c.Set(fiber.HeaderSecWebSocketProtocol, protocol)
+			}
+			return c.Next()
+		}
+		return fiber.ErrUpgradeRequired
+	})
+
+	app.Get(wsPath, fiberws.New(func(c *fiberws.Conn) {
+		query, _ := c.Locals(fiberQueryKey).(url.Values)
+		auth, _ := c.Locals(fiberAuthKey).(*Auth)
+		s.serveConn(s.newWebSocketConn(c.Conn), query, auth)
+	}, fiberws.Config{
+		EnableCompression: true,
+	}))
+}
+
+func fiberQuery(c *fiber.Ctx) url.Values {
+	var query = url.Values{}
+	c.Request().URI().QueryArgs().VisitAll(func(key, value []byte) {
+		query.Add(string(key), string(value))
+	})
+	return query
+}
+
+// handleFiberSSE streams the events once the handler returned, fasthttp reports a gone client on write
+func (s *ServerSocket) handleFiberSSE(c *fiber.Ctx, auth *Auth) error {
+	var query, remoteAddr = fiberQuery(c), c.Context().RemoteAddr().String()
+	if _, ok := s.codecOf(query.Get(protocolQueryKey)); !ok {
+		return fiber.NewError(fiber.StatusBadRequest, "unknown protocol")
+	}
+	setSSEHeaders(c.Set)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		conn, err := newSSEConn(remoteAddr, w, w.Flush)
+		if err != nil {
+			s.logger.Error("sse stream failed", "remote_addr", remoteAddr, "error", err)
+			return
+		}
+		s.serveSSE(conn, query, auth)
+	})
+	return nil
+}
+
+func (s *ServerSocket) StartWS(wsPath string, port int) error {
+	s.initWS(wsPath)
+	return s.App.Listen(fmt.Sprintf(":%d", port))
+}
+
+func (s *ServerSocket) StartWSS(wsPath string, port int, certFile, keyFile string) error {
+	s.initWS(wsPath)
+	return s.App.ListenTLS(fmt.Sprintf(":%d", port), certFile, keyFile)
+}
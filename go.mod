@@ -3,6 +3,7 @@ module github.com/exelr/eddwise
 go 1.18
 
 require (
+	github.com/fasthttp/websocket v1.5.0
 	github.com/gofiber/fiber/v2 v2.35.0
 	github.com/gofiber/websocket/v2 v2.0.23
 	github.com/smartystreets/goconvey v1.6.4
@@ -15,7 +16,6 @@ require (
 
 require (
 	github.com/andybalholm/brotli v1.0.4 // indirect
-	github.com/fasthttp/websocket v1.5.0 // indirect
 	github.com/gopherjs/gopherjs v0.0.0-20181017120253-0766667cb4d1 // indirect
 	github.com/jtolds/gls v4.20.0+incompatible // indirect
 	github.com/klauspost/compress v1.15.9 // indirect
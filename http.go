@@ -0,0 +1,114 @@
+package eddwise
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fasthttp/websocket"
+)
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the registered channels to any net/http router, it upgrades the websocket connections of
+// the path it is mounted on, serves the javascript client on <path>/edd.js and the fallback transport on
+// <path>/edd/sse and <path>/edd/send.
+// As the fiber integration, it accepts connections from any origin
+func (s *ServerSocket) Handler() http.Handler {
+	var upgrader = &websocket.Upgrader{
+		EnableCompression: true,
+		CheckOrigin: func(*http.Request) bool {
+			return true
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/edd.js") {
+			w.Header().Set("content-type", "application/javascript")
+			_, _ = w.Write(eddclientJS)
+			return
+		}
+		s.logger.Debug("http request", "uri", r.URL.String(), "remote_addr", r.RemoteAddr)
+		if strings.HasSuffix(r.URL.Path, ssePath) && r.Method == http.MethodGet {
+			auth, uerr := s.authorizeUpgrade(func() *UpgradeRequest { return httpUpgradeRequest(r) })
+			if uerr != nil {
+				http.Error(w, uerr.Message, uerr.Status)
+				return
+			}
+			s.handleSSE(w, r, auth)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, sseSendPath) {
+			var body io.Reader = r.Body
+			if s.maxMessageSize > 0 {
+				// one more byte than allowed is enough for receiveSSE to reject the post
+				body = io.LimitReader(r.Body, s.maxMessageSize+1)
+			}
+			data, err := io.ReadAll(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(s.receiveSSE(r.URL.Query().Get(sseConnKey), r.Method, r.Header.Get("content-type"), data))
+			return
+		}
+		if !websocket.IsWebSocketUpgrade(r) {
+			http.Error(w, http.StatusText(http.StatusUpgradeRequired), http.StatusUpgradeRequired)
+			return
+		}
+		auth, uerr := s.authorizeUpgrade(func() *UpgradeRequest { return httpUpgradeRequest(r) })
+		if uerr != nil {
+			http.Error(w, uerr.Message, uerr.Status)
+			return
+		}
+		var header http.Header
+		if protocol := s.selectProtocol(websocket.Subprotocols(r)); len(protocol) > 0 {
+			header = http.Header{"Sec-Websocket-Protocol": {protocol}}
+		}
+		conn, err := upgrader.Upgrade(w, r, header)
+		if err != nil {
+			// the upgrader already replied with an error
+			s.logger.Debug("websocket upgrade failed", "remote_addr", r.RemoteAddr, "error", err)
+			return
+		}
+		s.serveConn(s.newWebSocketConn(conn), r.URL.Query(), auth)
+	})
+}
+
+func (s *ServerSocket) handleSSE(w http.ResponseWriter, r *http.Request, auth *Auth) {
+	if _, ok := s.codecOf(r.URL.Query().Get(protocolQueryKey)); !ok {
+		http.Error(w, "unknown protocol", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	conn, err := newSSEConn(r.RemoteAddr, w, func() error {
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setSSEHeaders(w.Header().Set)
+	w.WriteHeader(http.StatusOK)
+	// net/http reports write errors only once the client is gone for good, the request context is quicker
+	go func() {
+		select {
+		case <-r.Context().Done():
+			conn.closeWith(ErrSSEStreamLost)
+		case <-conn.done:
+		}
+	}()
+	s.serveSSE(conn, r.URL.Query(), auth)
+}
+
+// MetricsHandler exposes the metrics in prometheus text format
+func (s *ServerSocket) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("content-type", metricsContentType)
+		_ = s.metrics.WritePrometheus(w)
+	})
+}
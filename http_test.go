@@ -0,0 +1,89 @@
+package eddwise
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var ch = &TestChannel{}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var mux = http.NewServeMux()
+	mux.Handle("/test", s.Handler())
+	mux.Handle("/test/", s.Handler())
+	var srv = httptest.NewServer(mux)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/test/edd.js")
+	if err != nil || res.StatusCode != http.StatusOK || res.Header.Get("content-type") != "application/javascript" {
+		t.Fatalf("unable to get the javascript client: %v %v\n", err, res)
+	}
+	_ = res.Body.Close()
+	if res, err = http.Get(srv.URL + "/test"); err != nil || res.StatusCode != http.StatusUpgradeRequired {
+		t.Fatalf("expecting upgrade required: %v %v\n", err, res)
+	}
+	_ = res.Body.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/test", "", srv.URL)
+	if err != nil {
+		t.Fatalf("unable to init websocket client: %s\n", err)
+	}
+	if err := websocket.JSON.Send(conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: "test"}}); err != nil {
+		t.Fatalf("unable to subscribe: %s\n", err)
+	}
+	var subscribed = EventMessageTest{}
+	if err := websocket.JSON.Receive(conn, &subscribed); err != nil || subscribed.Name != "edd:channel:subscribed" {
+		t.Fatalf("unexpected subscribe response %s %v\n", subscribed.Name, err)
+	}
+	if err := websocket.JSON.Send(conn, EventMessageToSend{Channel: "test", Name: "testRequest", Body: "important message"}); err != nil {
+		t.Fatalf("unable to send message through socket: %s\n", err)
+	}
+	var response = EventMessageTest{}
+	if err := websocket.JSON.Receive(conn, &response); err != nil {
+		t.Fatalf("unable to receive message through socket: %s\n", err)
+	}
+	if response.Channel != "test" || response.Name != "testResponse" || string(response.Body) != `"B"` {
+		t.Fatalf("unexpected response %s %s %s\n", response.Channel, response.Name, response.Body)
+	}
+	if !ch.GetConnected() {
+		t.Fatalf("Connect() method was not called\n")
+	}
+	_ = conn.Close()
+	if err := s.Close(0); err != nil {
+		t.Fatalf("unable to close server: %s\n", err)
+	}
+}
+
+func TestHTTPMaxMessageSize(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetMaxMessageSize(128)
+	if err := s.Register(&TestChannel{}); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var srv = httptest.NewServer(s.Handler())
+	defer srv.Close()
+	defer func() { _ = s.Close(0) }()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/test", "", srv.URL)
+	if err != nil {
+		t.Fatalf("unable to init websocket client: %s\n", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if err := websocket.Message.Send(conn, `{"channel":"test","name":"testRequest","body":"`+strings.Repeat("x", 4096)+`"}`); err != nil {
+		t.Fatalf("unable to send message through socket: %s\n", err)
+	}
+	// the connection sending an oversized message is closed
+	var msg string
+	if err := websocket.Message.Receive(conn, &msg); err == nil {
+		t.Fatalf("expecting the connection to be closed, got %s\n", msg)
+	}
+}
@@ -0,0 +1,65 @@
+package eddwise
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Interceptor wraps the routing of an event received on a channel. It can inspect or change the event,
+// short-circuit the routing by not calling next, or change the returned error
+type Interceptor func(ctx Context, ch ImplChannel, event *EventMessage, next EventHandler) error
+
+// SendHandler writes a message to a client
+type SendHandler func(client Client, msg *EventMessageToSend) error
+
+// OutboundInterceptor wraps every message sent to a client, including each recipient of a Broadcast.
+// The recipients of a Broadcast share the message, which is encoded once per codec: an interceptor must
+// pass a new message to next instead of modifying it
+type OutboundInterceptor func(client Client, msg *EventMessageToSend, next SendHandler) error
+
+// Use appends interceptors to the chain wrapping the routing of the received events, the auth events included,
+// the first registered interceptor is the outermost. The events of the system channel, such as the subscriptions
+// and the keepalives, are not intercepted, nor the ones rejected before reaching a channel
+func (s *ServerSocket) Use(interceptors ...Interceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
+// UseOutbound appends interceptors to the chain wrapping the messages sent to clients,
+// the first registered interceptor is the outermost
+func (s *ServerSocket) UseOutbound(interceptors ...OutboundInterceptor) {
+	s.outboundInterceptors = append(s.outboundInterceptors, interceptors...)
+}
+
+func chainInterceptors(interceptors []Interceptor, ch ImplChannel, handler EventHandler) EventHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		var interceptor, next = interceptors[i], handler
+		handler = func(ctx Context, event *EventMessage) error {
+			return interceptor(ctx, ch, event, next)
+		}
+	}
+	return handler
+}
+
+func chainOutboundInterceptors(interceptors []OutboundInterceptor, handler SendHandler) SendHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		var interceptor, next = interceptors[i], handler
+		handler = func(client Client, msg *EventMessageToSend) error {
+			return interceptor(client, msg, next)
+		}
+	}
+	return handler
+}
+
+// RecoverInterceptor turns a panic raised while routing an event into an internal error, the panic and its stack
+// are logged by the server only
+func RecoverInterceptor(ctx Context, ch ImplChannel, event *EventMessage, next EventHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if s, ok := ctx.GetServer().(*ServerSocket); ok {
+				s.logger.Error("panic while routing event", "channel", ch.Alias(), "event", event.Name, "panic", r, "stack", string(debug.Stack()))
+			}
+			err = internalError(fmt.Errorf("panic while routing %s on channel %s: %v", event.Name, ch.Alias(), r))
+		}
+	}()
+	return next(ctx, event)
+}
@@ -0,0 +1,128 @@
+package eddwise
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestInterceptorsChain(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var ch = &TestChannel{}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unexpected error while registering server: %s\n", err)
+	}
+	var calls []string
+	var errForbidden = errors.New("forbidden")
+	s.Use(RecoverInterceptor, func(ctx Context, ch ImplChannel, event *EventMessage, next EventHandler) error {
+		calls = append(calls, "first:"+ch.Alias()+":"+event.Name)
+		return next(ctx, event)
+	}, func(ctx Context, ch ImplChannel, event *EventMessage, next EventHandler) error {
+		calls = append(calls, "second")
+		if event.Name == "forbidden" {
+			return errForbidden
+		}
+		if event.Name == "panic" {
+			panic("boom")
+		}
+		return next(ctx, event)
+	})
+	var client = &ClientSocket{Server: s, codec: s.codec}
+	s.addSubscriber(ch.Alias(), client)
+	var ctx = NewDefaultContextFromBackground(s, client)
+
+	var err = s.ProcessEvent(ctx, []byte(`{"channel":"test","name":"forbidden","body":null}`))
+	if !errors.Is(err, errForbidden) {
+		t.Fatalf("expecting the interceptor to short-circuit the routing, got %v\n", err)
+	}
+	if e := AsError(err); e.Code != ErrCodeInternal || e.Message != "forbidden" {
+		t.Fatalf("unexpected error sent to the client %+v\n", e)
+	}
+	if len(calls) != 2 || calls[0] != "first:test:forbidden" || calls[1] != "second" {
+		t.Fatalf("unexpected interceptors calls %v\n", calls)
+	}
+	err = s.ProcessEvent(ctx, []byte(`{"channel":"test","name":"panic","body":null}`))
+	if err == nil {
+		t.Fatalf("expecting the panic to be recovered as an error\n")
+	}
+	if e := AsError(err); e.Code != ErrCodeInternal || e.Message != "internal error" {
+		t.Fatalf("the panic was disclosed to the client %+v\n", e)
+	}
+}
+
+func TestInterceptorsAuthEvents(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	if err := s.Register(&authTestChannel{}); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var mx sync.Mutex
+	var calls []string
+	s.Use(func(ctx Context, ch ImplChannel, event *EventMessage, next EventHandler) error {
+		mx.Lock()
+		calls = append(calls, ch.Alias()+":"+event.Name)
+		mx.Unlock()
+		return next(ctx, event)
+	})
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(serverConn, nil)
+	defer func() { _ = conn.Close() }()
+	sendJSON(t, conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: "private"}})
+	expectJSON(t, conn, "private", "edd:auth:challenge")
+	// the auth of the pending subscription
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:basic", Body: &BasicAuth{Username: "alice", Password: "secret"}})
+	expectSkippingUsers(t, conn, "private", "edd:auth:pass")
+	expectJSON(t, conn, SystemChannel, "edd:channel:subscribed")
+	// the refresh of the auth of the subscribed client
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:basic", Body: &BasicAuth{Username: "bob", Password: "secret"}})
+	expectSkippingUsers(t, conn, "private", "edd:auth:pass")
+
+	mx.Lock()
+	defer mx.Unlock()
+	if len(calls) != 2 || calls[0] != "private:edd:auth:basic" || calls[1] != "private:edd:auth:basic" {
+		t.Fatalf("unexpected interceptors calls %v, the system events are not intercepted\n", calls)
+	}
+}
+
+func TestOutboundInterceptorsChain(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var calls []string
+	var errDropped = errors.New("dropped")
+	s.UseOutbound(func(client Client, msg *EventMessageToSend, next SendHandler) error {
+		calls = append(calls, "first:"+msg.Name)
+		return next(client, msg)
+	}, func(client Client, msg *EventMessageToSend, next SendHandler) error {
+		calls = append(calls, "second")
+		if msg.Body == TestResponse("drop") {
+			return errDropped
+		}
+		var replaced = *msg
+		replaced.Body = TestResponse("intercepted")
+		return next(client, &replaced)
+	})
+	var serverConn, conn = NewPipe()
+	defer func() { _ = conn.Close() }()
+	var c = &ClientSocket{Server: s, Conn: serverConn, codec: s.codec, queue: newOutboundQueue(8)}
+	c.attach()
+
+	if err := c.Send("test", TestResponse("drop")); !errors.Is(err, errDropped) {
+		t.Fatalf("expecting the interceptor to drop the message, got %v\n", err)
+	}
+	if err := c.Send("test", TestResponse("A")); err != nil {
+		t.Fatalf("unable to send: %s\n", err)
+	}
+	// the recipients of a broadcast are intercepted too
+	if err := c.broadcast(newEncodeOnce(&EventMessageToSend{Channel: "test", Name: "testResponse", Body: TestResponse("B")})); err != nil {
+		t.Fatalf("unable to broadcast: %s\n", err)
+	}
+	for i := 0; i < 2; i++ {
+		if msg := expectJSON(t, conn, "test", "testResponse"); string(msg.Body) != `"intercepted"` {
+			t.Fatalf("unexpected body %s, expecting the one replaced by the interceptor\n", msg.Body)
+		}
+	}
+	if len(calls) != 6 || calls[0] != "first:testResponse" || calls[1] != "second" || calls[4] != "first:testResponse" {
+		t.Fatalf("unexpected interceptors calls %v\n", calls)
+	}
+}
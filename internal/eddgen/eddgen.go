@@ -16,7 +16,8 @@ type Design struct {
 	Channels []*Channel
 	Structs  []*Struct
 
-	structMap map[string]*Struct
+	structMap   map[string]*Struct
+	protoLocked bool
 }
 
 func (design *Design) ValidateType(_type Type) error {
@@ -74,6 +75,40 @@ func (design *Design) Validate() error {
 		}
 	}
 
+	//validate if rate limits are attached to client events
+	for _, eddCh := range design.Channels {
+		var clientEvents = eddCh.GetDirectionEvents(ClientToServer)
+		for ev := range eddCh.RateLimits {
+			if clientEvents[ev] == nil {
+				return fmt.Errorf("event '%s' is rate limited but cannot be sent from client in channel '%s'", ev, eddCh.Name)
+			}
+		}
+	}
+
+	//validate if policies are attached to enabled events
+	for _, eddCh := range design.Channels {
+		var mEnabled = eddCh.EnabledMap()
+		for ev := range eddCh.Policies {
+			if mEnabled[ev] == nil {
+				return fmt.Errorf("event '%s' has a policy but is not enabled in channel '%s'", ev, eddCh.Name)
+			}
+		}
+	}
+
+	//validate if replies can be sent from server and are attached to client events
+	for _, eddCh := range design.Channels {
+		var clientEvents = eddCh.GetDirectionEvents(ClientToServer)
+		var serverEvents = eddCh.GetDirectionEvents(ServerToClient)
+		for ev, reply := range eddCh.Replies {
+			if clientEvents[ev] == nil {
+				return fmt.Errorf("event '%s' expects a reply but cannot be sent from client in channel '%s'", ev, eddCh.Name)
+			}
+			if serverEvents[reply.Name] == nil {
+				return fmt.Errorf("reply '%s' of event '%s' cannot be sent from server in channel '%s'", reply.Name, ev, eddCh.Name)
+			}
+		}
+	}
+
 	var structs = design.StructsMap()
 
 	//validate if enabled type exists
@@ -174,21 +209,28 @@ func New{{ $ch.GoName }}Channel() *{{ $ch.GoName }}Channel {
 }
 
 func (ch *{{ $ch.GoName }}Channel) Connected(c eddwise.Client) error {
-	log.Println("User connected", c.GetId())
+	log.Println("User subscribed", c.GetId())
 	return nil
 }
 
 func (ch *{{ $ch.GoName }}Channel) Disconnected(c eddwise.Client) error {
-	log.Println("User disconnected", c.GetId())
+	log.Println("User unsubscribed", c.GetId())
 	return nil
 }
 
 {{ range $ev, $_ := $ch.GetDirectionEvents "ClientToServer" }}
+{{- with $ch.Reply $ev }}
+func (ch *{{ $ch.GoName }}Channel) On{{ $ev | goname }} (ctx eddwise.Context, {{ $ev | LowerFirst }} *{{ $Name }}.{{ $ev | goname }}) (*{{ $Name }}.{{ .GoName }}, error) {
+	log.Println("received request {{ $ev | goname }}:", {{ $ev | LowerFirst }}, "from", ctx.GetClient().GetId() ) 
+	return &{{ $Name }}.{{ .GoName }}{}, nil
+}
+{{ else }}
 func (ch *{{ $ch.GoName }}Channel) On{{ $ev | goname }} (ctx eddwise.Context, {{ $ev | LowerFirst }} *{{ $Name }}.{{ $ev | goname }}) error {
 	log.Println("received event {{ $ev | goname }}:", {{ $ev | LowerFirst }}, "from", ctx.GetClient().GetId() ) 
 	return nil
 }
 {{ end }}
+{{- end }}
 {{ end }}
 `
 
@@ -329,17 +371,28 @@ package {{ .Name }}
 
 import(
 	"errors"
+{{- if .HasPooledEvents }}
+	"sync"
+{{- end }}
 
 	"github.com/exelr/eddwise"
 )
 {{ range $ch := .Channels }}
 var _ eddwise.ImplChannel = (*{{ $ch.GoName }})(nil)
+var _ eddwise.ImplChannelEvents = (*{{ $ch.GoName }})(nil)
+var _ eddwise.ImplChannelGuard = (*{{ $ch.GoName }})(nil)
 var _ {{ $ch.GoName }}Recv = (*{{ $ch.GoName }})(nil)
 {{ end }}
 {{ range $ch := .Channels }}
+// {{ $ch.GoName }}Recv handles the client events, the ones declared with the pool tag are pooled: handlers must not
+// keep them once returned
 type {{ $ch.GoName }}Recv interface {
 {{- range $ev, $_ := $ch.GetDirectionEvents "ClientToServer" }}
+	{{- with $ch.Reply $ev }}
+	On{{ $ev | goname }}(eddwise.Context, *{{ $ev | goname }}) (*{{ .GoName }}, error)
+	{{- else }}
 	On{{ $ev | goname }}(eddwise.Context, *{{ $ev | goname }}) error
+	{{- end }}
 {{- end }}
 }
 
@@ -373,40 +426,145 @@ func (ch *{{ $ch.GoName }}) GetServer() eddwise.Server {
 	return ch.server
 }
 
+// Clients returns the clients subscribed to the channel
+func (ch *{{ $ch.GoName }}) Clients(exclude ...uint64) []eddwise.Client {
+	return ch.server.GetChannelClients(ch.Alias(), exclude...)
+}
+
+// Guard enforces the policy and the rate limit of the whole channel, the server runs it before the room events.
+// The policy comes first: a client that is not allowed does not spend its tokens
+func (ch *{{ $ch.GoName }}) Guard(ctx eddwise.Context) error {
+{{- with $ch.Policy }}
+	if err := eddwise.Authorize(ctx, ch.Alias(), "", {{ .GoLiteral }}); err != nil {
+		return err
+	}
+{{- end }}
+{{- with $ch.RateLimit }}
+	if err := eddwise.LimitRate(ctx, ch.Alias(), "", {{ .GoLiteral }}); err != nil {
+		return err
+	}
+{{- end }}
+	return nil
+}
+
+// Route checks the policies of the channel, of the event and of its reply before the rate limits, the token of the
+// event is given back when the limit of the channel rejects it
 func (ch *{{ $ch.GoName }}) Route(ctx eddwise.Context, evt *eddwise.EventMessage) error {
+{{- with $ch.Policy }}
+	if err := eddwise.Authorize(ctx, ch.Alias(), "", {{ .GoLiteral }}); err != nil {
+		return err
+	}
+{{- end }}
 	switch evt.Name {
 	default:
 		return eddwise.ErrMissingServerHandler(evt.Channel, evt.Name)
 {{ range $ev, $evData := $ch.GetDirectionEvents "ClientToServer" }}
 	// {{ $ev }}
 	case "{{ $evData.ProtocolAlias }}":
-		var msg = &{{ $ev | goname }}{}
-		if err := ch.server.Codec().Decode(evt.Body, msg); err != nil {
+	{{- with $ch.EventPolicy $ev }}
+		if err := eddwise.Authorize(ctx, ch.Alias(), evt.Name, {{ .GoLiteral }}); err != nil {
+			return err
+		}
+	{{- end }}
+	{{- with $ch.ReplyPolicy $ev }}
+		if err := eddwise.Authorize(ctx, ch.Alias(), "{{ ($ch.Reply $ev).ProtocolAlias }}", {{ .GoLiteral }}); err != nil {
+			return err
+		}
+	{{- end }}
+	{{- with $ch.EventRateLimit $ev }}
+		if err := eddwise.LimitRate(ctx, ch.Alias(), evt.Name, {{ .GoLiteral }}); err != nil {
+			return err
+		}
+	{{- end }}
+	{{- with $ch.RateLimit }}
+		if err := eddwise.LimitRate(ctx, ch.Alias(), "", {{ .GoLiteral }}); err != nil {
+		{{- with $ch.EventRateLimit $ev }}
+			eddwise.RefundRate(ctx, ch.Alias(), evt.Name, {{ .GoLiteral }})
+		{{- end }}
 			return err
 		}
+	{{- end }}
+	{{- if $.PooledEvent $ev }}
+		msg, err := eddwise.DecodeEvent(evt, acquire{{ $ev | goname }})
+		defer release{{ $ev | goname }}(msg)
+	{{- else }}
+		msg, err := eddwise.DecodeEvent(evt, func() *{{ $ev | goname }} { return new({{ $ev | goname }}) })
+	{{- end }}
+		if err != nil {
+			return eddwise.WrapError(eddwise.ErrCodeBadRequest, err)
+		}
 		if err := msg.CheckReceivedFields(); err != nil {
+			return eddwise.WrapError(eddwise.ErrCodeBadRequest, err)
+		}
+	{{- if $ch.Reply $ev }}
+		reply, err := ch.recv.On{{ $ev | goname }}(ctx, msg)
+		if err != nil {
 			return err
 		}
+		if reply == nil {
+			return eddwise.NewError(eddwise.ErrCodeInternal, "empty reply for request '{{ $ev | goname }}'")
+		}
+		return ctx.GetClient().Reply(ch.Alias(), evt.Id, reply)
+	{{- else }}
 		return ch.recv.On{{ $ev | goname }}(ctx, msg)
+	{{- end }}
+{{- end }}
+	}
+}
+
+// NewEvent returns the event to decode the body of a client event into, the pooled ones are released once handled
+func (ch *{{ $ch.GoName }}) NewEvent(name string) eddwise.Event {
+	switch name {
+{{- range $ev, $evData := $ch.GetDirectionEvents "ClientToServer" }}
+	case "{{ $evData.ProtocolAlias }}":
+	{{- if $.PooledEvent $ev }}
+		return acquire{{ $ev | goname }}()
+	{{- else }}
+		return new({{ $ev | goname }})
+	{{- end }}
 {{- end }}
 	}
+	return nil
 }
 
 {{ range $ev, $_ := $ch.GetDirectionEvents "ClientToServer" }}
+{{- with $ch.Reply $ev }}
+func (ch *{{ $ch.GoName }}) On{{ $ev | goname }}(eddwise.Context, *{{ $ev | goname }}) (*{{ .GoName }}, error) {
+	return nil, eddwise.NewError(eddwise.ErrCodeUnknownEvent, "request '{{ $ev | goname }}' is not handled on server")
+}
+{{ else }}
 func (ch *{{ $ch.GoName }}) On{{ $ev | goname }}(eddwise.Context, *{{ $ev | goname }}) error {
-	return errors.New("event '{{ $ev | goname }}' is not handled on server")
+	return eddwise.NewError(eddwise.ErrCodeUnknownEvent, "event '{{ $ev | goname }}' is not handled on server")
 }
 {{ end }}
+{{- end }}
 
 {{ range $ev, $_ := $ch.GetDirectionEvents "ServerToClient" }}
+{{- with $ch.BroadcastPolicies $ev }}
+// Send{{ $ev | goname }} sends the event to the client if it satisfies its policies, a forbidden error is returned otherwise
+func (ch *{{ $ch.GoName }}) Send{{ $ev | goname }}(client eddwise.Client, msg *{{ $ev | goname }}) error {
+	if len(eddwise.AuthorizedClients(ch.server, []eddwise.Client{client}, {{ . }})) == 0 {
+		return eddwise.NewError(eddwise.ErrCodeForbidden, "client %d is not allowed to receive '{{ $ev }}' in channel %s", client.GetId(), ch.Alias())
+	}
+	return client.Send(ch.Alias(), msg)
+}
+{{- else }}
 func (ch *{{ $ch.GoName }}) Send{{ $ev | goname }}(client eddwise.Client, msg *{{ $ev | goname }}) error {
 	return client.Send(ch.Alias(), msg)
 }
+{{- end }}
 {{ end }}
 {{ range $ev, $_ := $ch.GetDirectionEvents "ServerToClient" }}
+{{- with $ch.BroadcastPolicies $ev }}
+// Broadcast{{ $ev | goname }} sends the event to the clients satisfying its policies only
+func (ch *{{ $ch.GoName }}) Broadcast{{ $ev | goname }}(clients []eddwise.Client, msg *{{ $ev | goname }}) error {
+	return eddwise.Broadcast(ch.Alias(), msg, eddwise.AuthorizedClients(ch.server, clients, {{ . }}))
+}
+{{- else }}
 func (ch *{{ $ch.GoName }}) Broadcast{{ $ev | goname }}(clients []eddwise.Client, msg *{{ $ev | goname }}) error {
 	return eddwise.Broadcast(ch.Alias(), msg, clients)
 }
+{{- end }}
 {{ end }}
 
 {{ end }}
@@ -447,13 +605,28 @@ func (evt *{{ $st.GoName }}) Set{{ $field.GoName }}({{ $field.Name }} {{ $field.
 	return evt
 }
 {{- end }}
+{{- if $.PooledEvent $st.Name }}
+
+var {{ $st.GoName | LowerFirst }}Pool = sync.Pool{New: func() interface{} { return new({{ $st.GoName }}) }}
+
+func acquire{{ $st.GoName }}() *{{ $st.GoName }} {
+	return {{ $st.GoName | LowerFirst }}Pool.Get().(*{{ $st.GoName }})
+}
+
+// release{{ $st.GoName }} resets an event received from a client and puts it back in the pool, handlers must not keep it
+func release{{ $st.GoName }}(evt *{{ $st.GoName }}) {
+	*evt = {{ $st.GoName }}{}
+	{{ $st.GoName | LowerFirst }}Pool.Put(evt)
+}
+{{- end }}
 
 {{ end }}
 `
 
 	tmpl, err := template.New("serverTmpl").Funcs(template.FuncMap{
-		"TrimSpace": strings.TrimSpace,
-		"goname":    GoName,
+		"TrimSpace":  strings.TrimSpace,
+		"goname":     GoName,
+		"LowerFirst": LowerFirst,
 	}).Parse(serverTmpl)
 	if err != nil {
 		return err
@@ -498,7 +671,18 @@ func (cb *{{ $ch.GoName }}Behave) Recv() {{ $Name }}.{{ $ch.GoName }}Recv {
 func (cb *{{ $ch.GoName }}Behave) On{{ $ev | goname }}(clientId uint64, evt *{{ $Name }}.{{ $ev | goname }}, f ...func()) {
 	cb.On(clientId,
 		func(ctx eddwise.Context) error {
+		{{- if $ch.Reply $ev }}
+			reply, err := cb.Recv().On{{ $ev | goname }}(ctx, evt)
+			if err != nil {
+				return err
+			}
+			if reply == nil {
+				return eddwise.NewError(eddwise.ErrCodeInternal, "empty reply for request '{{ $ev | goname }}'")
+			}
+			return ctx.GetClient().Reply("{{ $ch.ProtocolAlias }}", 0, reply)
+		{{- else }}
 			return cb.Recv().On{{ $ev | goname }}(ctx, evt)
+		{{- end }}
 		}, evt, f...)
 }
 {{ end }}
@@ -527,8 +711,9 @@ func (design *Design) GenerateClient(w io.Writer) error {
 {{ range $struct := .Structs -}}
 {{ $struct.JsDef }}
 {{ end -}}
-import {EddChannel} from "/{{ .Name }}/edd.js";
-{{ range $ch := .Channels }}
+import {EddChannel, EddProtoReader, EddProtoWriter} from "/{{ .Name }}/edd.js";
+
+{{ JsProto }}{{ range $ch := .Channels }}
 class {{ .Name }}Channel extends EddChannel {
 	constructor() {
 		super("{{ $ch.ProtocolAlias }}")
@@ -630,20 +815,49 @@ class {{ .Name }}Channel extends EddChannel {
 		{{- end }}
         return this.client.send({channel:this.getAlias(), name:"{{ $eventData.ProtocolAlias }}", body: message});
     }
+{{- with $ch.Reply $event }}
+    /**
+     * @function {{ $ch.Name }}Channel#request{{ $event }}
+     * @param {{ "{" }}{{ $event }}{{ "}" }} message
+     * @param {number} [timeout] - milliseconds to wait for the reply
+     * @return {{ "{" }}Promise<{{ .Name }}>{{ "}" }} resolved with the reply or rejected with the server error
+     */
+    request{{ $event }} = function(message, timeout) {
+		{{- range $field := $eventData.Fields -}}
+			{{- if ne $field.Name $field.ProtocolAlias }}
+		Object.defineProperty(message, "{{ $field.ProtocolAlias }}", Object.getOwnPropertyDescriptor(message, "{{ $field.Name }}")); delete message["{{ $field.Name }}"];
+			{{- end }}
+		{{- end }}
+        return this.client.request({channel:this.getAlias(), name:"{{ $eventData.ProtocolAlias }}", body: message}, timeout).then((reply) => {
+            if(reply.name !== "{{ .ProtocolAlias }}") {
+                throw "unexpected reply '" + reply.name + "' for request '{{ $event }}'"
+            }
+            let event = reply.body
+		{{- range $field := .Fields -}}
+			{{- if ne $field.Name $field.ProtocolAlias }}
+            Object.defineProperty(event, "{{ $field.Name }}", Object.getOwnPropertyDescriptor(event, "{{ $field.ProtocolAlias }}")); delete event["{{ $field.ProtocolAlias }}"];
+			{{- end }}
+		{{- end }}
+            return event
+        });
+    }
+{{- end }}
 {{ end }}
 }
 {{ end }}
 export {
-{{- range $i, $ch := .Channels -}}
-	{{- if eq $i 0 -}}
-	{{ .Name }}Channel
-	{{- else -}}{{ .Name }}Channel,
-	{{- end -}}
+{{- range $ch := .Channels -}}
+	{{ .Name }}Channel,
 {{- end -}}
-}
+registerProtobuf}
 `
 	var tmpl, err = template.New("clientTmpl").Funcs(template.FuncMap{
 		"TrimSpace": strings.TrimSpace,
+		"JsProto": func() (string, error) {
+			var buf = bytes.NewBuffer(nil)
+			err := design.writeJsProto(buf)
+			return buf.String(), err
+		},
 	}).Parse(clientTmpl)
 	if err != nil {
 		return err
@@ -663,6 +877,30 @@ func (design *Design) ChannelsMap() map[string]*Channel {
 	return ret
 }
 
+// PooledEvent reports whether the struct is declared with the pool tag and sent by the clients on any channel, only
+// those are decoded into pooled events
+func (design *Design) PooledEvent(name string) bool {
+	if st := design.StructsMap()[name]; st == nil || !st.Tags.Pool {
+		return false
+	}
+	for _, ch := range design.Channels {
+		if _, ok := ch.GetDirectionEvents(ClientToServer)[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPooledEvents reports whether any client event is decoded into pooled events
+func (design *Design) HasPooledEvents() bool {
+	for _, st := range design.Structs {
+		if design.PooledEvent(st.Name) {
+			return true
+		}
+	}
+	return false
+}
+
 func (design *Design) StructsMap() map[string]*Struct {
 	if design.structMap == nil {
 		design.structMap = make(map[string]*Struct)
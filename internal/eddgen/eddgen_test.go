@@ -0,0 +1,162 @@
+package eddgen
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGenerateBuilds generates the server code of each design in testdata, vets it and runs the tests found beside
+// the design against it with the go command, the code is written inside the module so that it can import eddwise
+func TestGenerateBuilds(t *testing.T) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	var root = filepath.Join("..", "..")
+	designs, err := filepath.Glob(filepath.Join(root, "testdata", "*", "*.edd.yml"))
+	if err != nil || len(designs) == 0 {
+		t.Fatalf("no design found in testdata: %v\n", err)
+	}
+	out, err := os.MkdirTemp(filepath.Join(root, "testdata"), "_gen")
+	if err != nil {
+		t.Fatalf("unable to create output directory: %s\n", err)
+	}
+	defer func() { _ = os.RemoveAll(out) }()
+	var module = "github.com/exelr/eddwise/testdata/" + filepath.Base(out)
+	// the go command does not match directories starting with _ in patterns, packages are listed one by one
+	var packages []string
+
+	for _, path := range designs {
+		design, err := ParseAndValidateYamls(module, path)
+		if err != nil {
+			t.Fatalf("unable to parse %s: %s\n", path, err)
+		}
+		design.ApplyProtoLock(&ProtoLock{})
+		var dir = filepath.Join(out, "gen", design.Name)
+		var pkg = "./testdata/" + filepath.Base(out) + "/gen/" + design.Name
+		packages = append(packages, pkg, pkg+"/behave")
+		for file, generate := range map[string]func(io.Writer) error{
+			"channel.go":        design.GenerateServer,
+			"proto.go":          design.GenerateServerProto,
+			"behave/channel.go": design.GenerateServerTest,
+		} {
+			if err := generateFile(filepath.Join(dir, file), generate); err != nil {
+				t.Fatalf("unable to generate %s of %s: %s\n", file, path, err)
+			}
+		}
+		tests, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*_test.go"))
+		for _, test := range tests {
+			data, err := os.ReadFile(test)
+			if err != nil {
+				t.Fatalf("unable to read %s: %s\n", test, err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, filepath.Base(test)), data, 0644); err != nil {
+				t.Fatalf("unable to copy %s: %s\n", test, err)
+			}
+		}
+	}
+
+	for _, command := range []string{"vet", "test"} {
+		var cmd = exec.Command(goBin, append([]string{command}, packages...)...)
+		cmd.Dir = root
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("generated code does not pass go %s: %s\n%s\n", command, err, output)
+		}
+	}
+}
+
+func TestGenerateServerPools(t *testing.T) {
+	design, err := ParseAndValidateYamls("nested", filepath.Join("..", "..", "testdata", "nested", "design.edd.yml"))
+	if err != nil {
+		t.Fatalf("unable to parse the design: %s\n", err)
+	}
+	var buf = bytes.NewBuffer(nil)
+	if err := design.GenerateServer(buf); err != nil {
+		t.Fatalf("unable to generate the server: %s\n", err)
+	}
+	var code = buf.String()
+	// xd is pooled, coords is sent by the clients without the pool tag and client only by the server
+	if !strings.Contains(code, "func acquireXd(") || !strings.Contains(code, "defer releaseXd(msg)") {
+		t.Fatalf("the pooled event xd is not released once handled\n")
+	}
+	for _, name := range []string{"Coords", "Client"} {
+		if strings.Contains(code, "func acquire"+name+"(") || strings.Contains(code, LowerFirst(name)+"Pool") {
+			t.Fatalf("the event %s is pooled\n", name)
+		}
+	}
+}
+
+func TestGenerateServerReply(t *testing.T) {
+	design, err := ParseAndValidateYamls("nested", filepath.Join("..", "..", "testdata", "nested", "design.edd.yml"))
+	if err != nil {
+		t.Fatalf("unable to parse the design: %s\n", err)
+	}
+	var buf = bytes.NewBuffer(nil)
+	if err := design.GenerateServer(buf); err != nil {
+		t.Fatalf("unable to generate the server: %s\n", err)
+	}
+	var code = buf.String()
+	// xd expects client as reply, sent back with the id of the request, the unhandled events are notified as unknown
+	for _, part := range []string{
+		"OnXd(eddwise.Context, *Xd) (*Client, error)",
+		"ctx.GetClient().Reply(ch.Alias(), evt.Id, reply)",
+		`eddwise.NewError(eddwise.ErrCodeInternal, "empty reply for request 'Xd'")`,
+		`eddwise.NewError(eddwise.ErrCodeUnknownEvent, "request 'Xd' is not handled on server")`,
+		`eddwise.NewError(eddwise.ErrCodeUnknownEvent, "event 'Coords' is not handled on server")`,
+	} {
+		if !strings.Contains(code, part) {
+			t.Fatalf("missing %s in the generated server\n", part)
+		}
+	}
+	// the behaviours of the mock reply as Route does, an empty reply included
+	buf.Reset()
+	if err := design.GenerateServerTest(buf); err != nil {
+		t.Fatalf("unable to generate the server behaviours: %s\n", err)
+	}
+	if part := `eddwise.NewError(eddwise.ErrCodeInternal, "empty reply for request 'Xd'")`; !strings.Contains(buf.String(), part) {
+		t.Fatalf("missing %s in the generated server behaviours\n", part)
+	}
+}
+
+func TestGenerateServerGuards(t *testing.T) {
+	design, err := ParseAndValidateYamls("guarded", filepath.Join("..", "..", "testdata", "guarded", "design.edd.yml"))
+	if err != nil {
+		t.Fatalf("unable to parse the design: %s\n", err)
+	}
+	var buf = bytes.NewBuffer(nil)
+	if err := design.GenerateServer(buf); err != nil {
+		t.Fatalf("unable to generate the server: %s\n", err)
+	}
+	var code = buf.String()
+	// the limits and the policies of the channel, of its events and of the replies, see TestGenerateBuilds
+	for _, part := range []string{
+		`eddwise.LimitRate(ctx, ch.Alias(), "", eddwise.RateLimit{Rate: 20, Burst: 40})`,
+		`eddwise.Authorize(ctx, ch.Alias(), "", eddwise.Policy{Roles: []string{"player", "admin"}})`,
+		`eddwise.LimitRate(ctx, ch.Alias(), evt.Name, eddwise.RateLimit{Rate: 1, Burst: 5})`,
+		`eddwise.Authorize(ctx, ch.Alias(), evt.Name, eddwise.Policy{Scopes: []string{"chat:write"}})`,
+		`eddwise.Authorize(ctx, ch.Alias(), "history", eddwise.Policy{Scopes: []string{"history:read"}})`,
+		`eddwise.AuthorizedClients(ch.server, clients, eddwise.Policy{Roles: []string{"player", "admin"}}, eddwise.Policy{Roles: []string{"admin"}})`,
+		`eddwise.AuthorizedClients(ch.server, []eddwise.Client{client}, eddwise.Policy{Roles: []string{"player", "admin"}}, eddwise.Policy{Roles: []string{"admin"}})`,
+	} {
+		if !strings.Contains(code, part) {
+			t.Fatalf("missing %s in the generated server\n", part)
+		}
+	}
+}
+
+func generateFile(path string, generate func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return generate(f)
+}
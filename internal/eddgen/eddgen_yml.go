@@ -6,6 +6,8 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -36,6 +38,9 @@ type YamlChannel struct {
 
 func (yc *YamlChannel) UnmarshalYAML(node *yaml.Node) error {
 	yc.Tags = ProcessTags(node)
+	if yc.Tags.err != nil {
+		return fmt.Errorf("invalid tags at line %d: %w", node.Line, yc.Tags.err)
+	}
 	var m = struct {
 		Dual   YamlChannelEvents `yaml:"dual"`
 		Server YamlChannelEvents `yaml:"server"`
@@ -56,11 +61,23 @@ type YamlChannelEvents []YamlChannelEvent
 type YamlChannelEvent struct {
 	Tags  Tags
 	Event string
+	Reply string
 }
 
 func (yce *YamlChannelEvent) UnmarshalYAML(node *yaml.Node) error {
 	yce.Tags = ProcessTags(node)
+	if yce.Tags.err != nil {
+		return fmt.Errorf("invalid tags at line %d: %w", node.Line, yce.Tags.err)
+	}
 	yce.Event = node.Value
+	// request/response events are declared as "event -> reply"
+	if i := strings.Index(yce.Event, "->"); i >= 0 {
+		yce.Reply = strings.TrimSpace(yce.Event[i+2:])
+		yce.Event = strings.TrimSpace(yce.Event[:i])
+		if len(yce.Event) == 0 || len(yce.Reply) == 0 {
+			return fmt.Errorf("invalid request event '%s' at line %d, expecting 'event -> reply'", node.Value, node.Line)
+		}
+	}
 	return nil
 }
 
@@ -144,6 +161,11 @@ func (design *Design) Merge(design2 *Design) error {
 type Tags struct {
 	Alias     string
 	Direction Direction
+	RateLimit *RateLimit
+	Policy    *Policy
+	// Pool decodes the client events of the struct into pooled values, released once handled
+	Pool bool
+	err  error
 }
 
 func ProcessTags(node *yaml.Node) (t Tags) {
@@ -168,11 +190,70 @@ func ProcessTags(node *yaml.Node) (t Tags) {
 			t.Direction = ServerToClient
 		case "alias":
 			t.Alias = value
+		case "pool":
+			t.Pool = true
+		case "rate":
+			if t.RateLimit == nil {
+				t.RateLimit = &RateLimit{}
+			}
+			if t.RateLimit.Rate, t.err = ParseRate(value); t.err != nil {
+				return
+			}
+		case "roles", "scopes":
+			// several values are separated by +, as in !!roles=admin+moderator
+			if t.Policy == nil {
+				t.Policy = &Policy{}
+			}
+			var values = strings.Split(value, "+")
+			for _, v := range values {
+				if len(v) == 0 {
+					t.err = fmt.Errorf("invalid %s '%s'", key, value)
+					return
+				}
+			}
+			if key == "roles" {
+				t.Policy.Roles = values
+			} else {
+				t.Policy.Scopes = values
+			}
+		case "burst":
+			if t.RateLimit == nil {
+				t.RateLimit = &RateLimit{}
+			}
+			if t.RateLimit.Burst, t.err = strconv.Atoi(value); t.err != nil || t.RateLimit.Burst <= 0 {
+				t.err = fmt.Errorf("invalid burst '%s'", value)
+				return
+			}
 		}
 	}
+	if t.RateLimit != nil && t.RateLimit.Rate == 0 {
+		t.err = errors.New("burst requires a rate")
+	}
 	return
 }
 
+// ParseRate reads a rate as events per second, "10", or per unit of time, "10/s", "10/m" or "10/h"
+func ParseRate(value string) (float64, error) {
+	var per = 1.0
+	if i := strings.Index(value, "/"); i != -1 {
+		switch value[i+1:] {
+		case "s":
+		case "m":
+			per = 60
+		case "h":
+			per = 3600
+		default:
+			return 0, fmt.Errorf("invalid rate unit in '%s'", value)
+		}
+		value = value[:i]
+	}
+	var rate, err = strconv.ParseFloat(value, 64)
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("invalid rate '%s'", value)
+	}
+	return rate / per, nil
+}
+
 type MapItem struct {
 	Key   string
 	Value *yaml.Node
@@ -381,6 +462,11 @@ func (design *Design) ParseYaml(filePath string) error {
 				ServerToClient: {},
 				ClientToServer: {},
 			},
+			Replies:    map[string]*Struct{},
+			RateLimit:  chYaml.Value.Tags.RateLimit,
+			RateLimits: map[string]*RateLimit{},
+			Policy:     chYaml.Value.Tags.Policy,
+			Policies:   map[string]*Policy{},
 		}
 		var uniqueSet = map[string]bool{}
 		var dualWithDirection bool
@@ -393,6 +479,15 @@ func (design *Design) ParseYaml(filePath string) error {
 			}
 			uniqueSet[node.Event] = true
 			ch.Enabled = append(ch.Enabled, structMap[node.Event])
+			if len(node.Reply) > 0 {
+				ch.Replies[node.Event] = structMap[node.Reply]
+			}
+			if node.Tags.RateLimit != nil {
+				ch.RateLimits[node.Event] = node.Tags.RateLimit
+			}
+			if node.Tags.Policy != nil {
+				ch.Policies[node.Event] = node.Tags.Policy
+			}
 			if node.Tags.Direction != Any {
 				dualWithDirection = true
 				ch.Directions[node.Tags.Direction][node.Event] = true
@@ -408,9 +503,18 @@ func (design *Design) ParseYaml(filePath string) error {
 			if uniqueSet[node.Event] {
 				return fmt.Errorf("'%s' server event is already registered to the channel '%s'", node.Event, ch.Name)
 			}
+			if len(node.Reply) > 0 {
+				return fmt.Errorf("server event '%s' in channel '%s' cannot expect a reply", node.Event, ch.Name)
+			}
+			if node.Tags.RateLimit != nil {
+				return fmt.Errorf("server event '%s' in channel '%s' cannot be rate limited", node.Event, ch.Name)
+			}
 			uniqueSet[node.Event] = true
 			ch.Enabled = append(ch.Enabled, structMap[node.Event])
 			ch.Directions[ServerToClient][node.Event] = true
+			if node.Tags.Policy != nil {
+				ch.Policies[node.Event] = node.Tags.Policy
+			}
 		}
 
 		for _, node := range chYaml.Value.Client {
@@ -423,6 +527,29 @@ func (design *Design) ParseYaml(filePath string) error {
 			uniqueSet[node.Event] = true
 			ch.Enabled = append(ch.Enabled, structMap[node.Event])
 			ch.Directions[ClientToServer][node.Event] = true
+			if len(node.Reply) > 0 {
+				ch.Replies[node.Event] = structMap[node.Reply]
+			}
+			if node.Tags.RateLimit != nil {
+				ch.RateLimits[node.Event] = node.Tags.RateLimit
+			}
+			if node.Tags.Policy != nil {
+				ch.Policies[node.Event] = node.Tags.Policy
+			}
+		}
+
+		//replies not explicitly declared in the channel are enabled as server events
+		for _, event := range sortedKeys(ch.Replies) {
+			var reply = ch.Replies[event]
+			if reply == nil {
+				return fmt.Errorf("unknown reply for event '%s' in channel '%s'", event, ch.Name)
+			}
+			if uniqueSet[reply.Name] {
+				continue
+			}
+			uniqueSet[reply.Name] = true
+			ch.Enabled = append(ch.Enabled, reply)
+			ch.Directions[ServerToClient][reply.Name] = true
 		}
 
 		design.Channels = append(design.Channels, ch)
@@ -430,3 +557,12 @@ func (design *Design) ParseYaml(filePath string) error {
 
 	return nil
 }
+
+func sortedKeys[T any](m map[string]T) []string {
+	var keys = make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
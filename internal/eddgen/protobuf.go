@@ -0,0 +1,639 @@
+package eddgen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProtoLock keeps the protobuf field numbers stable across regenerations, it must be kept with the design.
+// Messages are keyed by struct name, nested structs by "struct.field"
+type ProtoLock struct {
+	Messages map[string]*ProtoLockMessage `yaml:"messages"`
+}
+
+type ProtoLockMessage struct {
+	Fields map[string]int `yaml:"fields"`
+	// Reserved numbers belonged to removed fields, they are never assigned again
+	Reserved []int `yaml:"reserved,omitempty"`
+}
+
+// ReadProtoLock reads a lock file, a missing file returns an empty lock
+func ReadProtoLock(path string) (*ProtoLock, error) {
+	var lock = &ProtoLock{Messages: map[string]*ProtoLockMessage{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("unable to parse lock file %s: %w", path, err)
+	}
+	if lock.Messages == nil {
+		lock.Messages = map[string]*ProtoLockMessage{}
+	}
+	return lock, nil
+}
+
+func (lock *ProtoLock) Write(path string) error {
+	var buf = bytes.NewBufferString("# Code generated by eddwise, keep it with the design: it preserves the protobuf field numbers.\n")
+	var enc = yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(lock); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// ApplyProtoLock numbers the fields of every struct, new fields get numbers never used before and the
+// numbers of removed fields are reserved
+func (design *Design) ApplyProtoLock(lock *ProtoLock) {
+	if lock.Messages == nil {
+		lock.Messages = map[string]*ProtoLockMessage{}
+	}
+	for _, st := range design.Structs {
+		applyProtoLock(lock, st.Name, st)
+	}
+	design.protoLocked = true
+}
+
+func applyProtoLock(lock *ProtoLock, path string, st *Struct) {
+	var lm = lock.Messages[path]
+	if lm == nil {
+		lm = &ProtoLockMessage{}
+		lock.Messages[path] = lm
+	}
+	if lm.Fields == nil {
+		lm.Fields = map[string]int{}
+	}
+	var max int
+	for _, n := range lm.Fields {
+		if n > max {
+			max = n
+		}
+	}
+	for _, n := range lm.Reserved {
+		if n > max {
+			max = n
+		}
+	}
+	var present = map[string]bool{}
+	for _, f := range st.Fields {
+		present[f.Name] = true
+		n, ok := lm.Fields[f.Name]
+		if !ok {
+			max++
+			n = max
+			lm.Fields[f.Name] = n
+		}
+		f.ProtoNumber = n
+		if t, ok := f.Type.Type.(*NestedType); ok {
+			applyProtoLock(lock, path+"."+f.Name, t.Struct)
+		}
+	}
+	for _, name := range sortedKeys(lm.Fields) {
+		if !present[name] {
+			lm.Reserved = append(lm.Reserved, lm.Fields[name])
+			delete(lm.Fields, name)
+		}
+	}
+	sort.Ints(lm.Reserved)
+	st.protoReserved = lm.Reserved
+}
+
+func (design *Design) ensureProtoNumbers() {
+	if !design.protoLocked {
+		design.ApplyProtoLock(&ProtoLock{})
+	}
+}
+
+type protoScalar struct {
+	proto    string // type in the .proto schema
+	wire     string // protowire type
+	jsWire   int
+	method   string // protowire Append and Reader method
+	conv     string // conversion of the value before appending
+	jsMethod string // EddProtoWriter and EddProtoReader method
+	zero     string
+	jsZero   string
+	packable bool
+}
+
+func protoScalarOf(t Type) *protoScalar {
+	var pt, ok = t.(PrimitiveType)
+	if !ok {
+		return nil
+	}
+	switch pt {
+	case "bool":
+		return &protoScalar{"bool", "VarintType", 0, "Bool", "", "bool", "false", "false", true}
+	case "int", "int64":
+		return &protoScalar{"sint64", "VarintType", 0, "ZigZag", "int64", "zigzag", "0", "0", true}
+	case "int8", "int16", "int32", "rune":
+		return &protoScalar{"sint32", "VarintType", 0, "ZigZag", "int64", "zigzag", "0", "0", true}
+	case "uint", "uint64":
+		return &protoScalar{"uint64", "VarintType", 0, "Varint", "uint64", "varint", "0", "0", true}
+	case "uint8", "byte", "uint16", "uint32":
+		return &protoScalar{"uint32", "VarintType", 0, "Varint", "uint64", "varint", "0", "0", true}
+	case "float32":
+		return &protoScalar{"float", "Fixed32Type", 5, "Float32", "", "float", "0", "0", true}
+	case "float64":
+		return &protoScalar{"double", "Fixed64Type", 1, "Float64", "", "double", "0", "0", true}
+	case "string":
+		return &protoScalar{"string", "BytesType", 2, "String", "", "string", `""`, `""`, false}
+	}
+	return nil
+}
+
+func (ps *protoScalar) goAppend(buf, value string) string {
+	if len(ps.conv) > 0 {
+		value = ps.conv + "(" + value + ")"
+	}
+	return fmt.Sprintf("protowire.Append%s(%s, %s)", ps.method, buf, value)
+}
+
+type protoKind int
+
+const (
+	protoKindScalar protoKind = iota
+	protoKindMessage
+	protoKindMap
+	// protoKindJSON holds the values protobuf cannot express, such as any or nested arrays, as json bytes
+	protoKindJSON
+)
+
+// protoMessageOf returns the struct of a message type and the name of its encoding functions
+func protoMessageOf(t Type, path string) (*Struct, string) {
+	switch t := t.(type) {
+	case *RefType:
+		return t.refStruct, GoName(t.ref)
+	case *NestedType:
+		return t.Struct, path
+	}
+	return nil, ""
+}
+
+func protoKindOf(twa *TypeWithAttributes) protoKind {
+	if twa.Array > 1 {
+		return protoKindJSON
+	}
+	switch t := twa.Type.(type) {
+	case PrimitiveType:
+		if protoScalarOf(t) != nil {
+			return protoKindScalar
+		}
+	case *RefType, *NestedType:
+		return protoKindMessage
+	case *MapType:
+		var key = protoScalarOf(t.keyType)
+		if twa.Array > 0 || key == nil || key.wire == "Fixed32Type" || key.wire == "Fixed64Type" || t.valueType.Array > 0 {
+			return protoKindJSON
+		}
+		switch t.valueType.Type.(type) {
+		case *RefType, *NestedType:
+			return protoKindMap
+		}
+		if protoScalarOf(t.valueType.Type) != nil {
+			return protoKindMap
+		}
+	}
+	return protoKindJSON
+}
+
+// protoPath names the message of a nested struct after its parent and field
+func protoPath(parent string, f *Field) string {
+	return parent + f.GoName()
+}
+
+func protoTypeName(t Type, path string) string {
+	if ps := protoScalarOf(t); ps != nil {
+		return ps.proto
+	}
+	if t, ok := t.(*RefType); ok {
+		return GoName(t.ref)
+	}
+	return path
+}
+
+// GenerateProto writes the .proto schema of the envelope and of every struct
+func (design *Design) GenerateProto(w io.Writer) error {
+	design.ensureProtoNumbers()
+	var buf = bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "// Code generated by eddwise, DO NOT EDIT.\n\nsyntax = \"proto3\";\n\npackage %s;\n\n", design.Name)
+	buf.WriteString(`// Envelope wraps every event, json is set when body is json encoded, as for the system events
+message Envelope {
+  string channel = 1;
+  string name = 2;
+  uint64 id = 3;
+  bytes body = 4;
+  bool json = 5;
+}
+`)
+	for _, st := range design.Structs {
+		buf.WriteString("\n")
+		writeProtoMessage(buf, st, st.GoName(), st.GoName(), 0)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeProtoMessage(buf *bytes.Buffer, st *Struct, name, path string, depth int) {
+	var indent = strings.Repeat("  ", depth)
+	if st.HasDoc() {
+		fmt.Fprintf(buf, "%s// %s\n", indent, strings.TrimSpace(st.Doc))
+	}
+	fmt.Fprintf(buf, "%smessage %s {\n", indent, name)
+	if len(st.protoReserved) > 0 {
+		var nums = make([]string, len(st.protoReserved))
+		for i, n := range st.protoReserved {
+			nums[i] = fmt.Sprint(n)
+		}
+		fmt.Fprintf(buf, "%s  reserved %s;\n", indent, strings.Join(nums, ", "))
+	}
+	for _, f := range st.Fields {
+		if t, ok := f.Type.Type.(*NestedType); ok {
+			writeProtoMessage(buf, t.Struct, f.GoName(), protoPath(path, f), depth+1)
+		}
+	}
+	for _, f := range st.Fields {
+		var comment string
+		if f.HasDoc() {
+			comment = " // " + strings.TrimSpace(f.Doc)
+		}
+		var decl string
+		switch protoKindOf(f.Type) {
+		case protoKindScalar, protoKindMessage:
+			decl = protoTypeName(f.Type.Type, f.GoName())
+			if f.Type.Array > 0 {
+				decl = "repeated " + decl
+			} else if f.Tags.Direction != Any && protoScalarOf(f.Type.Type) != nil {
+				decl = "optional " + decl
+			}
+		case protoKindMap:
+			var mt = f.Type.Type.(*MapType)
+			decl = fmt.Sprintf("map<%s, %s>", protoTypeName(mt.keyType, ""), protoTypeName(mt.valueType.Type, f.GoName()))
+			if _, ok := mt.valueType.Type.(*NestedType); ok {
+				comment += " // nested map values are not supported, use a ref type"
+			}
+		default:
+			decl = "bytes"
+			comment += " // json encoded " + f.Type.GoType()
+		}
+		fmt.Fprintf(buf, "%s  %s %s = %d;%s\n", indent, decl, f.Name, f.ProtoNumber, comment)
+	}
+	fmt.Fprintf(buf, "%s}\n", indent)
+}
+
+// GenerateServerProto writes the protobuf encoding of the structs, used by eddwise.ProtobufCodec
+func (design *Design) GenerateServerProto(w io.Writer) error {
+	design.ensureProtoNumbers()
+	var buf = bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "// Code generated by eddwise, DO NOT EDIT.\n\npackage %s\n\nimport (\n", design.Name)
+	for _, st := range design.Structs {
+		if usesProtoJSON(st) {
+			buf.WriteString("\t\"encoding/json\"\n\n")
+			break
+		}
+	}
+	buf.WriteString("\t\"github.com/exelr/eddwise\"\n\t\"github.com/exelr/eddwise/protowire\"\n)\n")
+	for _, st := range design.Structs {
+		fmt.Fprintf(buf, "\nvar _ eddwise.ProtoMessage = (*%s)(nil)\n", st.GoName())
+	}
+	for _, st := range design.Structs {
+		fmt.Fprintf(buf, `
+func (evt *%[1]s) MarshalProto() ([]byte, error) {
+	return appendProto%[1]s(nil, evt)
+}
+
+func (evt *%[1]s) UnmarshalProto(data []byte) error {
+	return readProto%[1]s(data, evt)
+}
+`, st.GoName())
+		writeGoProtoFuncs(buf, st, st.GoName(), st.GoName())
+	}
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("unable to format protobuf code: %w", err)
+	}
+	_, err = w.Write(formatted)
+	return err
+}
+
+func usesProtoJSON(st *Struct) bool {
+	for _, f := range st.Fields {
+		if protoKindOf(f.Type) == protoKindJSON {
+			return true
+		}
+		if t, ok := f.Type.Type.(*NestedType); ok && usesProtoJSON(t.Struct) {
+			return true
+		}
+	}
+	return false
+}
+
+// goRead assigns to target the value read by r, converted to goType
+func (ps *protoScalar) goRead(r, target, goType string) string {
+	var natural = map[string]string{"Varint": "uint64", "ZigZag": "int64"}[ps.method]
+	if len(natural) == 0 || natural == goType {
+		return fmt.Sprintf("%s, err = %s.%s()\n", target, r, ps.method)
+	}
+	return fmt.Sprintf("var x %s\nx, err = %s.%s()\n%s = %s(x)\n", natural, r, ps.method, target, goType)
+}
+
+func writeGoProtoFuncs(buf *bytes.Buffer, st *Struct, goType, path string) {
+	fmt.Fprintf(buf, "\nfunc appendProto%s(b []byte, v *%s) ([]byte, error) {\n", path, goType)
+	for _, f := range st.Fields {
+		writeGoProtoAppend(buf, f, path)
+	}
+	buf.WriteString("\treturn b, nil\n}\n")
+
+	fmt.Fprintf(buf, "\nfunc readProto%s(data []byte, v *%s) error {\n", path, goType)
+	buf.WriteString("\tvar r = protowire.NewReader(data)\n\tfor {\n\t\tnum, t, ok, err := r.Next()\n\t\tif err != nil || !ok {\n\t\t\treturn err\n\t\t}\n\t\tswitch num {\n")
+	for _, f := range st.Fields {
+		fmt.Fprintf(buf, "\t\tcase %d:\n", f.ProtoNumber)
+		writeGoProtoRead(buf, f, path)
+	}
+	buf.WriteString("\t\tdefault:\n\t\t\terr = r.Skip(t)\n\t\t}\n\t\tif err != nil {\n\t\t\treturn err\n\t\t}\n\t}\n}\n")
+
+	for _, f := range st.Fields {
+		if t, ok := f.Type.Type.(*NestedType); ok {
+			writeGoProtoFuncs(buf, t.Struct, t.GoType(), protoPath(path, f))
+		}
+	}
+}
+
+func writeGoProtoAppend(buf *bytes.Buffer, f *Field, path string) {
+	var value = "v." + f.GoName()
+	var optional = f.Tags.Direction != Any
+	var kind = protoKindOf(f.Type)
+	// messages and json values declare variables, they need their own scope
+	var scoped = optional || kind == protoKindMessage || kind == protoKindJSON
+	if optional {
+		fmt.Fprintf(buf, "if %s != nil {\n", value)
+		value = "*" + value
+	} else if scoped {
+		buf.WriteString("{\n")
+	}
+	var tag = func(wire string) string {
+		return fmt.Sprintf("b = protowire.AppendTag(b, %d, protowire.%s)\n", f.ProtoNumber, wire)
+	}
+	switch kind {
+	case protoKindScalar:
+		var ps = protoScalarOf(f.Type.Type)
+		switch {
+		case f.Type.Array > 0 && ps.packable:
+			fmt.Fprintf(buf, "if len(%s) > 0 {\nvar p []byte\nfor _, e := range %s {\np = %s\n}\n%sb = protowire.AppendBytes(b, p)\n}\n", value, value, ps.goAppend("p", "e"), tag("BytesType"))
+		case f.Type.Array > 0:
+			fmt.Fprintf(buf, "for _, e := range %s {\n%sb = %s\n}\n", value, tag(ps.wire), ps.goAppend("b", "e"))
+		case optional:
+			fmt.Fprintf(buf, "%sb = %s\n", tag(ps.wire), ps.goAppend("b", value))
+		case ps.method == "Bool":
+			fmt.Fprintf(buf, "if %s {\n%sb = %s\n}\n", value, tag(ps.wire), ps.goAppend("b", value))
+		default:
+			fmt.Fprintf(buf, "if %s != %s {\n%sb = %s\n}\n", value, ps.zero, tag(ps.wire), ps.goAppend("b", value))
+		}
+	case protoKindMessage:
+		var _, fn = protoMessageOf(f.Type.Type, protoPath(path, f))
+		if f.Type.Array > 0 {
+			fmt.Fprintf(buf, "for i := range %s {\nsub, err := appendProto%s(nil, &(%s)[i])\nif err != nil {\nreturn nil, err\n}\n%sb = protowire.AppendBytes(b, sub)\n}\n", value, fn, value, tag("BytesType"))
+		} else {
+			fmt.Fprintf(buf, "sub, err := appendProto%s(nil, &%s)\nif err != nil {\nreturn nil, err\n}\n%sb = protowire.AppendBytes(b, sub)\n", fn, value, tag("BytesType"))
+		}
+	case protoKindMap:
+		var mt = f.Type.Type.(*MapType)
+		var key = protoScalarOf(mt.keyType)
+		fmt.Fprintf(buf, "for k, e := range %s {\nvar entry = protowire.AppendTag(nil, 1, protowire.%s)\nentry = %s\n", value, key.wire, key.goAppend("entry", "k"))
+		if vs := protoScalarOf(mt.valueType.Type); vs != nil {
+			fmt.Fprintf(buf, "entry = protowire.AppendTag(entry, 2, protowire.%s)\nentry = %s\n", vs.wire, vs.goAppend("entry", "e"))
+		} else {
+			var _, fn = protoMessageOf(mt.valueType.Type, protoPath(path, f))
+			fmt.Fprintf(buf, "sub, err := appendProto%s(nil, &e)\nif err != nil {\nreturn nil, err\n}\nentry = protowire.AppendTag(entry, 2, protowire.BytesType)\nentry = protowire.AppendBytes(entry, sub)\n", fn)
+		}
+		fmt.Fprintf(buf, "%sb = protowire.AppendBytes(b, entry)\n}\n", tag("BytesType"))
+	default:
+		fmt.Fprintf(buf, "data, err := json.Marshal(%s)\nif err != nil {\nreturn nil, err\n}\n%sb = protowire.AppendBytes(b, data)\n", value, tag("BytesType"))
+	}
+	if scoped {
+		buf.WriteString("}\n")
+	}
+}
+
+func writeGoProtoRead(buf *bytes.Buffer, f *Field, path string) {
+	var target = "v." + f.GoName()
+	if f.Tags.Direction != Any {
+		fmt.Fprintf(buf, "if %s == nil {\n%s = new(%s)\n}\n", target, target, f.Type.GoType())
+		target = "*" + target
+	}
+	switch protoKindOf(f.Type) {
+	case protoKindScalar:
+		var ps = protoScalarOf(f.Type.Type)
+		var elem = f.Type.Type.GoType()
+		switch {
+		case f.Type.Array > 0 && ps.packable:
+			var e = "x"
+			if ps.conv != "" && ps.conv != elem {
+				e = elem + "(x)"
+			}
+			fmt.Fprintf(buf, "err = r.Packed(t, func(r *protowire.Reader) error {\nx, err := r.%s()\n%s = append(%s, %s)\nreturn err\n})\n", ps.method, target, target, e)
+		case f.Type.Array > 0:
+			fmt.Fprintf(buf, "var x %s\nif x, err = r.%s(); err == nil {\n%s = append(%s, x)\n}\n", elem, ps.method, target, target)
+		default:
+			buf.WriteString(ps.goRead("r", target, elem))
+		}
+	case protoKindMessage:
+		var _, fn = protoMessageOf(f.Type.Type, protoPath(path, f))
+		if f.Type.Array > 0 {
+			fmt.Fprintf(buf, "var sub []byte\nif sub, err = r.Bytes(); err == nil {\nvar e %s\nif err = readProto%s(sub, &e); err == nil {\n%s = append(%s, e)\n}\n}\n", f.Type.Type.GoType(), fn, target, target)
+		} else {
+			fmt.Fprintf(buf, "var sub []byte\nif sub, err = r.Bytes(); err == nil {\nerr = readProto%s(sub, &%s)\n}\n", fn, target)
+		}
+	case protoKindMap:
+		var mt = f.Type.Type.(*MapType)
+		var key = protoScalarOf(mt.keyType)
+		fmt.Fprintf(buf, "var sub []byte\nif sub, err = r.Bytes(); err != nil {\nbreak\n}\nif %s == nil {\n%s = %s{}\n}\n", target, target, mt.GoType())
+		fmt.Fprintf(buf, "var k %s\nvar e %s\nvar er = protowire.NewReader(sub)\nfor {\nnum, t, ok, err2 := er.Next()\nif err = err2; err != nil || !ok {\nbreak\n}\nswitch num {\n", mt.keyType.GoType(), mt.valueType.GoType())
+		fmt.Fprintf(buf, "case 1:\n%s", key.goRead("er", "k", mt.keyType.GoType()))
+		if vs := protoScalarOf(mt.valueType.Type); vs != nil {
+			fmt.Fprintf(buf, "case 2:\n%s", vs.goRead("er", "e", mt.valueType.GoType()))
+		} else {
+			var _, fn = protoMessageOf(mt.valueType.Type, protoPath(path, f))
+			fmt.Fprintf(buf, "case 2:\nvar value []byte\nif value, err = er.Bytes(); err == nil {\nerr = readProto%s(value, &e)\n}\n", fn)
+		}
+		if strings.HasPrefix(target, "*") {
+			target = "(" + target + ")"
+		}
+		fmt.Fprintf(buf, "default:\nerr = er.Skip(t)\n}\nif err != nil {\nbreak\n}\n}\n%s[k] = e\n", target)
+	default:
+		fmt.Fprintf(buf, "var sub []byte\nif sub, err = r.Bytes(); err == nil {\nerr = json.Unmarshal(sub, &%s)\n}\n", target)
+	}
+}
+
+// writeJsProto writes the protobuf encoding of the structs and registerProtobuf, registering the events of each
+// channel on a codec
+func (design *Design) writeJsProto(w io.Writer) error {
+	design.ensureProtoNumbers()
+	var buf = bytes.NewBuffer(nil)
+	for _, st := range design.Structs {
+		writeJsProtoFuncs(buf, st, st.GoName())
+	}
+	buf.WriteString("/**\n * Registers the protobuf encoding of the events of the channels on codec\n * @param {EddCodecProtobuf} codec\n * @returns {EddCodecProtobuf}\n */\nfunction registerProtobuf(codec) {\n")
+	for _, ch := range design.Channels {
+		for _, st := range ch.Enabled {
+			fmt.Fprintf(buf, "    codec.register(\"%s\", \"%s\", {encode: _protoEncode%s, decode: _protoDecode%s})\n", ch.ProtocolAlias(), st.ProtocolAlias(), st.GoName(), st.GoName())
+		}
+	}
+	buf.WriteString("    return codec\n}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeJsProtoFuncs(buf *bytes.Buffer, st *Struct, path string) {
+	fmt.Fprintf(buf, "function _protoEncode%s(obj) {\n    const w = new EddProtoWriter()\n", path)
+	for _, f := range st.Fields {
+		writeJsProtoEncode(buf, f, path)
+	}
+	buf.WriteString("    return w.result()\n}\n\n")
+
+	fmt.Fprintf(buf, "function _protoDecode%s(data) {\n    const r = new EddProtoReader(data)\n    const obj = {}\n", path)
+	for _, f := range st.Fields {
+		if f.Tags.Direction == Any {
+			fmt.Fprintf(buf, "    obj[%q] = %s\n", f.ProtocolAlias(), jsProtoDefault(f, path))
+		}
+	}
+	buf.WriteString("    for (let f = r.next(); f; f = r.next()) {\n        switch (f.num) {\n")
+	for _, f := range st.Fields {
+		fmt.Fprintf(buf, "            case %d:\n", f.ProtoNumber)
+		writeJsProtoDecode(buf, f, path)
+		buf.WriteString("                break\n")
+	}
+	buf.WriteString("            default:\n                r.skip(f.type)\n        }\n    }\n    return obj\n}\n\n")
+
+	for _, f := range st.Fields {
+		if t, ok := f.Type.Type.(*NestedType); ok {
+			writeJsProtoFuncs(buf, t.Struct, protoPath(path, f))
+		}
+	}
+}
+
+func jsProtoDefault(f *Field, path string) string {
+	switch protoKindOf(f.Type) {
+	case protoKindScalar:
+		if f.Type.Array > 0 {
+			return "[]"
+		}
+		return protoScalarOf(f.Type.Type).jsZero
+	case protoKindMessage:
+		if f.Type.Array > 0 {
+			return "[]"
+		}
+		var _, fn = protoMessageOf(f.Type.Type, protoPath(path, f))
+		return fmt.Sprintf("_protoDecode%s(new Uint8Array(0))", fn)
+	case protoKindMap:
+		return "{}"
+	default:
+		return "null"
+	}
+}
+
+// jsMapKey converts the string key of a javascript object to the protobuf key type
+func jsMapKey(ps *protoScalar) string {
+	switch ps.jsMethod {
+	case "string":
+		return "k"
+	case "bool":
+		return `k === "true"`
+	default:
+		return "Number(k)"
+	}
+}
+
+func writeJsProtoEncode(buf *bytes.Buffer, f *Field, path string) {
+	var optional = f.Tags.Direction != Any
+	fmt.Fprintf(buf, "    {\n        const v = obj[%q]\n        if(v !== undefined && v !== null) {\n", f.ProtocolAlias())
+	var in = "            "
+	switch protoKindOf(f.Type) {
+	case protoKindScalar:
+		var ps = protoScalarOf(f.Type.Type)
+		switch {
+		case f.Type.Array > 0 && ps.packable:
+			fmt.Fprintf(buf, "%sif(v.length) {\n%s    const p = new EddProtoWriter()\n%s    v.forEach((e) => p.%s(e))\n%s    w.tag(%d, 2)\n%s    w.bytes(p.result())\n%s}\n", in, in, in, ps.jsMethod, in, f.ProtoNumber, in, in)
+		case f.Type.Array > 0:
+			fmt.Fprintf(buf, "%sv.forEach((e) => {\n%s    w.tag(%d, %d)\n%s    w.%s(e)\n%s})\n", in, in, f.ProtoNumber, ps.jsWire, in, ps.jsMethod, in)
+		case optional:
+			fmt.Fprintf(buf, "%sw.tag(%d, %d)\n%sw.%s(v)\n", in, f.ProtoNumber, ps.jsWire, in, ps.jsMethod)
+		default:
+			fmt.Fprintf(buf, "%sif(v !== %s) {\n%s    w.tag(%d, %d)\n%s    w.%s(v)\n%s}\n", in, ps.jsZero, in, f.ProtoNumber, ps.jsWire, in, ps.jsMethod, in)
+		}
+	case protoKindMessage:
+		var _, fn = protoMessageOf(f.Type.Type, protoPath(path, f))
+		if f.Type.Array > 0 {
+			fmt.Fprintf(buf, "%sv.forEach((e) => {\n%s    w.tag(%d, 2)\n%s    w.bytes(_protoEncode%s(e))\n%s})\n", in, in, f.ProtoNumber, in, fn, in)
+		} else {
+			fmt.Fprintf(buf, "%sw.tag(%d, 2)\n%sw.bytes(_protoEncode%s(v))\n", in, f.ProtoNumber, in, fn)
+		}
+	case protoKindMap:
+		var mt = f.Type.Type.(*MapType)
+		var key = protoScalarOf(mt.keyType)
+		fmt.Fprintf(buf, "%sObject.keys(v).forEach((k) => {\n%s    const e = new EddProtoWriter()\n%s    e.tag(1, %d)\n%s    e.%s(%s)\n", in, in, in, key.jsWire, in, key.jsMethod, jsMapKey(key))
+		if vs := protoScalarOf(mt.valueType.Type); vs != nil {
+			fmt.Fprintf(buf, "%s    e.tag(2, %d)\n%s    e.%s(v[k])\n", in, vs.jsWire, in, vs.jsMethod)
+		} else {
+			var _, fn = protoMessageOf(mt.valueType.Type, protoPath(path, f))
+			fmt.Fprintf(buf, "%s    e.tag(2, 2)\n%s    e.bytes(_protoEncode%s(v[k]))\n", in, in, fn)
+		}
+		fmt.Fprintf(buf, "%s    w.tag(%d, 2)\n%s    w.bytes(e.result())\n%s})\n", in, f.ProtoNumber, in, in)
+	default:
+		fmt.Fprintf(buf, "%sw.tag(%d, 2)\n%sw.string(JSON.stringify(v))\n", in, f.ProtoNumber, in)
+	}
+	buf.WriteString("        }\n    }\n")
+}
+
+func writeJsProtoDecode(buf *bytes.Buffer, f *Field, path string) {
+	var in = "                "
+	var target = fmt.Sprintf("obj[%q]", f.ProtocolAlias())
+	switch protoKindOf(f.Type) {
+	case protoKindScalar:
+		var ps = protoScalarOf(f.Type.Type)
+		switch {
+		case f.Type.Array > 0 && ps.packable:
+			fmt.Fprintf(buf, "%s%s = %s || []\n%sr.packed(f.type, (r) => %s.push(r.%s()))\n", in, target, target, in, target, ps.jsMethod)
+		case f.Type.Array > 0:
+			fmt.Fprintf(buf, "%s(%s = %s || []).push(r.%s())\n", in, target, target, ps.jsMethod)
+		default:
+			fmt.Fprintf(buf, "%s%s = r.%s()\n", in, target, ps.jsMethod)
+		}
+	case protoKindMessage:
+		var _, fn = protoMessageOf(f.Type.Type, protoPath(path, f))
+		if f.Type.Array > 0 {
+			fmt.Fprintf(buf, "%s(%s = %s || []).push(_protoDecode%s(r.bytes()))\n", in, target, target, fn)
+		} else {
+			fmt.Fprintf(buf, "%s%s = _protoDecode%s(r.bytes())\n", in, target, fn)
+		}
+	case protoKindMap:
+		var mt = f.Type.Type.(*MapType)
+		var key = protoScalarOf(mt.keyType)
+		var value = "null"
+		var readValue string
+		if vs := protoScalarOf(mt.valueType.Type); vs != nil {
+			value = vs.jsZero
+			readValue = fmt.Sprintf("e.%s()", vs.jsMethod)
+		} else {
+			var _, fn = protoMessageOf(mt.valueType.Type, protoPath(path, f))
+			value = fmt.Sprintf("_protoDecode%s(new Uint8Array(0))", fn)
+			readValue = fmt.Sprintf("_protoDecode%s(e.bytes())", fn)
+		}
+		fmt.Fprintf(buf, "%s{\n%s    const e = new EddProtoReader(r.bytes())\n%s    let k = %s, val = %s\n", in, in, in, key.jsZero, value)
+		fmt.Fprintf(buf, "%s    for (let ef = e.next(); ef; ef = e.next()) {\n%s        if(ef.num === 1) {\n%s            k = e.%s()\n%s        } else if(ef.num === 2) {\n%s            val = %s\n%s        } else {\n%s            e.skip(ef.type)\n%s        }\n%s    }\n", in, in, in, key.jsMethod, in, in, readValue, in, in, in, in)
+		fmt.Fprintf(buf, "%s    (%s = %s || {})[k] = val\n%s}\n", in, target, target, in)
+	default:
+		fmt.Fprintf(buf, "%s%s = JSON.parse(r.string())\n", in, target)
+	}
+}
@@ -0,0 +1,112 @@
+package eddgen
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lockDesign returns a design made of the coords struct with the given int fields, along with a nested struct
+func lockDesign(fields ...string) *Design {
+	var st = &Struct{Name: "coords"}
+	for _, name := range fields {
+		st.Fields = append(st.Fields, &Field{Name: name, Type: &TypeWithAttributes{Type: PrimitiveType("int")}})
+	}
+	var nested = &Struct{Fields: []*Field{{Name: "n", Type: &TypeWithAttributes{Type: PrimitiveType("int")}}}}
+	st.Fields = append(st.Fields, &Field{Name: "nested", Type: &TypeWithAttributes{Type: &NestedType{Struct: nested}}})
+	return &Design{Structs: []*Struct{st}}
+}
+
+func protoNumbers(design *Design) map[string]int {
+	var ret = map[string]int{}
+	for _, f := range design.Structs[0].Fields {
+		ret[f.Name] = f.ProtoNumber
+	}
+	return ret
+}
+
+func TestApplyProtoLock(t *testing.T) {
+	var lock = &ProtoLock{}
+	var design = lockDesign("x", "y")
+	design.ApplyProtoLock(lock)
+	if numbers := protoNumbers(design); !reflect.DeepEqual(numbers, map[string]int{"x": 1, "y": 2, "nested": 3}) {
+		t.Fatalf("unexpected initial numbers %v\n", numbers)
+	}
+	if lm := lock.Messages["coords.nested"]; lm == nil || lm.Fields["n"] != 1 {
+		t.Fatalf("the nested struct was not numbered: %+v\n", lock.Messages)
+	}
+
+	// a new field gets the next number, the existing ones are kept
+	design = lockDesign("z", "x", "y")
+	design.ApplyProtoLock(lock)
+	if numbers := protoNumbers(design); !reflect.DeepEqual(numbers, map[string]int{"z": 4, "x": 1, "y": 2, "nested": 3}) {
+		t.Fatalf("unexpected numbers after adding a field %v\n", numbers)
+	}
+
+	// the number of a removed field is reserved
+	design = lockDesign("z", "y")
+	design.ApplyProtoLock(lock)
+	if numbers := protoNumbers(design); !reflect.DeepEqual(numbers, map[string]int{"z": 4, "y": 2, "nested": 3}) {
+		t.Fatalf("unexpected numbers after removing a field %v\n", numbers)
+	}
+	if reserved := lock.Messages["coords"].Reserved; !reflect.DeepEqual(reserved, []int{1}) || !reflect.DeepEqual(design.Structs[0].protoReserved, reserved) {
+		t.Fatalf("unexpected reserved numbers %v\n", reserved)
+	}
+
+	// the lock is read back as written by a previous generation
+	var path = filepath.Join(t.TempDir(), "design.lock.yml")
+	if err := lock.Write(path); err != nil {
+		t.Fatalf("unable to write the lock: %s\n", err)
+	}
+	lock, err := ReadProtoLock(path)
+	if err != nil {
+		t.Fatalf("unable to read the lock: %s\n", err)
+	}
+
+	// a field added again gets a new number, the reserved ones are never assigned
+	design = lockDesign("z", "y", "x", "w")
+	design.ApplyProtoLock(lock)
+	if numbers := protoNumbers(design); !reflect.DeepEqual(numbers, map[string]int{"z": 4, "y": 2, "x": 5, "w": 6, "nested": 3}) {
+		t.Fatalf("unexpected numbers after adding a removed field again %v\n", numbers)
+	}
+	if reserved := lock.Messages["coords"].Reserved; !reflect.DeepEqual(reserved, []int{1}) {
+		t.Fatalf("unexpected reserved numbers %v\n", reserved)
+	}
+
+	// the reserved numbers above the used ones are not assigned either
+	design = lockDesign("z", "y", "x")
+	design.ApplyProtoLock(lock)
+	design = lockDesign("z", "y", "x", "v")
+	design.ApplyProtoLock(lock)
+	if numbers := protoNumbers(design); numbers["v"] != 7 || !reflect.DeepEqual(lock.Messages["coords"].Reserved, []int{1, 6}) {
+		t.Fatalf("unexpected numbers %v and reserved numbers %v\n", numbers, lock.Messages["coords"].Reserved)
+	}
+}
+
+func TestGenerateClientProtobuf(t *testing.T) {
+	design, err := ParseAndValidateYamls("guarded", filepath.Join("..", "..", "testdata", "guarded", "design.edd.yml"))
+	if err != nil {
+		t.Fatalf("unable to parse the design: %s\n", err)
+	}
+	var buf = bytes.NewBuffer(nil)
+	if err := design.GenerateClient(buf); err != nil {
+		t.Fatalf("unable to generate the client: %s\n", err)
+	}
+	var code = buf.String()
+	// the events are registered on the codec given to registerProtobuf, never on a codec shared by the module
+	for _, part := range []string{
+		"function registerProtobuf(codec) {\n",
+		`    codec.register("chat", "message", {encode: _protoEncodeMessage, decode: _protoDecodeMessage})`,
+		`    codec.register("lobby", "history", {encode: _protoEncodeHistory, decode: _protoDecodeHistory})`,
+		"export {chatChannel,lobbyChannel,registerProtobuf}\n",
+	} {
+		if !strings.Contains(code, part) {
+			t.Fatalf("missing %s in the generated client\n", part)
+		}
+	}
+	if strings.Contains(code, "EddCodecProtobuf.register") {
+		t.Fatalf("the generated client registers its events on the shared codec\n")
+	}
+}
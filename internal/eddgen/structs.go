@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
 	"unicode"
@@ -28,6 +29,79 @@ type Channel struct {
 	Doc        string
 	Enabled    []*Struct
 	Directions map[Direction]map[string]bool
+	Replies    map[string]*Struct
+	// RateLimit applies to all the client events of the channel, RateLimits to single events
+	RateLimit  *RateLimit
+	RateLimits map[string]*RateLimit
+	// Policy restricts all the events of the channel, Policies single events
+	Policy   *Policy
+	Policies map[string]*Policy
+}
+
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// GoLiteral returns the eddwise.RateLimit value used by the generated code
+func (rl *RateLimit) GoLiteral() string {
+	return fmt.Sprintf("eddwise.RateLimit{Rate: %s, Burst: %d}", strconv.FormatFloat(rl.Rate, 'g', -1, 64), rl.Burst)
+}
+
+// EventRateLimit returns the limit declared for the event, or nil
+func (c *Channel) EventRateLimit(event string) *RateLimit {
+	return c.RateLimits[event]
+}
+
+// Policy is the authorization rule declared with the roles and scopes tags
+type Policy struct {
+	Roles  []string
+	Scopes []string
+}
+
+// GoLiteral returns the eddwise.Policy value used by the generated code
+func (p *Policy) GoLiteral() string {
+	var fields []string
+	if len(p.Roles) > 0 {
+		fields = append(fields, "Roles: "+goStringsLiteral(p.Roles))
+	}
+	if len(p.Scopes) > 0 {
+		fields = append(fields, "Scopes: "+goStringsLiteral(p.Scopes))
+	}
+	return "eddwise.Policy{" + strings.Join(fields, ", ") + "}"
+}
+
+func goStringsLiteral(values []string) string {
+	var quoted = make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	return "[]string{" + strings.Join(quoted, ", ") + "}"
+}
+
+// EventPolicy returns the policy declared for the event, or nil
+func (c *Channel) EventPolicy(event string) *Policy {
+	return c.Policies[event]
+}
+
+// ReplyPolicy returns the policy declared for the reply of the event, or nil: the client must satisfy it to send
+// the request
+func (c *Channel) ReplyPolicy(event string) *Policy {
+	if reply := c.Reply(event); reply != nil {
+		return c.Policies[reply.Name]
+	}
+	return nil
+}
+
+// BroadcastPolicies returns the policies restricting the receivers of the server event as Go arguments, empty if none
+func (c *Channel) BroadcastPolicies(event string) string {
+	var literals []string
+	for _, p := range []*Policy{c.Policy, c.Policies[event]} {
+		if p != nil {
+			literals = append(literals, p.GoLiteral())
+		}
+	}
+	return strings.Join(literals, ", ")
 }
 
 func (c *Channel) GoName() string {
@@ -42,6 +116,11 @@ func (c *Channel) EnabledMap() map[string]*Struct {
 	return ret
 }
 
+// Reply returns the struct sent back to the client as response of the given event, or nil if the event does not expect a reply
+func (c *Channel) Reply(event string) *Struct {
+	return c.Replies[event]
+}
+
 func (c *Channel) ProtocolAlias() string {
 	if len(c.Alias) > 0 {
 		return c.Alias
@@ -151,6 +230,8 @@ type Field struct {
 	Name string
 	Type *TypeWithAttributes
 	Doc  string
+	// ProtoNumber is the protobuf field number, assigned by Design.ApplyProtoLock
+	ProtoNumber int
 }
 
 func (f *Field) GoType() string {
@@ -204,6 +285,8 @@ type Struct struct {
 	Name   string
 	Fields []*Field
 	Doc    string
+
+	protoReserved []int
 }
 
 func (s *Struct) TopFieldsWithStrictDirection(direction Direction) []*Field {
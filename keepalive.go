@@ -0,0 +1,103 @@
+package eddwise
+
+import (
+	"time"
+)
+
+// SystemChannel is the channel reserved to the protocol messages that are not bound to a registered channel
+const SystemChannel = "edd"
+
+const (
+	DefaultPingInterval = 30 * time.Second
+	DefaultPongWait     = 60 * time.Second
+)
+
+// KeepAlive is sent periodically by the server on the SystemChannel, clients are expected to send it back
+type KeepAlive struct{}
+
+func (*KeepAlive) GetEventName() string {
+	return "edd:keepalive"
+}
+
+func (*KeepAlive) ProtocolAlias() string {
+	return "edd:keepalive"
+}
+
+// SetKeepAlive configures how often the server pings its clients and how long it waits for any frame before
+// considering the connection dead, a zero pingInterval disables the pings
+func (s *ServerSocket) SetKeepAlive(pingInterval, pongWait time.Duration) {
+	s.pingInterval = pingInterval
+	s.pongWait = pongWait
+}
+
+// SetIdleTimeout closes the connections that do not send any application event for the given duration,
+// keepalive answers are not considered activity. A zero duration disables the idle timeout
+func (s *ServerSocket) SetIdleTimeout(d time.Duration) {
+	s.idleTimeout = d
+}
+
+func (s *ServerSocket) processSystemEvent(ctx Context, event *EventMessage) error {
+	switch event.Name {
+	case "edd:keepalive":
+		// the read deadline is already extended by the read loop
+		return nil
+	case "edd:channel:subscribe":
+		return s.subscribe(ctx, event)
+	case "edd:channel:unsubscribe":
+		return s.unsubscribe(ctx, event)
+	default:
+		return NewError(ErrCodeUnknownEvent, "unknown system event %s", event.Name)
+	}
+}
+
+func (c *ClientSocket) extendReadDeadline() {
+	if dc, ok := c.Conn.(DeadlineConn); ok && c.Server.pongWait > 0 {
+		_ = dc.SetReadDeadline(time.Now().Add(c.Server.pongWait))
+	}
+}
+
+func (c *ClientSocket) initKeepAlive() {
+	c.extendReadDeadline()
+	if pc, ok := c.Conn.(PingConn); ok {
+		pc.SetPongHandler(c.extendReadDeadline)
+	}
+	if c.Server.idleTimeout > 0 {
+		c.idleTimer = time.AfterFunc(c.Server.idleTimeout, func() {
+			c.Server.logger.Info("closing idle client", c.logArgs()...)
+			_ = SendError(c, NewError(ErrCodeTimeout, "idle timeout"))
+			_ = c.Close()
+		})
+	}
+}
+
+func (c *ClientSocket) stopKeepAlive() {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+}
+
+// touch records an application activity of the client
+func (c *ClientSocket) touch() {
+	if c.idleTimer != nil {
+		c.idleTimer.Reset(c.Server.idleTimeout)
+	}
+}
+
+// ping is called by the writer, so it never races with other writes
+func (c *ClientSocket) ping(conn Conn) error {
+	if pc, ok := conn.(PingConn); ok {
+		if err := pc.Ping(time.Now().Add(c.Server.writeWait)); err != nil {
+			return err
+		}
+	}
+	m, err := c.codec.Encode(&EventMessageToSend{
+		Channel: SystemChannel,
+		Name:    (*KeepAlive)(nil).ProtocolAlias(),
+		Body:    &KeepAlive{},
+	})
+	if err != nil {
+		return err
+	}
+	// keepalives are not recorded in the session history, they are never replayed
+	return c.writeFrameTo(conn, outboundFrame{mt: c.codec.FrameType(), data: m})
+}
@@ -0,0 +1,181 @@
+package eddwise
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deadlinePipe aborts the pipe when its read deadline expires, as a network connection would fail the read
+type deadlinePipe struct {
+	*PipeConn
+	mx    sync.Mutex
+	timer *time.Timer
+}
+
+func (p *deadlinePipe) SetReadDeadline(t time.Time) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.timer = time.AfterFunc(time.Until(t), p.Abort)
+	return nil
+}
+
+func (p *deadlinePipe) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// idleTestChannel records the clients it was disconnected from
+type idleTestChannel struct {
+	clusterTestChannel
+	disconnected chan uint64
+}
+
+func (ch *idleTestChannel) Disconnected(c Client) error {
+	ch.disconnected <- c.GetId()
+	return nil
+}
+
+func TestIdleTimeout(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetKeepAlive(0, time.Second)
+	s.SetIdleTimeout(100 * time.Millisecond)
+	var ch = &idleTestChannel{disconnected: make(chan uint64, 1)}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	// the peer is not served, it only records what the channel sends
+	var peer = &recorderClient{id: 1000, ClientContextMap: ClientContextMap{m: map[string]interface{}{}}}
+	s.AddClient(peer)
+	s.addSubscriber(ch.Alias(), peer)
+
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(serverConn, nil)
+	defer func() { _ = conn.Close() }()
+	sendJSON(t, conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: ch.Alias()}})
+	expectSkippingUsers(t, conn, SystemChannel, "edd:channel:subscribed")
+	sendJSON(t, conn, EventMessageToSend{Channel: ch.Alias(), Name: "edd:room:create_request", Body: &RoomCreateRequest{Room: "lobby", Public: true}})
+	expectSkippingUsers(t, conn, ch.Alias(), "edd:room:create")
+	expectSkippingUsers(t, conn, ch.Alias(), "edd:room:join")
+	if room := ch.Room("lobby"); room == nil || len(room.Clients()) != 1 {
+		t.Fatalf("the client did not join the room\n")
+	}
+
+	// the client stays silent until the idle timeout
+	var msg = expectSkippingUsers(t, conn, ErrorsChannel, "error")
+	var e Error
+	if err := json.Unmarshal(msg.Body, &e); err != nil || e.Code != ErrCodeTimeout {
+		t.Fatalf("unexpected error %s, expecting %s\n", msg.Body, ErrCodeTimeout)
+	}
+	select {
+	case <-ch.disconnected:
+	case <-time.After(time.Second):
+		t.Fatalf("Disconnected() was not called on idle timeout\n")
+	}
+	if room := ch.Room("lobby"); room != nil && len(room.Clients()) != 0 {
+		t.Fatalf("the idle client did not quit its room\n")
+	}
+	peer.waitFor(t, "game/edd:user:left")
+	waitForClients(t, s, 1)
+}
+
+func TestKeepAlive(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetKeepAlive(20*time.Millisecond, 100*time.Millisecond)
+	var ch = &idleTestChannel{disconnected: make(chan uint64, 1)}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(&deadlinePipe{PipeConn: serverConn}, nil)
+	defer func() { _ = conn.Close() }()
+	subscribeJSON(t, conn, ch.Alias())
+
+	// answering the keepalives extends the read deadline well past the pong wait
+	var start = time.Now()
+	for time.Since(start) < 300*time.Millisecond {
+		expectJSON(t, conn, SystemChannel, "edd:keepalive")
+		sendJSON(t, conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:keepalive", Body: &KeepAlive{}})
+	}
+	if len(s.GetClients()) != 1 {
+		t.Fatalf("the client answering the keepalives was disconnected\n")
+	}
+
+	// a dead connection is dropped once the pong wait is over
+	select {
+	case <-ch.disconnected:
+	case <-time.After(time.Second):
+		t.Fatalf("the silent client was not disconnected\n")
+	}
+	waitForClients(t, s, 0)
+}
+
+func TestIdleTimeoutActivity(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetKeepAlive(0, time.Second)
+	s.SetIdleTimeout(150 * time.Millisecond)
+	var ch = &idleTestChannel{disconnected: make(chan uint64, 1)}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(serverConn, nil)
+	defer func() { _ = conn.Close() }()
+	subscribeJSON(t, conn, ch.Alias())
+
+	// the keepalive answers do not extend the idle timeout, the application events do
+	var start = time.Now()
+	var done = make(chan struct{})
+	defer close(done)
+	go func() {
+		var keepalive, _ = s.codec.Encode(EventMessageToSend{Channel: SystemChannel, Name: "edd:keepalive", Body: &KeepAlive{}})
+		var event, _ = s.codec.Encode(EventMessageToSend{Channel: ch.Alias(), Name: "move", Body: nil})
+		var sentEvent bool
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+			var frame = keepalive
+			if !sentEvent && time.Since(start) > 100*time.Millisecond {
+				frame, sentEvent = event, true
+			}
+			if conn.WriteFrame(TextFrame, frame) != nil {
+				return
+			}
+		}
+	}()
+	var msg = expectSkippingUsers(t, conn, ErrorsChannel, "error")
+	var e Error
+	if err := json.Unmarshal(msg.Body, &e); err != nil || e.Code != ErrCodeTimeout {
+		t.Fatalf("unexpected error %s, expecting %s\n", msg.Body, ErrCodeTimeout)
+	}
+	if elapsed := time.Since(start); elapsed < 240*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("the idle timeout expired after %s, expecting 150ms after the event sent at 100ms\n", elapsed)
+	}
+	select {
+	case <-ch.disconnected:
+	case <-time.After(time.Second):
+		t.Fatalf("Disconnected() was not called on idle timeout\n")
+	}
+}
+
+// waitForClients waits for the server to have n clients, both connected and subscribed to the channels
+func waitForClients(t *testing.T, s *ServerSocket, n int) {
+	t.Helper()
+	var deadline = time.Now().Add(time.Second)
+	for len(s.GetClients()) != n || len(s.GetChannelClients("game")) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected %d clients, expecting %d\n", len(s.GetClients()), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
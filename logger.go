@@ -0,0 +1,95 @@
+package eddwise
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger receives the lifecycle logs of the server, args are alternating keys and values.
+// It is satisfied by *slog.Logger, see NewSlogLogger
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota - 1
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// StdLogger writes to the standard log package as "LEVEL msg key=value ..."
+type StdLogger struct {
+	Level LogLevel
+}
+
+func NewStdLogger(level LogLevel) *StdLogger {
+	return &StdLogger{Level: level}
+}
+
+func (l *StdLogger) log(level LogLevel, msg string, args []interface{}) {
+	if level < l.Level {
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(level.String())
+	sb.WriteByte(' ')
+	sb.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			_, _ = fmt.Fprintf(&sb, " %v=%v", args[i], args[i+1])
+		} else {
+			_, _ = fmt.Fprintf(&sb, " !BADKEY=%v", args[i])
+		}
+	}
+	log.Println(sb.String())
+}
+
+func (l *StdLogger) Debug(msg string, args ...interface{}) { l.log(LevelDebug, msg, args) }
+func (l *StdLogger) Info(msg string, args ...interface{})  { l.log(LevelInfo, msg, args) }
+func (l *StdLogger) Warn(msg string, args ...interface{})  { l.log(LevelWarn, msg, args) }
+func (l *StdLogger) Error(msg string, args ...interface{}) { l.log(LevelError, msg, args) }
+
+// NopLogger discards every log, useful in tests
+type NopLogger struct{}
+
+func (NopLogger) Debug(string, ...interface{}) {}
+func (NopLogger) Info(string, ...interface{})  {}
+func (NopLogger) Warn(string, ...interface{})  {}
+func (NopLogger) Error(string, ...interface{}) {}
+
+// SetLogger replaces the logger of the server, nil silences it
+func (s *ServerSocket) SetLogger(l Logger) {
+	if l == nil {
+		l = NopLogger{}
+	}
+	s.logger = l
+}
+
+func (s *ServerSocket) Logger() Logger {
+	return s.logger
+}
+
+// logArgs prepends the client identification to the log args
+func (c *ClientSocket) logArgs(args ...interface{}) []interface{} {
+	return append([]interface{}{"client_id", c.id, "remote_addr", c.remoteAddr}, args...)
+}
@@ -0,0 +1,36 @@
+//go:build go1.21
+
+package eddwise
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger adapts a *slog.Logger, nil uses slog.Default()
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &slogLogger{l: l.With("component", "eddwise")}
+}
+
+func (sl *slogLogger) Debug(msg string, args ...interface{}) {
+	sl.l.Log(context.Background(), slog.LevelDebug, msg, args...)
+}
+
+func (sl *slogLogger) Info(msg string, args ...interface{}) {
+	sl.l.Log(context.Background(), slog.LevelInfo, msg, args...)
+}
+
+func (sl *slogLogger) Warn(msg string, args ...interface{}) {
+	sl.l.Log(context.Background(), slog.LevelWarn, msg, args...)
+}
+
+func (sl *slogLogger) Error(msg string, args ...interface{}) {
+	sl.l.Log(context.Background(), slog.LevelError, msg, args...)
+}
@@ -0,0 +1,80 @@
+//go:build go1.21
+
+package eddwise
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+// recordHandler keeps the records it handles, with the attributes added by With
+type recordHandler struct {
+	mx      *sync.Mutex
+	records *[]slog.Record
+	attrs   []slog.Attr
+	level   slog.Level
+}
+
+func (h *recordHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	r = r.Clone()
+	r.AddAttrs(h.attrs...)
+	*h.records = append(*h.records, r)
+	return nil
+}
+
+func (h *recordHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var c = *h
+	c.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &c
+}
+
+func (h *recordHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func TestSlogLogger(t *testing.T) {
+	var records []slog.Record
+	var h = &recordHandler{mx: &sync.Mutex{}, records: &records, level: slog.LevelInfo}
+	var l = NewSlogLogger(slog.New(h))
+	l.Debug("hidden", "client_id", 1)
+	l.Info("client connecting", "client_id", uint64(1), "remote_addr", "127.0.0.1")
+	l.Warn("client write error", "error", "broken pipe")
+	l.Error("event error", "channel", "game")
+
+	var expected = []struct {
+		level slog.Level
+		msg   string
+		attrs map[string]string
+	}{
+		{slog.LevelInfo, "client connecting", map[string]string{"client_id": "1", "remote_addr": "127.0.0.1", "component": "eddwise"}},
+		{slog.LevelWarn, "client write error", map[string]string{"error": "broken pipe", "component": "eddwise"}},
+		{slog.LevelError, "event error", map[string]string{"channel": "game", "component": "eddwise"}},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("unexpected %d records, expecting %d: debug logs should be filtered at info level\n", len(records), len(expected))
+	}
+	for i, e := range expected {
+		var r = records[i]
+		if r.Level != e.level || r.Message != e.msg {
+			t.Fatalf("unexpected record %s %s, expecting %s %s\n", r.Level, r.Message, e.level, e.msg)
+		}
+		var attrs = map[string]string{}
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = a.Value.String()
+			return true
+		})
+		for key, value := range e.attrs {
+			if attrs[key] != value {
+				t.Fatalf("unexpected attribute %s=%s of %s, expecting %s\n", key, attrs[key], r.Message, value)
+			}
+		}
+	}
+}
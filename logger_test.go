@@ -0,0 +1,26 @@
+package eddwise
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf = bytes.NewBuffer(nil)
+	var orig = log.Writer()
+	log.SetOutput(buf)
+	defer log.SetOutput(orig)
+
+	var l = NewStdLogger(LevelInfo)
+	l.Debug("hidden", "client_id", 1)
+	l.Info("client connecting", "client_id", 1, "remote_addr", "127.0.0.1")
+	var out = buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Fatalf("debug logs should be filtered at info level: %s\n", out)
+	}
+	if !strings.Contains(out, "INFO client connecting client_id=1 remote_addr=127.0.0.1") {
+		t.Fatalf("unexpected log output: %s\n", out)
+	}
+}
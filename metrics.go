@@ -0,0 +1,335 @@
+package eddwise
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDurationBuckets are the upper bounds, in seconds, of the handler duration histogram
+var DefaultDurationBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// DefaultFanoutBuckets are the upper bounds of the broadcast fan-out size histogram
+var DefaultFanoutBuckets = []float64{1, 5, 10, 50, 100, 500, 1000, 5000, 10000}
+
+const unknownLabel = "_unknown"
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+type metricDesc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *metricDesc) writeHeader(w *bufio.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+}
+
+func (d *metricDesc) labelPairs(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	var write = func(k, v string) {
+		if sb.Len() > 1 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabel(v))
+		sb.WriteByte('"')
+	}
+	for i, l := range d.labels {
+		write(l, values[i])
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		write(extra[i], extra[i+1])
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+const labelSep = "\xff"
+
+// CounterVec is a set of monotonic counters partitioned by label values
+type CounterVec struct {
+	metricDesc
+	mx     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{
+		metricDesc: metricDesc{name: name, help: help, kind: "counter", labels: labels},
+		values:     map[string]float64{},
+	}
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	var key = strings.Join(labelValues, labelSep)
+	c.mx.Lock()
+	c.values[key] += v
+	c.mx.Unlock()
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Value returns the current value of the counter with the given label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.values[strings.Join(labelValues, labelSep)]
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(splitLabels(key, len(c.labels))), formatFloat(c.values[key]))
+	}
+}
+
+func splitLabels(key string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	return strings.Split(key, labelSep)
+}
+
+// GaugeFunc is a gauge evaluated when the metrics are scraped
+type GaugeFunc struct {
+	metricDesc
+	fn func() map[string]float64
+}
+
+func newGaugeFunc(name, help string, label string, fn func() map[string]float64) *GaugeFunc {
+	var labels []string
+	if len(label) > 0 {
+		labels = []string{label}
+	}
+	return &GaugeFunc{
+		metricDesc: metricDesc{name: name, help: help, kind: "gauge", labels: labels},
+		fn:         fn,
+	}
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	var values = g.fn()
+	for _, key := range sortedKeys(values) {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(splitLabels(key, len(g.labels))), formatFloat(values[key]))
+	}
+}
+
+type histogramValue struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec is a set of histograms partitioned by label values
+type HistogramVec struct {
+	metricDesc
+	buckets []float64
+	mx      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{
+		metricDesc: metricDesc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets:    buckets,
+		values:     map[string]*histogramValue{},
+	}
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	var key = strings.Join(labelValues, labelSep)
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	var hv, ok = h.values[key]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hv.counts[i]++
+		}
+	}
+	hv.sum += v
+	hv.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		var hv = h.values[key]
+		var labels = splitLabels(key, len(h.labels))
+		for i, b := range h.buckets {
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(labels, "le", formatFloat(b)), hv.counts[i])
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(labels, "le", "+Inf"), hv.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(labels), formatFloat(hv.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(labels), hv.count)
+	}
+}
+
+// Metrics collects the counters, gauges and histograms of a server
+type Metrics struct {
+	EventsTotal      *CounterVec
+	EventErrors      *CounterVec
+	HandlerDuration  *HistogramVec
+	BroadcastFanout  *HistogramVec
+	SendErrors       *CounterVec
+	OutboundDropped  *CounterVec
+	AuthFailures     *CounterVec
+	ConnectionsTotal *CounterVec
+
+	collectors []metric
+}
+
+func newMetrics(s *ServerSocket) *Metrics {
+	var m = &Metrics{
+		EventsTotal:      newCounterVec("eddwise_events_total", "Number of events received by channel and event name.", "channel", "event"),
+		EventErrors:      newCounterVec("eddwise_event_errors_total", "Number of events that failed processing by channel, event name and error code.", "channel", "event", "code"),
+		HandlerDuration:  newHistogramVec("eddwise_handler_duration_seconds", "Time spent processing an event by channel and event name.", DefaultDurationBuckets, "channel", "event"),
+		BroadcastFanout:  newHistogramVec("eddwise_broadcast_fanout", "Number of recipients of a broadcast by channel.", DefaultFanoutBuckets, "channel"),
+		SendErrors:       newCounterVec("eddwise_send_errors_total", "Number of messages that could not be sent to a client by channel.", "channel"),
+		OutboundDropped:  newCounterVec("eddwise_outbound_dropped_total", "Number of messages dropped from the full outbound queues of the clients."),
+		AuthFailures:     newCounterVec("eddwise_auth_failures_total", "Number of connections rejected by authentication."),
+		ConnectionsTotal: newCounterVec("eddwise_connections_total", "Number of accepted connections."),
+	}
+	m.collectors = []metric{
+		newGaugeFunc("eddwise_connected_clients", "Number of currently connected clients.", "", func() map[string]float64 {
+			var clients int
+			for _, ss := range s.sockets() {
+				ss.ClientsMx.RLock()
+				clients += len(ss.Clients)
+				ss.ClientsMx.RUnlock()
+			}
+			return map[string]float64{"": float64(clients)}
+		}),
+		newGaugeFunc("eddwise_rooms", "Number of rooms by channel.", "channel", func() map[string]float64 {
+			var ret = map[string]float64{}
+			for _, ss := range s.sockets() {
+				for alias, ch := range ss.RegisteredChannels {
+					if rm, ok := ch.(ImplRoomManager); ok {
+						ret[alias] += float64(rm.RoomCount())
+					}
+				}
+			}
+			return ret
+		}),
+		m.ConnectionsTotal, m.AuthFailures, m.EventsTotal, m.EventErrors, m.HandlerDuration, m.BroadcastFanout, m.SendErrors, m.OutboundDropped,
+	}
+	return m
+}
+
+// WritePrometheus writes all the metrics in the prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	var bw = bufio.NewWriter(w)
+	for _, c := range m.collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (m *Metrics) observeEvent(channel, name string, start time.Time, err error) {
+	if err != nil {
+		m.EventErrors.Inc(channel, name, string(AsError(err).Code))
+	}
+	m.EventsTotal.Inc(channel, name)
+	m.HandlerDuration.Observe(time.Since(start).Seconds(), channel, name)
+}
+
+// eventLabels returns the channel and the event labels of a received event, the names sent by the client are used
+// only when declared on a registered channel, to bound the cardinality of the metrics. The channels not declaring
+// their events, see ImplChannelEvents, label only the events they routed without error
+func (s *ServerSocket) eventLabels(event *EventMessage, err error) (string, string) {
+	if event.Channel == SystemChannel {
+		switch event.Name {
+		case "edd:keepalive", "edd:channel:subscribe", "edd:channel:unsubscribe":
+			return event.Channel, event.Name
+		}
+		return event.Channel, unknownLabel
+	}
+	ch, ok := s.RegisteredChannels[event.Channel]
+	if !ok {
+		return unknownLabel, unknownLabel
+	}
+	// the declared events are decoded along with the envelope, unless their body is malformed, see newEvent
+	if event.event != nil || declaresEvent(ch, event.Name) || (isAuthEvent(event.Name) && s.channelAuthMethod(ch, event.Name) != nil) {
+		return event.Channel, event.Name
+	}
+	if _, ok := ch.(ImplChannelEvents); !ok && event.routed && err == nil {
+		return event.Channel, event.Name
+	}
+	return event.Channel, unknownLabel
+}
+
+// declaresEvent reports whether the event is a room event of a room manager or is declared by the channel
+func declaresEvent(ch ImplChannel, name string) bool {
+	if _, ok := ch.(ImplRoomManager); ok && newClientRoomEvent(name) != nil {
+		return true
+	}
+	ce, ok := ch.(ImplChannelEvents)
+	return ok && ce.NewEvent(name) != nil
+}
+
+// Metrics returns the metrics collected by the server
+func (s *ServerSocket) Metrics() *Metrics {
+	return s.metrics
+}
+
+// EnableMetrics exposes the metrics in the prometheus text format on the given path of the fiber app
+func (s *ServerSocket) EnableMetrics(path string) {
+	s.metricsPath = path
+}
+
+// serverOf returns the server of the clients, if they are connected to a ServerSocket
+func serverOf(clients []Client) *ServerSocket {
+	for _, c := range clients {
+		if cs, ok := c.(*ClientSocket); ok {
+			return cs.Server
+		}
+	}
+	return nil
+}
+
+// sortedKeys is used to keep the exposition output stable
+func sortedKeys[T any](m map[string]T) []string {
+	var keys = make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,59 @@
+package eddwise
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var ch, typed = &TestChannel{}, &typedChannel{}
+	for _, c := range []ImplChannel{ch, typed} {
+		if err := s.Register(c); err != nil {
+			t.Fatalf("unexpected error while registering server: %s\n", err)
+		}
+	}
+	var client = &ClientSocket{Server: s, codec: s.codec, queue: newOutboundQueue(8)}
+	s.addSubscriber(ch.Alias(), client)
+	s.addSubscriber(typed.Alias(), client)
+	var ctx = NewDefaultContextFromBackground(s, client)
+	_ = s.ProcessEvent(ctx, []byte(`{"channel":"test","name":"unexpected","body":null}`))
+	_ = s.ProcessEvent(ctx, []byte(`{"channel":"test","name":"another","body":null}`))
+	_ = s.ProcessEvent(ctx, []byte(`{"channel":"test","name":"testRequest","body":"important message"}`))
+	// the label of a declared event does not depend on the decoding of its body
+	_ = s.ProcessEvent(ctx, []byte(`{"channel":"typed","name":"proto","body":{"Id":"x"}}`))
+	_ = s.ProcessEvent(ctx, []byte(`{"channel":"typed","name":"undeclared","body":{}}`))
+	_ = s.ProcessEvent(ctx, []byte(`{"channel":"nope","name":"whatever","body":null}`))
+	_ = s.ProcessEvent(ctx, []byte(`{"channel":"`+SystemChannel+`","name":"edd:keepalive","body":null}`))
+	// the messages dropped from a full queue are counted, the client being gone or not
+	var slow = &ClientSocket{Server: s, queue: newOutboundQueue(1), slowConsumer: DropNewest}
+	for _, m := range []string{"1", "2", "3"} {
+		_ = slow.enqueue(TextFrame, []byte(m))
+	}
+
+	var buf = bytes.NewBuffer(nil)
+	if err := s.Metrics().WritePrometheus(buf); err != nil {
+		t.Fatalf("unable to write metrics: %s\n", err)
+	}
+	var out = buf.String()
+	for _, expected := range []string{
+		"# TYPE eddwise_events_total counter\n",
+		`eddwise_events_total{channel="test",event="_unknown"} 2`,
+		`eddwise_events_total{channel="test",event="testRequest"} 1`,
+		`eddwise_event_errors_total{channel="typed",event="proto",code="internal"} 1`,
+		`eddwise_events_total{channel="typed",event="_unknown"} 1`,
+		`eddwise_events_total{channel="_unknown",event="_unknown"} 1`,
+		`eddwise_events_total{channel="` + SystemChannel + `",event="edd:keepalive"} 1`,
+		`eddwise_event_errors_total{channel="_unknown",event="_unknown",code="unknown_channel"} 1`,
+		`eddwise_handler_duration_seconds_bucket{channel="test",event="_unknown",le="+Inf"} 2`,
+		"eddwise_connected_clients 0\n",
+		"# TYPE eddwise_outbound_dropped_total counter\n",
+		"eddwise_outbound_dropped_total 2\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("missing '%s' in metrics output:\n%s\n", expected, out)
+		}
+	}
+}
@@ -44,6 +44,9 @@ func (cm *Client) Send(channel string, event eddwise.Event) error {
 	})
 	return nil
 }
+func (cm *Client) Reply(channel string, _ uint64, event eddwise.Event) error {
+	return cm.Send(channel, event)
+}
 func (cm *Client) SendJSON(interface{}) error {
 	return errors.New("not implemented in mock")
 }
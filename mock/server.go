@@ -47,12 +47,17 @@ for1:
 	return ret
 }
 
+// GetChannelClients returns every client, the mock serves a single channel and its clients are all subscribed
+func (s *ServerMock) GetChannelClients(_ string, exclude ...uint64) []eddwise.Client {
+	return s.GetClients(exclude...)
+}
+
 func (s *ServerMock) RemoveClient(c eddwise.Client) {
 	s.ClientsMx.Lock()
 	defer s.ClientsMx.Unlock()
 	delete(s.Clients, c.GetId())
 }
 
-func (s *ServerMock) Codec() *eddwise.CodecSerializer {
+func (s *ServerMock) Codec() eddwise.Codec {
 	return nil
 }
@@ -0,0 +1,217 @@
+package eddwise
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// SlowConsumerPolicy defines what happens when the outbound queue of a client is full
+type SlowConsumerPolicy int
+
+const (
+	// DisconnectSlowConsumer closes the connection of a client that is not able to keep up with its queue
+	DisconnectSlowConsumer SlowConsumerPolicy = iota
+	// DropOldest discards the oldest queued message to make room for the new one
+	DropOldest
+	// DropNewest discards the message that is being sent
+	DropNewest
+)
+
+const (
+	DefaultOutboundQueueSize = 256
+	DefaultWriteWait         = 10 * time.Second
+)
+
+var ErrSlowConsumer = errors.New("client outbound queue is full, disconnecting slow consumer")
+
+type outboundFrame struct {
+	mt   FrameType
+	data []byte
+}
+
+// QueueStats reports the state of the outbound queue of a client
+type QueueStats struct {
+	Depth    int    `json:"depth"`
+	Capacity int    `json:"capacity"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+func newOutboundQueue(size int) chan outboundFrame {
+	if size <= 0 {
+		size = DefaultOutboundQueueSize
+	}
+	return make(chan outboundFrame, size)
+}
+
+func (c *ClientSocket) enqueue(mt FrameType, data []byte) error {
+	c.WriteMx.Lock()
+	defer c.WriteMx.Unlock()
+	if c.closed {
+		return errors.New("writing to closed client")
+	}
+	var frame = outboundFrame{mt: mt, data: data}
+	select {
+	case c.queue <- frame:
+		return nil
+	default:
+	}
+	switch c.slowConsumer {
+	case DropNewest:
+		c.drop()
+		return nil
+	case DropOldest:
+		select {
+		case <-c.queue:
+			c.drop()
+		default:
+		}
+		c.queue <- frame
+		return nil
+	default:
+		c.Server.logger.Warn("disconnecting slow consumer", c.logArgs("queue_capacity", cap(c.queue))...)
+		c.closeLocked()
+		// unblock the writer, it may be stuck on a stalled connection
+		if c.Conn != nil {
+			_ = c.Conn.Close()
+		}
+		return ErrSlowConsumer
+	}
+}
+
+// drop counts a message dropped from the full queue
+func (c *ClientSocket) drop() {
+	atomic.AddUint64(&c.dropped, 1)
+	c.Server.metrics.OutboundDropped.Inc()
+}
+
+// attach starts the writer and the keepalive of the current connection, frames are written before the queue
+func (c *ClientSocket) attach(frames ...outboundFrame) {
+	c.writerDone = make(chan struct{})
+	c.detached = make(chan struct{})
+	c.initKeepAlive()
+	go c.writeLoop(frames)
+}
+
+// detach waits for the writer to leave the current connection, which must not be used afterwards
+func (c *ClientSocket) detach() {
+	c.stopKeepAlive()
+	close(c.detached)
+	<-c.writerDone
+	c.WriteMx.Lock()
+	c.Conn = nil
+	c.WriteMx.Unlock()
+}
+
+// writeLoop is the only goroutine writing on the connection, it drains the queue until the client is closed
+// or detached from the connection
+func (c *ClientSocket) writeLoop(frames []outboundFrame) {
+	var conn, detached = c.Conn, c.detached
+	defer close(c.writerDone)
+	defer func() { _ = conn.Close() }()
+	var pingCh <-chan time.Time
+	if c.Server.pingInterval > 0 {
+		var ticker = time.NewTicker(c.Server.pingInterval)
+		defer ticker.Stop()
+		pingCh = ticker.C
+	}
+	var failed bool
+	var fail = func(err error) {
+		c.Server.logger.Warn("client write error", c.logArgs("error", err)...)
+		failed = true
+		// make the read loop fail so the client is cleaned up
+		_ = conn.Close()
+	}
+	// replayed frames already have a sequence number
+	for _, frame := range frames {
+		if err := c.writeFrameTo(conn, frame); err != nil {
+			fail(err)
+			break
+		}
+	}
+	for {
+		select {
+		case frame, ok := <-c.queue:
+			if !ok {
+				return
+			}
+			if failed {
+				// keep it for the replay, if any
+				c.record(frame)
+				continue
+			}
+			if err := c.writeFrame(conn, frame); err != nil {
+				fail(err)
+			}
+		case <-pingCh:
+			if failed {
+				continue
+			}
+			if err := c.ping(conn); err != nil {
+				fail(err)
+			}
+		case <-detached:
+			return
+		}
+	}
+}
+
+func (c *ClientSocket) record(frame outboundFrame) {
+	if c.session != nil {
+		c.session.record(frame)
+	}
+}
+
+func (c *ClientSocket) writeFrame(conn Conn, frame outboundFrame) error {
+	c.record(frame)
+	return c.writeFrameTo(conn, frame)
+}
+
+func (c *ClientSocket) writeFrameTo(conn Conn, frame outboundFrame) error {
+	if dc, ok := conn.(DeadlineConn); ok {
+		_ = dc.SetWriteDeadline(time.Now().Add(c.Server.writeWait))
+	}
+	return conn.WriteFrame(frame.mt, frame.data)
+}
+
+func (c *ClientSocket) closeLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.queue)
+}
+
+// QueueStats returns the current state of the outbound queue
+func (c *ClientSocket) QueueStats() QueueStats {
+	return QueueStats{
+		Depth:    len(c.queue),
+		Capacity: cap(c.queue),
+		Dropped:  atomic.LoadUint64(&c.dropped),
+	}
+}
+
+// SetOutboundQueue configures the size of the outbound queue of each client and what to do when it is full,
+// it affects only clients connected afterwards: both are copied into the client when it connects
+func (s *ServerSocket) SetOutboundQueue(size int, policy SlowConsumerPolicy) {
+	s.outboundQueueSize = size
+	s.slowConsumerPolicy = policy
+}
+
+// SetWriteWait sets the maximum time allowed to write a single message to a client
+func (s *ServerSocket) SetWriteWait(d time.Duration) {
+	s.writeWait = d
+}
+
+// QueueStats returns the outbound queue state of each connected client, by client id
+func (s *ServerSocket) QueueStats() map[uint64]QueueStats {
+	s.ClientsMx.RLock()
+	defer s.ClientsMx.RUnlock()
+	var ret = make(map[uint64]QueueStats, len(s.Clients))
+	for id, c := range s.Clients {
+		if cs, ok := c.(*ClientSocket); ok {
+			ret[id] = cs.QueueStats()
+		}
+	}
+	return ret
+}
@@ -0,0 +1,81 @@
+package eddwise
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutboundQueuePolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy SlowConsumerPolicy
+		first  string
+	}{
+		{DropOldest, "2"},
+		{DropNewest, "1"},
+	} {
+		var s = NewServer()
+		s.SetOutboundQueue(2, tc.policy)
+		var client = &ClientSocket{Server: s, queue: newOutboundQueue(s.outboundQueueSize), slowConsumer: tc.policy}
+		for _, m := range []string{"1", "2", "3"} {
+			if err := client.enqueue(TextFrame, []byte(m)); err != nil {
+				t.Fatalf("unexpected error while enqueueing with policy %d: %s\n", tc.policy, err)
+			}
+		}
+		var stats = client.QueueStats()
+		if stats.Depth != 2 || stats.Capacity != 2 || stats.Dropped != 1 {
+			t.Fatalf("unexpected queue stats %+v with policy %d\n", stats, tc.policy)
+		}
+		if first := string((<-client.queue).data); first != tc.first {
+			t.Fatalf("unexpected first message '%s' with policy %d, expecting '%s'\n", first, tc.policy, tc.first)
+		}
+	}
+}
+
+func TestOutboundQueueDisconnect(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetOutboundQueue(2, DisconnectSlowConsumer)
+	var serverConn, conn = NewPipe()
+	var client = &ClientSocket{Server: s, Conn: serverConn, queue: newOutboundQueue(s.outboundQueueSize)}
+	for _, m := range []string{"1", "2"} {
+		if err := client.enqueue(TextFrame, []byte(m)); err != nil {
+			t.Fatalf("unexpected error while enqueueing: %s\n", err)
+		}
+	}
+	if err := client.enqueue(TextFrame, []byte("3")); !errors.Is(err, ErrSlowConsumer) {
+		t.Fatalf("unexpected error %v, expecting %s\n", err, ErrSlowConsumer)
+	}
+	if !client.Closed() {
+		t.Fatalf("expecting the slow consumer to be closed\n")
+	}
+	if _, _, err := conn.ReadFrame(); err == nil {
+		t.Fatalf("expecting the connection to be closed\n")
+	}
+	if err := client.enqueue(TextFrame, []byte("4")); err == nil {
+		t.Fatalf("expecting an error while writing to a closed client\n")
+	}
+}
+
+func TestOutboundQueueSettings(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetOutboundQueue(2, DropNewest)
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(serverConn, nil)
+	defer func() { _ = conn.Close() }()
+	var deadline = time.Now().Add(time.Second)
+	for len(s.GetClients()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the client did not connect\n")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	var client = s.GetClients()[0].(*ClientSocket)
+
+	// the connected client keeps the settings it connected with
+	s.SetOutboundQueue(4, DisconnectSlowConsumer)
+	if client.slowConsumer != DropNewest || cap(client.queue) != 2 {
+		t.Fatalf("unexpected policy %d and capacity %d, expecting the ones set when the client connected\n", client.slowConsumer, cap(client.queue))
+	}
+}
@@ -0,0 +1,128 @@
+package eddwise
+
+import (
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Policy is the authorization rule declared in the design for a channel or an event: the client needs one of the
+// Roles, if any, and all the Scopes
+type Policy struct {
+	Roles  []string
+	Scopes []string
+}
+
+// PolicyHook decides whether the auth satisfies the policy, auth is nil for the clients not authenticated
+type PolicyHook func(auth *Auth, policy Policy) bool
+
+// PolicySubject can be implemented by Auth.Data to expose its roles and scopes to DefaultPolicyHook
+type PolicySubject interface {
+	PolicyRoles() []string
+	PolicyScopes() []string
+}
+
+// SetPolicyHook replaces DefaultPolicyHook in the evaluation of the policies
+func (s *ServerSocket) SetPolicyHook(fn PolicyHook) {
+	s.policyHook = fn
+}
+
+// DefaultPolicyHook reads the roles and the scopes of a PolicySubject or, when Auth.Data is a map of claims, in
+// the "roles" or "role" and the "scopes" or "scope" claims, either arrays or space separated strings
+func DefaultPolicyHook(auth *Auth, policy Policy) bool {
+	if len(policy.Roles) == 0 && len(policy.Scopes) == 0 {
+		return true
+	}
+	if auth == nil {
+		return false
+	}
+	var roles, scopes []string
+	switch data := auth.Data.(type) {
+	case PolicySubject:
+		roles, scopes = data.PolicyRoles(), data.PolicyScopes()
+	case map[string]interface{}:
+		roles, scopes = claimStrings(data, "roles", "role"), claimStrings(data, "scopes", "scope")
+	}
+	if len(policy.Roles) > 0 {
+		var found bool
+		for _, role := range policy.Roles {
+			if slices.Contains(roles, role) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, scope := range policy.Scopes {
+		if !slices.Contains(scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// claimStrings returns the strings of the first claim found among names
+func claimStrings(claims map[string]interface{}, names ...string) []string {
+	for _, name := range names {
+		switch v := claims[name].(type) {
+		case string:
+			return strings.Fields(v)
+		case []string:
+			return v
+		case []interface{}:
+			var ret = make([]string, 0, len(v))
+			for _, e := range v {
+				if s, ok := e.(string); ok {
+					ret = append(ret, s)
+				}
+			}
+			return ret
+		}
+	}
+	return nil
+}
+
+func serverPolicyHook(server Server) PolicyHook {
+	if s, ok := server.(*ServerSocket); ok && s.policyHook != nil {
+		return s.policyHook
+	}
+	return DefaultPolicyHook
+}
+
+// ImplChannelGuard is implemented by the generated channels, Guard enforces the policy and the rate limit declared
+// on the whole channel. The server runs it for the room events, Route checks the same along with those of the event
+type ImplChannelGuard interface {
+	Guard(Context) error
+}
+
+// Authorize checks the policy against the auth of the client, the generated Route uses it to enforce the policies
+// declared in the design. An empty event identifies the policy of the whole channel
+func Authorize(ctx Context, channel, event string, policy Policy) error {
+	if serverPolicyHook(ctx.GetServer())(ctx.GetClient().GetRawAuth(), policy) {
+		return nil
+	}
+	if len(event) > 0 {
+		return NewError(ErrCodeForbidden, "forbidden event %s in channel %s", event, channel).withOrigin(channel, event)
+	}
+	return NewError(ErrCodeForbidden, "forbidden in channel %s", channel).withOrigin(channel, "")
+}
+
+// AuthorizedClients returns the clients satisfying all the policies, the generated Broadcast functions use it to
+// restrict the receivers of the server events
+func AuthorizedClients(server Server, clients []Client, policies ...Policy) []Client {
+	var hook = serverPolicyHook(server)
+	var ret = make([]Client, 0, len(clients))
+for1:
+	for _, c := range clients {
+		var auth = c.GetRawAuth()
+		for _, policy := range policies {
+			if !hook(auth, policy) {
+				continue for1
+			}
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
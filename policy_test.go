@@ -0,0 +1,99 @@
+package eddwise
+
+import (
+	"testing"
+)
+
+type policyTestData struct{ roles []string }
+
+func (d policyTestData) PolicyRoles() []string  { return d.roles }
+func (d policyTestData) PolicyScopes() []string { return nil }
+
+func TestDefaultPolicyHook(t *testing.T) {
+	var claims = &Auth{Id: "user", Data: map[string]interface{}{"roles": []interface{}{"player"}, "scope": "game:play chat:write"}}
+	var tests = []struct {
+		name   string
+		auth   *Auth
+		policy Policy
+		allow  bool
+	}{
+		{"empty policy", nil, Policy{}, true},
+		{"anonymous", nil, Policy{Roles: []string{"player"}}, false},
+		{"one of the roles", claims, Policy{Roles: []string{"admin", "player"}}, true},
+		{"missing role", claims, Policy{Roles: []string{"admin"}}, false},
+		{"all the scopes", claims, Policy{Scopes: []string{"game:play", "chat:write"}}, true},
+		{"missing scope", claims, Policy{Roles: []string{"player"}, Scopes: []string{"game:admin"}}, false},
+		{"policy subject", &Auth{Id: "user", Data: policyTestData{roles: []string{"admin"}}}, Policy{Roles: []string{"admin"}}, true},
+		{"unknown data", &Auth{Id: "user", Data: "admin"}, Policy{Roles: []string{"admin"}}, false},
+	}
+	for _, test := range tests {
+		if allow := DefaultPolicyHook(test.auth, test.policy); allow != test.allow {
+			t.Fatalf("%s: unexpected evaluation %v\n", test.name, allow)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	var s = NewServer()
+	var admin, player = &ClientSocket{id: 1}, &ClientSocket{id: 2}
+	admin.setRawAuth(&Auth{Id: "admin", Data: map[string]interface{}{"role": "admin"}})
+	player.setRawAuth(&Auth{Id: "player", Data: map[string]interface{}{"role": "player"}})
+	var policy = Policy{Roles: []string{"admin"}}
+
+	if err := Authorize(NewDefaultContextFromBackground(s, admin), "game", "kick", policy); err != nil {
+		t.Fatalf("unexpected error %s\n", err)
+	}
+	var err = Authorize(NewDefaultContextFromBackground(s, player), "game", "kick", policy)
+	if e := AsError(err); err == nil || e.Code != ErrCodeForbidden || e.Channel != "game" || e.Event != "kick" {
+		t.Fatalf("unexpected error %v, expecting %s\n", err, ErrCodeForbidden)
+	}
+	if clients := AuthorizedClients(s, []Client{admin, player}, policy); len(clients) != 1 || clients[0] != admin {
+		t.Fatalf("unexpected authorized clients %v\n", clients)
+	}
+
+	s.SetPolicyHook(func(auth *Auth, policy Policy) bool {
+		return auth != nil
+	})
+	if clients := AuthorizedClients(s, []Client{admin, player}, policy); len(clients) != 2 {
+		t.Fatalf("the policy hook was not used\n")
+	}
+}
+
+// guardedRoomChannel lets only the admins use its rooms, as a generated channel declaring roles would
+type guardedRoomChannel struct {
+	clusterTestChannel
+}
+
+func (ch *guardedRoomChannel) Guard(ctx Context) error {
+	return Authorize(ctx, ch.Alias(), "", Policy{Roles: []string{"admin"}})
+}
+
+func TestRoomEventsGuard(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var ch = &guardedRoomChannel{}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var admin = &recorderClient{id: 1, ClientContextMap: ClientContextMap{m: map[string]interface{}{}}}
+	var player = &recorderClient{id: 2, ClientContextMap: ClientContextMap{m: map[string]interface{}{}}}
+	admin.setRawAuth(&Auth{Id: "admin", Data: map[string]interface{}{"role": "admin"}})
+	player.setRawAuth(&Auth{Id: "player", Data: map[string]interface{}{"role": "player"}})
+	var create = []byte(`{"channel":"game","name":"edd:room:create_request","body":{"room":"lobby","public":true}}`)
+
+	s.addSubscriber(ch.Alias(), player)
+	var err = s.ProcessEvent(NewDefaultContextFromBackground(s, player), create)
+	if e := AsError(err); err == nil || e.Code != ErrCodeForbidden {
+		t.Fatalf("unexpected error %v, expecting %s\n", err, ErrCodeForbidden)
+	}
+	if ch.Room("lobby") != nil {
+		t.Fatalf("the room was created by a forbidden client\n")
+	}
+	s.addSubscriber(ch.Alias(), admin)
+	if err := s.ProcessEvent(NewDefaultContextFromBackground(s, admin), create); err != nil {
+		t.Fatalf("unexpected error %s\n", err)
+	}
+	if ch.Room("lobby") == nil {
+		t.Fatalf("the room was not created\n")
+	}
+}
@@ -0,0 +1,140 @@
+package eddwise
+
+import (
+	"fmt"
+
+	"github.com/exelr/eddwise/protowire"
+	"github.com/ugorji/go/codec"
+)
+
+const ProtocolProtobuf = "edd.protobuf"
+
+// ProtoMessage is implemented by the events generated from the design, see the .proto schema emitted by edd gen
+type ProtoMessage interface {
+	MarshalProto() ([]byte, error)
+	UnmarshalProto([]byte) error
+}
+
+var _ Codec = (*ProtobufCodec)(nil)
+
+// ProtobufCodec wraps each message in an envelope {1: channel, 2: name, 3: id, 4: body, 5: json}.
+// Bodies implementing ProtoMessage are protobuf encoded, the others, such as the system events, are json
+// encoded and flagged as such
+type ProtobufCodec struct {
+	json Codec
+}
+
+func NewProtobufCodec() *ProtobufCodec {
+	return &ProtobufCodec{json: NewJSONCodec()}
+}
+
+func (pc *ProtobufCodec) Name() string {
+	return ProtocolProtobuf
+}
+
+func (pc *ProtobufCodec) FrameType() FrameType {
+	return BinaryFrame
+}
+
+func (pc *ProtobufCodec) Encode(v interface{}) ([]byte, error) {
+	switch msg := v.(type) {
+	case *EventMessageToSend:
+		return pc.encodeEnvelope(msg)
+	case EventMessageToSend:
+		return pc.encodeEnvelope(&msg)
+	case ProtoMessage:
+		return msg.MarshalProto()
+	default:
+		return pc.json.Encode(v)
+	}
+}
+
+func (pc *ProtobufCodec) encodeEnvelope(msg *EventMessageToSend) ([]byte, error) {
+	var body []byte
+	var err error
+	var isJSON bool
+	if pm, ok := msg.Body.(ProtoMessage); ok {
+		body, err = pm.MarshalProto()
+	} else {
+		body, err = pc.json.Encode(msg.Body)
+		isJSON = true
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b = make([]byte, 0, len(msg.Channel)+len(msg.Name)+len(body)+16)
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, msg.Channel)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, msg.Name)
+	if msg.Id != 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, msg.Id)
+	}
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	b = protowire.AppendBytes(b, body)
+	if isJSON {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendBool(b, true)
+	}
+	return b, nil
+}
+
+func (pc *ProtobufCodec) Decode(data []byte, v interface{}) error {
+	switch msg := v.(type) {
+	case *EventMessage:
+		return pc.decodeEnvelope(data, msg)
+	case ProtoMessage:
+		return msg.UnmarshalProto(data)
+	default:
+		return pc.json.Decode(data, v)
+	}
+}
+
+// decodeEnvelope decodes the body into the event resolved by msg.newEvent, if any, otherwise it keeps the body raw:
+// a json body is decoded later with the json codec
+func (pc *ProtobufCodec) decodeEnvelope(data []byte, msg *EventMessage) error {
+	var r = protowire.NewReader(data)
+	msg.codec = pc
+	for {
+		num, t, ok, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		switch num {
+		case 1:
+			msg.Channel, err = r.String()
+		case 2:
+			msg.Name, err = r.String()
+		case 3:
+			msg.Id, err = r.Varint()
+		case 4:
+			var body []byte
+			body, err = r.Bytes()
+			msg.Body = codec.Raw(body)
+		case 5:
+			var isJSON bool
+			if isJSON, err = r.Bool(); isJSON {
+				msg.codec = pc.json
+			}
+		default:
+			err = r.Skip(t)
+		}
+		if err != nil {
+			return fmt.Errorf("protobuf envelope: %w", err)
+		}
+	}
+	if msg.newEvent == nil {
+		return nil
+	}
+	if event := msg.newEvent(msg.Channel, msg.Name); event != nil {
+		if err := msg.codec.Decode(msg.Body, event); err != nil {
+			return err
+		}
+		msg.Body, msg.event = nil, event
+	}
+	return nil
+}
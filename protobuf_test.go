@@ -0,0 +1,82 @@
+package eddwise
+
+import (
+	"testing"
+
+	"github.com/exelr/eddwise/protowire"
+)
+
+type testProto struct {
+	Id    uint64
+	Score int
+}
+
+func (tp *testProto) MarshalProto() ([]byte, error) {
+	var b = protowire.AppendTag(nil, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, tp.Id)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	return protowire.AppendZigZag(b, int64(tp.Score)), nil
+}
+
+func (tp *testProto) UnmarshalProto(data []byte) error {
+	var r = protowire.NewReader(data)
+	for {
+		num, t, ok, err := r.Next()
+		if err != nil || !ok {
+			return err
+		}
+		switch num {
+		case 1:
+			tp.Id, err = r.Varint()
+		case 2:
+			var x int64
+			x, err = r.ZigZag()
+			tp.Score = int(x)
+		default:
+			err = r.Skip(t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	var pc = NewProtobufCodec()
+	data, err := pc.Encode(&EventMessageToSend{Channel: "test", Name: "proto", Id: 300, Body: &testProto{Id: 1 << 40, Score: -42}})
+	if err != nil {
+		t.Fatalf("unable to encode: %s\n", err)
+	}
+	var msg = &EventMessage{}
+	if err := pc.Decode(data, msg); err != nil {
+		t.Fatalf("unable to decode: %s\n", err)
+	}
+	var body testProto
+	if err := msg.DecodeBody(&body); err != nil || msg.Channel != "test" || msg.Name != "proto" || msg.Id != 300 {
+		t.Fatalf("unexpected envelope %s %s %d %v\n", msg.Channel, msg.Name, msg.Id, err)
+	}
+	if body.Id != 1<<40 || body.Score != -42 {
+		t.Fatalf("unexpected body %+v\n", body)
+	}
+
+	data, err = pc.Encode(EventMessageToSend{Channel: "edd", Name: "error", Body: map[string]string{"code": "x"}})
+	if err != nil {
+		t.Fatalf("unable to encode json body: %s\n", err)
+	}
+	msg = &EventMessage{}
+	if err := pc.Decode(data, msg); err != nil {
+		t.Fatalf("unable to decode json body: %s\n", err)
+	}
+	var jsonBody map[string]string
+	if err := msg.DecodeBody(&jsonBody); err != nil || jsonBody["code"] != "x" {
+		t.Fatalf("unexpected json body %v %v\n", jsonBody, err)
+	}
+}
+
+func (*testProto) GetEventName() string {
+	return "proto"
+}
+
+func (*testProto) ProtocolAlias() string {
+	return "proto"
+}
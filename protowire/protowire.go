@@ -0,0 +1,190 @@
+// Package protowire implements the subset of the protobuf wire format used by the code generated by edd gen
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+type Type int
+
+const (
+	VarintType  Type = 0
+	Fixed64Type Type = 1
+	BytesType   Type = 2
+	Fixed32Type Type = 5
+)
+
+var ErrTruncated = errors.New("protowire: truncated message")
+
+func AppendTag(b []byte, num int, t Type) []byte {
+	return AppendVarint(b, uint64(num)<<3|uint64(t))
+}
+
+func AppendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// AppendZigZag appends a signed integer as sint64
+func AppendZigZag(b []byte, v int64) []byte {
+	return AppendVarint(b, uint64(v<<1)^uint64(v>>63))
+}
+
+func AppendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+func AppendFloat32(b []byte, v float32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], math.Float32bits(v))
+	return append(b, buf[:]...)
+}
+
+func AppendFloat64(b []byte, v float64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b, buf[:]...)
+}
+
+// AppendBytes appends a length delimited value
+func AppendBytes(b []byte, v []byte) []byte {
+	return append(AppendVarint(b, uint64(len(v))), v...)
+}
+
+func AppendString(b []byte, v string) []byte {
+	return append(AppendVarint(b, uint64(len(v))), v...)
+}
+
+// Reader reads the fields of a message in order
+type Reader struct {
+	data []byte
+	pos  int
+}
+
+func NewReader(data []byte) *Reader {
+	return &Reader{data: data}
+}
+
+// Next returns the number and the type of the next field, ok is false at the end of the message
+func (r *Reader) Next() (num int, t Type, ok bool, err error) {
+	if r.pos >= len(r.data) {
+		return 0, 0, false, nil
+	}
+	tag, err := r.Varint()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	num, t = int(tag>>3), Type(tag&7)
+	if num <= 0 {
+		return 0, 0, false, fmt.Errorf("protowire: invalid field number %d", num)
+	}
+	return num, t, true, nil
+}
+
+func (r *Reader) Varint() (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if r.pos >= len(r.data) {
+			return 0, ErrTruncated
+		}
+		var c = r.data[r.pos]
+		r.pos++
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("protowire: varint overflow")
+}
+
+func (r *Reader) ZigZag() (int64, error) {
+	v, err := r.Varint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *Reader) Bool() (bool, error) {
+	v, err := r.Varint()
+	return v != 0, err
+}
+
+func (r *Reader) Float32() (float32, error) {
+	if r.pos+4 > len(r.data) {
+		return 0, ErrTruncated
+	}
+	var v = binary.LittleEndian.Uint32(r.data[r.pos:])
+	r.pos += 4
+	return math.Float32frombits(v), nil
+}
+
+func (r *Reader) Float64() (float64, error) {
+	if r.pos+8 > len(r.data) {
+		return 0, ErrTruncated
+	}
+	var v = binary.LittleEndian.Uint64(r.data[r.pos:])
+	r.pos += 8
+	return math.Float64frombits(v), nil
+}
+
+// Bytes returns a length delimited value, it shares the memory of the message
+func (r *Reader) Bytes() ([]byte, error) {
+	n, err := r.Varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, ErrTruncated
+	}
+	var v = r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return v, nil
+}
+
+func (r *Reader) String() (string, error) {
+	v, err := r.Bytes()
+	return string(v), err
+}
+
+// Skip discards the value of an unknown field
+func (r *Reader) Skip(t Type) error {
+	var err error
+	switch t {
+	case VarintType:
+		_, err = r.Varint()
+	case Fixed64Type:
+		_, err = r.Float64()
+	case Fixed32Type:
+		_, err = r.Float32()
+	case BytesType:
+		_, err = r.Bytes()
+	default:
+		err = fmt.Errorf("protowire: unsupported wire type %d", t)
+	}
+	return err
+}
+
+// Packed calls read for each element of a packed repeated field, unpacked elements are read with read alone
+func (r *Reader) Packed(t Type, read func(*Reader) error) error {
+	if t != BytesType {
+		return read(r)
+	}
+	data, err := r.Bytes()
+	if err != nil {
+		return err
+	}
+	var sub = NewReader(data)
+	for sub.pos < len(sub.data) {
+		if err := read(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
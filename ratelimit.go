@@ -0,0 +1,215 @@
+package eddwise
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Rate events per second on average, with bursts of up to Burst events.
+// A zero Burst defaults to the rate rounded up
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+// RateLimitAction defines what happens to a client exceeding a rate limit
+type RateLimitAction int
+
+const (
+	// RejectOverLimit drops the event and notifies the client with an ErrCodeRateLimited error
+	RejectOverLimit RateLimitAction = iota
+	// DisconnectOverLimit notifies the client and closes its connection
+	DisconnectOverLimit
+)
+
+type tokenBucket struct {
+	mx     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(limit RateLimit, now time.Time) bool {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	var burst = limit.burst()
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refund gives back a token taken by a rejected event
+func (b *tokenBucket) refund(limit RateLimit) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.tokens = math.Min(limit.burst(), b.tokens+1)
+}
+
+// allow consumes a token of the bucket identified by key, buckets are created full
+func (cc *ClientContextMap) allow(key string, limit RateLimit) bool {
+	var now = time.Now()
+	b, ok := cc.buckets.Load(key)
+	if !ok {
+		b, _ = cc.buckets.LoadOrStore(key, &tokenBucket{tokens: limit.burst(), last: now})
+	}
+	return b.(*tokenBucket).take(limit, now)
+}
+
+// refund gives back the token consumed by allow on the bucket identified by key
+func (cc *ClientContextMap) refund(key string, limit RateLimit) {
+	if b, ok := cc.buckets.Load(key); ok {
+		b.(*tokenBucket).refund(limit)
+	}
+}
+
+type rateLimits struct {
+	mx       sync.RWMutex
+	global   RateLimit
+	channels map[string]RateLimit
+	events   map[string]RateLimit
+	action   RateLimitAction
+}
+
+// SetRateLimit limits the events each client can send, regardless of channel and event, keepalive answers excepted.
+// A zero rate disables it
+func (s *ServerSocket) SetRateLimit(limit RateLimit) {
+	s.rateLimits.mx.Lock()
+	defer s.rateLimits.mx.Unlock()
+	s.rateLimits.global = limit
+}
+
+// SetChannelRateLimit limits the events each client can send on a channel. A zero rate disables it
+func (s *ServerSocket) SetChannelRateLimit(channel string, limit RateLimit) {
+	s.rateLimits.mx.Lock()
+	defer s.rateLimits.mx.Unlock()
+	if s.rateLimits.channels == nil {
+		s.rateLimits.channels = map[string]RateLimit{}
+	}
+	setRateLimit(s.rateLimits.channels, channel, limit)
+}
+
+// SetEventRateLimit limits how often each client can send an event, room events included. A zero rate disables it
+func (s *ServerSocket) SetEventRateLimit(channel, event string, limit RateLimit) {
+	s.rateLimits.mx.Lock()
+	defer s.rateLimits.mx.Unlock()
+	if s.rateLimits.events == nil {
+		s.rateLimits.events = map[string]RateLimit{}
+	}
+	setRateLimit(s.rateLimits.events, channel+"/"+event, limit)
+}
+
+// SetRateLimitAction defines what happens to the clients exceeding a limit, RejectOverLimit by default
+func (s *ServerSocket) SetRateLimitAction(action RateLimitAction) {
+	s.rateLimits.mx.Lock()
+	defer s.rateLimits.mx.Unlock()
+	s.rateLimits.action = action
+}
+
+func setRateLimit(m map[string]RateLimit, key string, limit RateLimit) {
+	if limit.Rate <= 0 {
+		delete(m, key)
+		return
+	}
+	m[key] = limit
+}
+
+// limitRate enforces the limits configured on the server
+func (s *ServerSocket) limitRate(ctx Context, event *EventMessage) error {
+	s.rateLimits.mx.RLock()
+	var global = s.rateLimits.global
+	var channel, hasChannel = s.rateLimits.channels[event.Channel]
+	var ev, hasEvent = s.rateLimits.events[event.Channel+"/"+event.Name]
+	s.rateLimits.mx.RUnlock()
+
+	var buckets = make([]bucketLimit, 0, 3)
+	if global.Rate > 0 {
+		buckets = append(buckets, bucketLimit{"*", global})
+	}
+	if hasChannel {
+		buckets = append(buckets, bucketLimit{"channel:" + event.Channel, channel})
+	}
+	if hasEvent {
+		buckets = append(buckets, bucketLimit{"event:" + event.Channel + "/" + event.Name, ev})
+	}
+	var client = ctx.GetClient()
+	for i, b := range buckets {
+		if !client.allow(b.key, b.limit) {
+			// the rejected event does not drain the buckets it passed
+			for _, passed := range buckets[:i] {
+				client.refund(passed.key, passed.limit)
+			}
+			return s.overLimit(client, event.Channel, event.Name)
+		}
+	}
+	return nil
+}
+
+type bucketLimit struct {
+	key   string
+	limit RateLimit
+}
+
+func (s *ServerSocket) overLimit(client Client, channel, event string) error {
+	var e = NewError(ErrCodeRateLimited, "rate limit exceeded").withOrigin(channel, event)
+	s.rateLimits.mx.RLock()
+	var action = s.rateLimits.action
+	s.rateLimits.mx.RUnlock()
+	if action == DisconnectOverLimit {
+		s.logger.Info("disconnecting client over rate limit", "client_id", client.GetId(), "channel", channel, "event", event)
+		_ = SendError(client, e)
+		_ = client.Close()
+	}
+	return e
+}
+
+// LimitRate consumes a token of the client bucket for the event, the generated Route uses it to enforce
+// the limits declared in the design. An empty event identifies the limit of the whole channel
+func LimitRate(ctx Context, channel, event string, limit RateLimit) error {
+	var client = ctx.GetClient()
+	if client.allow("route:"+channel+"/"+event, limit) {
+		return nil
+	}
+	if s, ok := ctx.GetServer().(*ServerSocket); ok {
+		return s.overLimit(client, channel, event)
+	}
+	return NewError(ErrCodeRateLimited, "rate limit exceeded")
+}
+
+// RefundRate gives back the token consumed by LimitRate, the generated Route uses it when a later check rejects
+// the event
+func RefundRate(ctx Context, channel, event string, limit RateLimit) {
+	ctx.GetClient().refund("route:"+channel+"/"+event, limit)
+}
+
+// copyFrom copies the limits of src, the maps are not shared
+func (rl *rateLimits) copyFrom(src *rateLimits) {
+	src.mx.RLock()
+	defer src.mx.RUnlock()
+	rl.mx.Lock()
+	defer rl.mx.Unlock()
+	rl.global, rl.action = src.global, src.action
+	rl.channels, rl.events = nil, nil
+	for key, limit := range src.channels {
+		if rl.channels == nil {
+			rl.channels = map[string]RateLimit{}
+		}
+		rl.channels[key] = limit
+	}
+	for key, limit := range src.events {
+		if rl.events == nil {
+			rl.events = map[string]RateLimit{}
+		}
+		rl.events[key] = limit
+	}
+}
@@ -0,0 +1,143 @@
+package eddwise
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var limit = RateLimit{Rate: 2, Burst: 3}
+	var now = time.Now()
+	var b = &tokenBucket{tokens: limit.burst(), last: now}
+	for i := 0; i < 3; i++ {
+		if !b.take(limit, now) {
+			t.Fatalf("burst token %d refused\n", i)
+		}
+	}
+	if b.take(limit, now) {
+		t.Fatalf("expecting the bucket to be empty\n")
+	}
+	if !b.take(limit, now.Add(500*time.Millisecond)) {
+		t.Fatalf("expecting a token to be refilled after 500ms\n")
+	}
+	if (RateLimit{Rate: 0.5}).burst() != 1 {
+		t.Fatalf("expecting a default burst of 1\n")
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	if err := s.Register(&TestChannel{}); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	s.SetEventRateLimit("test", "testRequest", RateLimit{Rate: 0.001, Burst: 2})
+	var client = &recorderClient{id: 1, ClientContextMap: ClientContextMap{m: map[string]interface{}{}}}
+	s.addSubscriber("test", client)
+	var ctx = NewDefaultContextFromBackground(s, client)
+	var msg = []byte(`{"channel":"test","name":"testRequest","body":"important message"}`)
+	for i := 0; i < 2; i++ {
+		if err := s.ProcessEvent(ctx, msg); err != nil {
+			t.Fatalf("unexpected error: %s\n", err)
+		}
+	}
+	var e *Error
+	if err := s.ProcessEvent(ctx, msg); !errors.As(err, &e) || e.Code != ErrCodeRateLimited || e.Event != "testRequest" {
+		t.Fatalf("expecting a rate limited error, got %v\n", err)
+	}
+
+	if err := LimitRate(ctx, "test", "", RateLimit{Rate: 0.001, Burst: 1}); err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+	if err := LimitRate(ctx, "test", "", RateLimit{Rate: 0.001, Burst: 1}); !errors.As(err, &e) || e.Code != ErrCodeRateLimited {
+		t.Fatalf("expecting a rate limited error, got %v\n", err)
+	}
+}
+
+func TestSystemRateLimit(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetRateLimit(RateLimit{Rate: 0.001, Burst: 2})
+	var client = &recorderClient{id: 1, ClientContextMap: ClientContextMap{m: map[string]interface{}{}}}
+	var ctx = NewDefaultContextFromBackground(s, client)
+	// keepalive answers do not spend the tokens of the client
+	var keepalive = []byte(`{"channel":"` + SystemChannel + `","name":"edd:keepalive","body":{}}`)
+	for i := 0; i < 5; i++ {
+		if err := s.ProcessEvent(ctx, keepalive); err != nil {
+			t.Fatalf("unexpected error: %s\n", err)
+		}
+	}
+	var e *Error
+	var msg = []byte(`{"channel":"` + SystemChannel + `","name":"edd:channel:subscribe","body":{"channel":"unknown"}}`)
+	for i := 0; i < 2; i++ {
+		if err := s.ProcessEvent(ctx, msg); !errors.As(err, &e) || e.Code != ErrCodeUnknownChannel {
+			t.Fatalf("expecting an unknown channel error, got %v\n", err)
+		}
+	}
+	if err := s.ProcessEvent(ctx, msg); !errors.As(err, &e) || e.Code != ErrCodeRateLimited || e.Channel != SystemChannel {
+		t.Fatalf("expecting a rate limited error on the system channel, got %v\n", err)
+	}
+	if err := s.ProcessEvent(ctx, keepalive); err != nil {
+		t.Fatalf("unexpected error over the limit: %s\n", err)
+	}
+}
+
+func TestRateLimitRefund(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	if err := s.Register(&TestChannel{}); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	s.SetRateLimit(RateLimit{Rate: 0.001, Burst: 2})
+	s.SetEventRateLimit("test", "testRequest", RateLimit{Rate: 0.001, Burst: 1})
+	var client = &recorderClient{id: 1, ClientContextMap: ClientContextMap{m: map[string]interface{}{}}}
+	s.addSubscriber("test", client)
+	var ctx = NewDefaultContextFromBackground(s, client)
+	var msg = []byte(`{"channel":"test","name":"testRequest","body":"important message"}`)
+	if err := s.ProcessEvent(ctx, msg); err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+	var e *Error
+	if err := s.ProcessEvent(ctx, msg); !errors.As(err, &e) || e.Code != ErrCodeRateLimited {
+		t.Fatalf("expecting a rate limited error, got %v\n", err)
+	}
+	// the global token taken by the rejected event was given back
+	var other = []byte(`{"channel":"test","name":"other","body":"important message"}`)
+	if err := s.ProcessEvent(ctx, other); errors.As(err, &e) && e.Code == ErrCodeRateLimited {
+		t.Fatalf("the rejected event drained the global limit\n")
+	}
+	if err := s.ProcessEvent(ctx, other); !errors.As(err, &e) || e.Code != ErrCodeRateLimited {
+		t.Fatalf("expecting a rate limited error, got %v\n", err)
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var client = &recorderClient{id: 1, ClientContextMap: ClientContextMap{m: map[string]interface{}{}}}
+	var ctx = NewDefaultContextFromBackground(s, client)
+	var limit = RateLimit{Rate: 0.001, Burst: 1}
+	if err := LimitRate(ctx, "test", "testRequest", limit); err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+	var e *Error
+	if err := LimitRate(ctx, "test", "testRequest", limit); !errors.As(err, &e) || e.Code != ErrCodeRateLimited {
+		t.Fatalf("expecting a rate limited error, got %v\n", err)
+	}
+	// the buckets are per channel and event
+	if err := LimitRate(ctx, "test", "", limit); err != nil {
+		t.Fatalf("the event drained the limit of the channel: %s\n", err)
+	}
+	RefundRate(ctx, "test", "testRequest", limit)
+	if err := LimitRate(ctx, "test", "testRequest", limit); err != nil {
+		t.Fatalf("the token was not given back: %s\n", err)
+	}
+	// the refunds never go over the burst
+	RefundRate(ctx, "test", "", limit)
+	RefundRate(ctx, "test", "", limit)
+	_ = LimitRate(ctx, "test", "", limit)
+	if err := LimitRate(ctx, "test", "", limit); !errors.As(err, &e) || e.Code != ErrCodeRateLimited {
+		t.Fatalf("expecting a rate limited error, got %v\n", err)
+	}
+}
@@ -0,0 +1,354 @@
+// Package redisbroker implements eddwise.Broker on top of redis pub/sub
+package redisbroker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/exelr/eddwise"
+)
+
+var _ eddwise.Broker = (*Broker)(nil)
+
+var ErrClosed = errors.New("broker is closed")
+
+type Options struct {
+	Addr     string
+	Password string
+	// DialTimeout defaults to 5 seconds
+	DialTimeout time.Duration
+	// ReconnectWait is the pause between two attempts to restore the subscriptions, defaults to 1 second
+	ReconnectWait time.Duration
+}
+
+// Broker uses one connection to publish and one connection to receive the messages of the subscribed topics
+type Broker struct {
+	opts Options
+
+	pubMx sync.Mutex
+	pub   *conn
+
+	subMx    sync.Mutex
+	sub      *conn
+	handlers map[string]map[*func([]byte)]struct{}
+	pending  map[string]chan struct{}
+	closed   bool
+	done     chan struct{}
+}
+
+func New(opts Options) (*Broker, error) {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.ReconnectWait <= 0 {
+		opts.ReconnectWait = time.Second
+	}
+	var b = &Broker{
+		opts:     opts,
+		handlers: map[string]map[*func([]byte)]struct{}{},
+		pending:  map[string]chan struct{}{},
+		done:     make(chan struct{}),
+	}
+	pub, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	b.pub = pub
+	return b, nil
+}
+
+func (b *Broker) dial() (*conn, error) {
+	nc, err := net.DialTimeout("tcp", b.opts.Addr, b.opts.DialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("redis dial: %w", err)
+	}
+	var c = &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if b.opts.Password != "" {
+		if err := c.writeCommand("AUTH", []byte(b.opts.Password)); err != nil {
+			_ = nc.Close()
+			return nil, err
+		}
+		if _, err := c.readReply(); err != nil {
+			_ = nc.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (b *Broker) Publish(topic string, data []byte) error {
+	b.pubMx.Lock()
+	defer b.pubMx.Unlock()
+	if b.pub == nil {
+		return ErrClosed
+	}
+	err := b.publish(topic, data)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		// the connection may have been dropped, retry once on a new one
+		_ = b.pub.Close()
+		pub, derr := b.dial()
+		if derr != nil {
+			return derr
+		}
+		b.pub = pub
+		err = b.publish(topic, data)
+	}
+	return err
+}
+
+func (b *Broker) publish(topic string, data []byte) error {
+	if err := b.pub.writeCommand("PUBLISH", []byte(topic), data); err != nil {
+		return err
+	}
+	_, err := b.pub.readReply()
+	return err
+}
+
+// Subscribe returns once redis has confirmed the subscription, so no message published afterwards is lost
+func (b *Broker) Subscribe(topic string, handler func(data []byte)) (func(), error) {
+	var key = &handler
+	confirmed, err := b.subscribe(topic, key)
+	if err != nil {
+		return nil, err
+	}
+	var unsubscribe = b.unsubscribeFunc(topic, key)
+	select {
+	case <-confirmed:
+		return unsubscribe, nil
+	case <-time.After(b.opts.DialTimeout):
+		unsubscribe()
+		return nil, fmt.Errorf("redis: subscription to %s not confirmed", topic)
+	}
+}
+
+func (b *Broker) subscribe(topic string, key *func([]byte)) (chan struct{}, error) {
+	b.subMx.Lock()
+	defer b.subMx.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	if b.sub == nil {
+		sub, err := b.dial()
+		if err != nil {
+			return nil, err
+		}
+		b.sub = sub
+		go b.readLoop(sub)
+	}
+	if _, ok := b.handlers[topic]; ok {
+		b.handlers[topic][key] = struct{}{}
+		if confirmed, ok := b.pending[topic]; ok {
+			return confirmed, nil
+		}
+		var confirmed = make(chan struct{})
+		close(confirmed)
+		return confirmed, nil
+	}
+	if err := b.sub.writeCommand("SUBSCRIBE", []byte(topic)); err != nil {
+		return nil, err
+	}
+	var confirmed = make(chan struct{})
+	b.pending[topic] = confirmed
+	b.handlers[topic] = map[*func([]byte)]struct{}{key: {}}
+	return confirmed, nil
+}
+
+func (b *Broker) unsubscribeFunc(topic string, key *func([]byte)) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.subMx.Lock()
+			defer b.subMx.Unlock()
+			delete(b.handlers[topic], key)
+			if len(b.handlers[topic]) == 0 {
+				delete(b.handlers, topic)
+				delete(b.pending, topic)
+				if b.sub != nil {
+					_ = b.sub.writeCommand("UNSUBSCRIBE", []byte(topic))
+				}
+			}
+		})
+	}
+}
+
+// readLoop dispatches the messages in order, and restores the subscriptions when the connection drops
+func (b *Broker) readLoop(sub *conn) {
+	for {
+		reply, err := sub.readReply()
+		if err != nil {
+			if sub = b.reconnect(sub); sub == nil {
+				return
+			}
+			continue
+		}
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 3 {
+			continue
+		}
+		kind, _ := msg[0].([]byte)
+		topic, _ := msg[1].([]byte)
+		switch string(kind) {
+		case "subscribe":
+			b.confirm(string(topic))
+		case "message":
+			data, _ := msg[2].([]byte)
+			for _, handler := range b.topicHandlers(string(topic)) {
+				handler(data)
+			}
+		}
+	}
+}
+
+func (b *Broker) confirm(topic string) {
+	b.subMx.Lock()
+	defer b.subMx.Unlock()
+	if confirmed, ok := b.pending[topic]; ok {
+		close(confirmed)
+		delete(b.pending, topic)
+	}
+}
+
+func (b *Broker) topicHandlers(topic string) []func([]byte) {
+	b.subMx.Lock()
+	defer b.subMx.Unlock()
+	var ret = make([]func([]byte), 0, len(b.handlers[topic]))
+	for handler := range b.handlers[topic] {
+		ret = append(ret, *handler)
+	}
+	return ret
+}
+
+func (b *Broker) reconnect(old *conn) *conn {
+	_ = old.Close()
+	for {
+		b.subMx.Lock()
+		if b.closed {
+			b.subMx.Unlock()
+			return nil
+		}
+		sub, err := b.dial()
+		if err == nil {
+			for topic := range b.handlers {
+				if err = sub.writeCommand("SUBSCRIBE", []byte(topic)); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				b.sub = sub
+				b.subMx.Unlock()
+				return sub
+			}
+			_ = sub.Close()
+		}
+		b.subMx.Unlock()
+		select {
+		case <-b.done:
+			return nil
+		case <-time.After(b.opts.ReconnectWait):
+		}
+	}
+}
+
+func (b *Broker) Close() error {
+	b.subMx.Lock()
+	if b.closed {
+		b.subMx.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	if b.sub != nil {
+		_ = b.sub.Close()
+		b.sub = nil
+	}
+	b.subMx.Unlock()
+
+	b.pubMx.Lock()
+	defer b.pubMx.Unlock()
+	var err = b.pub.Close()
+	b.pub = nil
+	return err
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// conn speaks the subset of RESP needed for pub/sub
+type conn struct {
+	net.Conn
+	wmx sync.Mutex
+	r   *bufio.Reader
+	w   *bufio.Writer
+}
+
+func (c *conn) writeCommand(name string, args ...[]byte) error {
+	c.wmx.Lock()
+	defer c.wmx.Unlock()
+	_, _ = fmt.Fprintf(c.w, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(name), name)
+	for _, arg := range args {
+		_, _ = fmt.Fprintf(c.w, "$%d\r\n", len(arg))
+		_, _ = c.w.Write(arg)
+		_, _ = c.w.WriteString("\r\n")
+	}
+	return c.w.Flush()
+}
+
+func (c *conn) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func (c *conn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		var buf = make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		var items = make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,107 @@
+package redisbroker
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestReadReply(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	go func() {
+		_, _ = server.Write([]byte("*3\r\n$7\r\nmessage\r\n$5\r\ntopic\r\n$4\r\n\x00\r\n\x01\r\n-ERR wrong\r\n"))
+	}()
+	var c = &conn{Conn: client, r: bufio.NewReader(client), w: bufio.NewWriter(client)}
+	reply, err := c.readReply()
+	if err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+	msg, ok := reply.([]interface{})
+	if !ok || len(msg) != 3 || string(msg[2].([]byte)) != "\x00\r\n\x01" {
+		t.Fatalf("unexpected reply %q\n", reply)
+	}
+	if _, err := c.readReply(); err == nil || err.Error() != "redis: ERR wrong" {
+		t.Fatalf("expecting a redis error, got %v\n", err)
+	}
+}
+
+// startRedis runs a throwaway redis-server, the test is skipped when it is not installed
+func startRedis(t *testing.T) string {
+	path, err := exec.LookPath("redis-server")
+	if err != nil {
+		t.Skip("redis-server not found in PATH")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to find a free port: %s\n", err)
+	}
+	var port = l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+
+	var cmd = exec.Command(path, "--port", fmt.Sprint(port), "--bind", "127.0.0.1", "--save", "", "--appendonly", "no")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("unable to start redis-server: %s\n", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+	var addr = fmt.Sprintf("127.0.0.1:%d", port)
+	var deadline = time.Now().Add(5 * time.Second)
+	for {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = c.Close()
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("redis-server did not start: %s\n", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestBrokerPubSub(t *testing.T) {
+	var addr = startRedis(t)
+	b1, err := New(Options{Addr: addr})
+	if err != nil {
+		t.Fatalf("unable to connect: %s\n", err)
+	}
+	defer func() { _ = b1.Close() }()
+	b2, err := New(Options{Addr: addr})
+	if err != nil {
+		t.Fatalf("unable to connect: %s\n", err)
+	}
+	defer func() { _ = b2.Close() }()
+
+	var received = make(chan string, 10)
+	unsubscribe, err := b2.Subscribe("edd:test", func(data []byte) {
+		received <- string(data)
+	})
+	if err != nil {
+		t.Fatalf("unable to subscribe: %s\n", err)
+	}
+	defer unsubscribe()
+
+	// the subscription is asynchronous, publish until the first message comes through
+	var deadline = time.After(5 * time.Second)
+	for {
+		if err := b1.Publish("edd:test", []byte("hello")); err != nil {
+			t.Fatalf("unable to publish: %s\n", err)
+		}
+		select {
+		case msg := <-received:
+			if msg != "hello" {
+				t.Fatalf("unexpected message %q\n", msg)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("message not received\n")
+		}
+	}
+}
@@ -34,22 +34,49 @@ func (r *Room) Has(client Client) bool {
 	return ok
 }
 
-func (r *Room) Join(client Client) error {
-	var clients []Client
-	err := func() error {
-		r.Lock()
-		defer r.Unlock()
-		if _, ok := r.clientsMap[client.GetId()]; ok {
-			return fmt.Errorf("client is already in the room")
+// add puts the client in the room without notifying anyone
+func (r *Room) add(client Client) ([]Client, error) {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.clientsMap[client.GetId()]; ok {
+		return nil, fmt.Errorf("client is already in the room")
+	}
+	r.clientsMap[client.GetId()] = client
+	client.addRoom(r)
+	return r.clients(), nil
+}
+
+// remove takes the client out of the room without notifying anyone
+func (r *Room) remove(client Client) ([]Client, error) {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.clientsMap[client.GetId()]; !ok {
+		return nil, fmt.Errorf("client is not in the room")
+	}
+	var clients = r.clients()
+	delete(r.clientsMap, client.GetId())
+	client.delRoom(r)
+	return clients, nil
+}
+
+// publish replicates the membership change of a local client to the cluster
+func (r *Room) publish(kind clusterKind, client Client) {
+	if _, ok := client.(*RemoteClient); ok {
+		return
+	}
+	if ch, ok := r.ch.(ImplChannel); ok {
+		if c := clusterOf(ch); c != nil {
+			c.publishRoom(kind, ch.Alias(), r.id, false, client)
 		}
-		r.clientsMap[client.GetId()] = client
-		clients = r.clients()
-		return nil
-	}()
+	}
+}
+
+func (r *Room) Join(client Client) error {
+	clients, err := r.add(client)
 	if err != nil {
 		return err
 	}
-	client.addRoom(r)
+	r.publish(clusterRoomJoin, client)
 	var auth = client.GetRawAuth()
 	if auth != nil {
 		_ = r.ch.BroadcastRoomEvent(clients, &RoomJoin{
@@ -84,22 +111,11 @@ func (r *Room) Join(client Client) error {
 
 }
 func (r *Room) Left(client Client) error {
-	var clients []Client
-	err := func() error {
-		r.Lock()
-		defer r.Unlock()
-		if _, ok := r.clientsMap[client.GetId()]; !ok {
-			return fmt.Errorf("client is not in the room")
-		}
-		clients = r.clients()
-		delete(r.clientsMap, client.GetId())
-		return nil
-	}()
+	clients, err := r.remove(client)
 	if err != nil {
 		return err
 	}
-
-	client.delRoom(r)
+	r.publish(clusterRoomLeft, client)
 
 	var auth = client.GetRawAuth()
 	if auth != nil {
@@ -124,6 +140,9 @@ type ImplRoomManager interface {
 	BroadcastRoomEvent([]Client, ServerRoomEvent) error
 	SendPublicRooms(Client) error
 	RoomClientQuit(Client) error
+	RoomCount() int
+	Rooms() []*Room
+	applyClusterRoom(kind clusterKind, room string, public bool, client Client)
 }
 
 type RoomManager struct {
@@ -155,7 +174,23 @@ func (rm *RoomManager) Room(id string) *Room {
 	return rm.rooms[id]
 }
 
-func (rm *RoomManager) Create(id string, public bool) (*Room, error) {
+func (rm *RoomManager) RoomCount() int {
+	rm.RLock()
+	defer rm.RUnlock()
+	return len(rm.rooms)
+}
+
+func (rm *RoomManager) Rooms() []*Room {
+	rm.RLock()
+	defer rm.RUnlock()
+	var ret = make([]*Room, 0, len(rm.rooms))
+	for _, room := range rm.rooms {
+		ret = append(ret, room)
+	}
+	return ret
+}
+
+func (rm *RoomManager) create(id string, public bool) (*Room, error) {
 	rm.Lock()
 	defer rm.Unlock()
 	if _, ok := rm.rooms[id]; ok {
@@ -172,6 +207,34 @@ func (rm *RoomManager) Create(id string, public bool) (*Room, error) {
 	return room, nil
 }
 
+func (rm *RoomManager) Create(id string, public bool) (*Room, error) {
+	room, err := rm.create(id, public)
+	if err != nil {
+		return nil, err
+	}
+	if c := clusterOf(rm.ch); c != nil {
+		c.publishRoom(clusterRoomCreate, rm.ch.Alias(), id, public, nil)
+	}
+	return room, nil
+}
+
+// applyClusterRoom mirrors a room change made on another node, peers were already notified by that node
+func (rm *RoomManager) applyClusterRoom(kind clusterKind, id string, public bool, client Client) {
+	if kind == clusterRoomCreate {
+		_, _ = rm.create(id, public)
+		return
+	}
+	var room = rm.Room(id)
+	if room == nil {
+		return
+	}
+	if kind == clusterRoomJoin {
+		_, _ = room.add(client)
+	} else {
+		_, _ = room.remove(client)
+	}
+}
+
 func (rm *RoomManager) OnRoomEvent(client Client, event ClientRoomEvent) error {
 	switch event := event.(type) {
 	case *RoomJoinRequest:
@@ -210,7 +273,7 @@ func (rm *RoomManager) OnRoomEvent(client Client, event ClientRoomEvent) error {
 			return err
 		}
 		if event.Public {
-			_ = rm.chRm.BroadcastRoomEvent(rm.ch.GetServer().GetClients(), &RoomCreate{Room: event.Room})
+			_ = rm.chRm.BroadcastRoomEvent(rm.ch.GetServer().GetChannelClients(rm.ch.Alias()), &RoomCreate{Room: event.Room})
 		} else {
 			_ = rm.chRm.SendRoomEvent(client, &RoomCreate{Room: event.Room})
 		}
@@ -252,10 +315,13 @@ func (rm *RoomManager) SendPublicRooms(client Client) error {
 	return nil
 }
 
+// RoomClientQuit makes the client leave the rooms of the channel
 func (rm *RoomManager) RoomClientQuit(client Client) error {
 	var rooms = client.GetRooms()
 	for _, room := range rooms {
-		_ = room.Left(client)
+		if room.ch == rm.chRm {
+			_ = room.Left(client)
+		}
 	}
 	return nil
 }
@@ -5,6 +5,19 @@ type ClientRoomEvent interface {
 	ClientRoomEvent()
 }
 
+// newClientRoomEvent returns the room event of the given name, nil if it is not a room event
+func newClientRoomEvent(name string) ClientRoomEvent {
+	switch name {
+	case "edd:room:create_request":
+		return &RoomCreateRequest{}
+	case "edd:room:join_request":
+		return &RoomJoinRequest{}
+	case "edd:room:left_request":
+		return &RoomLeftRequest{}
+	}
+	return nil
+}
+
 type RoomCreateRequest struct {
 	Room   string `json:"room"`
 	Public bool   `json:"public"`
@@ -0,0 +1,234 @@
+package eddwise
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DefaultSessionHistory = 256
+
+// SessionStart is sent on the SystemChannel once the client is connected, or resumed, the token
+// and the number of received messages let the client resume the session after a connection loss
+type SessionStart struct {
+	Token   string `json:"token"`
+	Resumed bool   `json:"resumed"`
+	// Lost is the number of missed messages that could not be replayed
+	Lost uint64 `json:"lost,omitempty"`
+	// Grace is the time in milliseconds the session is kept after a connection loss
+	Grace int64 `json:"grace"`
+}
+
+func (*SessionStart) GetEventName() string {
+	return "edd:session"
+}
+
+func (*SessionStart) ProtocolAlias() string {
+	return "edd:session"
+}
+
+type sessionState int
+
+const (
+	sessionActive sessionState = iota
+	sessionParked
+	sessionEnded
+)
+
+// session keeps the messages written to a client, so the ones it missed can be replayed on resume
+type session struct {
+	token   string
+	mx      sync.Mutex
+	state   sessionState
+	parked  chan struct{}
+	timer   *time.Timer
+	sent    uint64
+	history []outboundFrame
+}
+
+func newSession(historySize int) (*session, error) {
+	var rnd [16]byte
+	if _, err := rand.Read(rnd[:]); err != nil {
+		return nil, err
+	}
+	if historySize <= 0 {
+		historySize = DefaultSessionHistory
+	}
+	return &session{
+		token:   hex.EncodeToString(rnd[:]),
+		parked:  make(chan struct{}),
+		history: make([]outboundFrame, historySize),
+	}, nil
+}
+
+// record assigns the next sequence number to a frame written, or about to be written, to the client
+func (ss *session) record(frame outboundFrame) {
+	ss.mx.Lock()
+	defer ss.mx.Unlock()
+	ss.history[ss.sent%uint64(len(ss.history))] = frame
+	ss.sent++
+}
+
+// missed returns the frames following the first received ones still in history, and how many are gone
+func (ss *session) missed(received uint64) ([]outboundFrame, uint64) {
+	if received > ss.sent {
+		received = ss.sent
+	}
+	var size = uint64(len(ss.history))
+	var first uint64
+	if ss.sent > size {
+		first = ss.sent - size
+	}
+	var lost uint64
+	if received < first {
+		lost = first - received
+		received = first
+	}
+	var frames = make([]outboundFrame, 0, ss.sent-received)
+	for seq := received; seq < ss.sent; seq++ {
+		frames = append(frames, ss.history[seq%size])
+	}
+	return frames, lost
+}
+
+// SetSessionResumption keeps the state of a client that lost its connection for the grace period, the
+// last historySize messages sent to it are replayed when it reconnects. A zero grace disables the resumption
+func (s *ServerSocket) SetSessionResumption(grace time.Duration, historySize int) {
+	s.sessionGrace = grace
+	s.sessionHistory = historySize
+}
+
+func (s *ServerSocket) sessionStart(client *ClientSocket, resumed bool, lost uint64) error {
+	return client.Send(SystemChannel, &SessionStart{
+		Token:   client.session.token,
+		Resumed: resumed,
+		Lost:    lost,
+		Grace:   s.sessionGrace.Milliseconds(),
+	})
+}
+
+func (s *ServerSocket) registerSession(client *ClientSocket) {
+	s.sessionsMx.Lock()
+	defer s.sessionsMx.Unlock()
+	s.sessions[client.session.token] = client
+}
+
+func (s *ServerSocket) unregisterSession(client *ClientSocket) {
+	if client.session == nil {
+		return
+	}
+	s.sessionsMx.Lock()
+	defer s.sessionsMx.Unlock()
+	delete(s.sessions, client.session.token)
+}
+
+// resumeSession attaches the connection to the session it asks for, nil if there is nothing to resume
+func (s *ServerSocket) resumeSession(conn Conn, query url.Values, cs Codec) *ClientSocket {
+	var token = query.Get("edd_session")
+	if s.sessionGrace <= 0 || len(token) == 0 {
+		return nil
+	}
+	received, _ := strconv.ParseUint(query.Get("edd_received"), 10, 64)
+
+	s.sessionsMx.RLock()
+	var client = s.sessions[token]
+	s.sessionsMx.RUnlock()
+	if client == nil || client.codec != cs {
+		// the missed messages cannot be replayed with another codec
+		return nil
+	}
+	var ss = client.session
+
+	ss.mx.Lock()
+	if ss.state == sessionActive {
+		// the previous connection may be half-open, take it over
+		var parked = ss.parked
+		ss.mx.Unlock()
+		client.WriteMx.Lock()
+		if client.Conn != nil {
+			_ = client.Conn.Close()
+		}
+		client.WriteMx.Unlock()
+		select {
+		case <-parked:
+		case <-time.After(s.writeWait):
+			return nil
+		}
+		ss.mx.Lock()
+	}
+	if ss.state != sessionParked || client.Closed() {
+		ss.mx.Unlock()
+		return nil
+	}
+	ss.timer.Stop()
+	ss.state = sessionActive
+	ss.parked = make(chan struct{})
+	frames, lost := ss.missed(received)
+	ss.mx.Unlock()
+
+	client.WriteMx.Lock()
+	client.Conn = conn
+	client.WriteMx.Unlock()
+	client.attach(frames...)
+
+	s.logger.Info("client session resumed", client.logArgs("new_remote_addr", conn.RemoteAddr(), "replayed", len(frames), "lost", lost)...)
+	if err := s.sessionStart(client, true, lost); err != nil {
+		s.logger.Warn("unable to write session start", client.logArgs("error", err)...)
+	}
+	return client
+}
+
+// parkSession detaches the client from its lost connection instead of disconnecting it
+func (s *ServerSocket) parkSession(client *ClientSocket, readErr error) bool {
+	if client.session == nil || client.Closed() || errors.Is(readErr, io.EOF) {
+		return false
+	}
+	client.detach()
+
+	var ss = client.session
+	ss.mx.Lock()
+	defer ss.mx.Unlock()
+	ss.state = sessionParked
+	ss.timer = time.AfterFunc(s.sessionGrace, func() {
+		s.expireSession(client)
+	})
+	close(ss.parked)
+	s.logger.Info("client session parked", client.logArgs("grace", s.sessionGrace)...)
+	return true
+}
+
+// expireSession runs the disconnection of a parked client whose grace period elapsed
+func (s *ServerSocket) expireSession(client *ClientSocket) {
+	var ss = client.session
+	ss.mx.Lock()
+	if ss.state != sessionParked {
+		ss.mx.Unlock()
+		return
+	}
+	ss.state = sessionEnded
+	ss.timer.Stop()
+	ss.mx.Unlock()
+
+	s.logger.Info("client session expired", client.logArgs()...)
+	_ = client.Close()
+	s.disconnectClient(NewDefaultContext(context.Background(), s, client), client)
+}
+
+// expireSessions ends every parked session without waiting for the grace period
+func (s *ServerSocket) expireSessions() {
+	s.sessionsMx.RLock()
+	var clients = make([]*ClientSocket, 0, len(s.sessions))
+	for _, c := range s.sessions {
+		clients = append(clients, c)
+	}
+	s.sessionsMx.RUnlock()
+	for _, c := range clients {
+		s.expireSession(c)
+	}
+}
@@ -0,0 +1,113 @@
+package eddwise
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSessionResume(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetSessionResumption(time.Second, 8)
+	var ch = &TestChannel{}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	defer func() { _ = s.Close(0) }()
+
+	serverConn, conn := NewPipe()
+	go s.ServeConn(serverConn, nil)
+
+	var start = EventMessageTest{}
+	receiveJSON(t, conn, &start)
+	if start.Name != "edd:session" {
+		t.Fatalf("unexpected first message %s\n", start.Name)
+	}
+	var session SessionStart
+	_ = json.Unmarshal(start.Body, &session)
+	if session.Resumed || len(session.Token) == 0 || session.Grace != 1000 {
+		t.Fatalf("unexpected session start %+v\n", session)
+	}
+
+	// lose the connection, then send while the client is away
+	conn.Abort()
+	var client = s.GetClients()[0]
+	select {
+	case <-client.(*ClientSocket).session.parked:
+	case <-time.After(time.Second):
+		t.Fatalf("session was not parked\n")
+	}
+	for i := 0; i < 3; i++ {
+		if err := client.Send("test", TestResponse(fmt.Sprint(i))); err != nil {
+			t.Fatalf("unable to send to parked client: %s\n", err)
+		}
+	}
+	if ch.GetDisconnected() {
+		t.Fatalf("Disconnected() was called on a parked session\n")
+	}
+
+	serverConn, conn = NewPipe()
+	defer func() { _ = conn.Close() }()
+	go s.ServeConn(serverConn, url.Values{"edd_session": {session.Token}, "edd_received": {"1"}})
+	for i := 0; i < 3; i++ {
+		var msg = EventMessageTest{}
+		receiveJSON(t, conn, &msg)
+		if msg.Name != "testResponse" || string(msg.Body) != fmt.Sprintf("%q", fmt.Sprint(i)) {
+			t.Fatalf("unexpected replayed message %s %s\n", msg.Name, msg.Body)
+		}
+	}
+	var resumed = EventMessageTest{}
+	receiveJSON(t, conn, &resumed)
+	_ = json.Unmarshal(resumed.Body, &session)
+	if resumed.Name != "edd:session" || !session.Resumed || session.Lost != 0 {
+		t.Fatalf("unexpected resume message %s %s\n", resumed.Name, resumed.Body)
+	}
+	if len(s.GetClients()) != 1 || s.GetClients()[0] != client {
+		t.Fatalf("the session was not resumed on the same client\n")
+	}
+}
+
+func TestSessionKeepAliveNotRecorded(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetSessionResumption(time.Second, 8)
+	s.SetKeepAlive(20*time.Millisecond, time.Second)
+	defer func() { _ = s.Close(0) }()
+
+	serverConn, conn := NewPipe()
+	defer func() { _ = conn.Close() }()
+	go s.ServeConn(serverConn, nil)
+	for _, name := range []string{"edd:session", "edd:keepalive", "edd:keepalive"} {
+		var msg = EventMessageTest{}
+		receiveJSON(t, conn, &msg)
+		if msg.Name != name {
+			t.Fatalf("unexpected message %s, expecting %s\n", msg.Name, name)
+		}
+	}
+	var ss = s.GetClients()[0].(*ClientSocket).session
+	ss.mx.Lock()
+	defer ss.mx.Unlock()
+	if ss.sent != 1 {
+		t.Fatalf("unexpected %d messages in the session history, expecting only the session start\n", ss.sent)
+	}
+}
+
+func TestSessionMissed(t *testing.T) {
+	ss, err := newSession(4)
+	if err != nil {
+		t.Fatalf("unable to create session: %s\n", err)
+	}
+	for i := 0; i < 6; i++ {
+		ss.record(outboundFrame{data: []byte{byte(i)}})
+	}
+	frames, lost := ss.missed(1)
+	if lost != 1 || len(frames) != 4 || frames[0].data[0] != 2 {
+		t.Fatalf("unexpected replay: %d frames, %d lost\n", len(frames), lost)
+	}
+	if frames, lost = ss.missed(10); lost != 0 || len(frames) != 0 {
+		t.Fatalf("unexpected replay: %d frames, %d lost\n", len(frames), lost)
+	}
+}
@@ -0,0 +1,175 @@
+package eddwise
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// The fallback transport streams the server events with Server-Sent Events on <path>/edd/sse and receives the
+// client events as HTTP POSTs on <path>/edd/send?edd_conn=<id>, where id is sent as first event of the stream
+const (
+	ssePath     = "/edd/sse"
+	sseSendPath = "/edd/send"
+	sseConnKey  = "edd_conn"
+)
+
+var ErrSSEStreamLost = errors.New("sse stream lost")
+
+var _ Conn = (*sseConn)(nil)
+
+// sseConn is the server end of an event stream and of the posts tied to it
+type sseConn struct {
+	id         string
+	remoteAddr string
+	w          io.Writer
+	flush      func() error
+	in         chan pipeFrame
+	once       sync.Once
+	done       chan struct{}
+	err        error
+}
+
+func newSSEConn(remoteAddr string, w io.Writer, flush func() error) (*sseConn, error) {
+	var rnd [16]byte
+	if _, err := rand.Read(rnd[:]); err != nil {
+		return nil, err
+	}
+	return &sseConn{
+		id:         hex.EncodeToString(rnd[:]),
+		remoteAddr: remoteAddr,
+		w:          w,
+		flush:      flush,
+		in:         make(chan pipeFrame),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+func (c *sseConn) ReadFrame() (FrameType, []byte, error) {
+	select {
+	case f := <-c.in:
+		return f.ft, f.data, nil
+	case <-c.done:
+		return 0, nil, c.err
+	}
+}
+
+// WriteFrame sends text frames as they are and binary frames base64 encoded as "binary" events.
+// Text frames are expected to be single line, as the codecs produce them
+func (c *sseConn) WriteFrame(ft FrameType, data []byte) error {
+	var event string
+	if ft == BinaryFrame {
+		event = "binary"
+		data = []byte(base64.StdEncoding.EncodeToString(data))
+	}
+	return c.writeEvent(event, data)
+}
+
+func (c *sseConn) writeEvent(event string, data []byte) error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+	}
+	var buf bytes.Buffer
+	if len(event) > 0 {
+		buf.WriteString("event: ")
+		buf.WriteString(event)
+		buf.WriteByte('\n')
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	_, err := c.w.Write(buf.Bytes())
+	if err == nil {
+		err = c.flush()
+	}
+	if err != nil {
+		c.closeWith(ErrSSEStreamLost)
+	}
+	return err
+}
+
+// push hands a posted frame to the reader, it blocks until the frame is read so posts keep their order
+func (c *sseConn) push(ft FrameType, data []byte) bool {
+	select {
+	case c.in <- pipeFrame{ft: ft, data: append([]byte(nil), data...)}:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// Close ends the stream, the reader gets io.EOF
+func (c *sseConn) Close() error {
+	c.closeWith(io.EOF)
+	return nil
+}
+
+func (c *sseConn) closeWith(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+	})
+}
+
+func (c *sseConn) RemoteAddr() string {
+	return c.remoteAddr
+}
+
+// serveSSE sends the connection id to the client then serves it until the stream is over
+func (s *ServerSocket) serveSSE(conn *sseConn, query url.Values, auth *Auth) {
+	s.sseConns.Store(conn.id, conn)
+	defer s.sseConns.Delete(conn.id)
+	if err := conn.writeEvent("edd:open", []byte(conn.id)); err != nil {
+		s.logger.Debug("sse stream failed", "remote_addr", conn.remoteAddr, "error", err)
+		return
+	}
+	s.serveConn(conn, query, auth)
+}
+
+// receiveSSE routes a post to its stream and returns the http status to reply with,
+// a delete closes the connection on purpose. The posts over the max message size are rejected
+func (s *ServerSocket) receiveSSE(id string, method string, contentType string, body []byte) int {
+	v, ok := s.sseConns.Load(id)
+	if !ok {
+		return http.StatusNotFound
+	}
+	var conn = v.(*sseConn)
+	switch method {
+	case http.MethodDelete:
+		_ = conn.Close()
+		return http.StatusNoContent
+	case http.MethodPost:
+		if s.maxMessageSize > 0 && int64(len(body)) > s.maxMessageSize {
+			return http.StatusRequestEntityTooLarge
+		}
+		var ft = TextFrame
+		if strings.HasPrefix(contentType, "application/octet-stream") {
+			ft = BinaryFrame
+		}
+		if !conn.push(ft, body) {
+			return http.StatusGone
+		}
+		return http.StatusNoContent
+	default:
+		return http.StatusMethodNotAllowed
+	}
+}
+
+func setSSEHeaders(set func(key, value string)) {
+	set("content-type", "text/event-stream")
+	set("cache-control", "no-cache")
+	// disable the buffering of nginx, which would hold the events
+	set("x-accel-buffering", "no")
+}
@@ -0,0 +1,195 @@
+package eddwise
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readSSE returns the event name and the data of the next server-sent event
+func readSSE(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unable to read event stream: %s\n", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case len(line) == 0:
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data += strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestSSEFallback(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var ch = &TestChannel{}
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var srv = httptest.NewServer(s.Handler())
+	defer srv.Close()
+	defer func() { _ = s.Close(0) }()
+
+	res, err := http.Get(srv.URL + "/test/edd/sse")
+	if err != nil || res.StatusCode != http.StatusOK || res.Header.Get("content-type") != "text/event-stream" {
+		t.Fatalf("unable to open event stream: %v %v\n", err, res)
+	}
+	defer func() { _ = res.Body.Close() }()
+	var stream = bufio.NewReader(res.Body)
+	event, id := readSSE(t, stream)
+	if event != "edd:open" || len(id) == 0 {
+		t.Fatalf("unexpected first event %s %s\n", event, id)
+	}
+
+	var sendURL = srv.URL + "/test/edd/send?edd_conn=" + id
+	for _, msg := range []string{
+		`{"channel":"edd","name":"edd:channel:subscribe","body":{"channel":"test"}}`,
+		`{"channel":"test","name":"testRequest","body":"important message"}`,
+	} {
+		res, err = http.Post(sendURL, "text/plain", strings.NewReader(msg))
+		if err != nil || res.StatusCode != http.StatusNoContent {
+			t.Fatalf("unable to post event: %v %v\n", err, res)
+		}
+		_ = res.Body.Close()
+	}
+	event, data := readSSE(t, stream)
+	var response = EventMessageTest{}
+	if err := json.Unmarshal([]byte(data), &response); err != nil || response.Name != "edd:channel:subscribed" {
+		t.Fatalf("unexpected subscribe response %s %s\n", event, data)
+	}
+	event, data = readSSE(t, stream)
+	if err := json.Unmarshal([]byte(data), &response); err != nil || len(event) != 0 {
+		t.Fatalf("unexpected response event %s %s\n", event, data)
+	}
+	if response.Channel != "test" || response.Name != "testResponse" || string(response.Body) != `"B"` {
+		t.Fatalf("unexpected response %s %s %s\n", response.Channel, response.Name, response.Body)
+	}
+	if !ch.GetConnected() {
+		t.Fatalf("Connect() method was not called\n")
+	}
+
+	if res, err = http.Post(srv.URL+"/test/edd/send?edd_conn=unknown", "text/plain", strings.NewReader("{}")); err != nil || res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expecting not found for an unknown connection: %v %v\n", err, res)
+	}
+	_ = res.Body.Close()
+
+	req, _ := http.NewRequest(http.MethodDelete, sendURL, nil)
+	if res, err = http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusNoContent {
+		t.Fatalf("unable to close the connection: %v %v\n", err, res)
+	}
+	_ = res.Body.Close()
+	if _, err := stream.ReadString('\n'); err != io.EOF {
+		t.Fatalf("expecting the stream to end, got %v\n", err)
+	}
+	if !ch.GetDisconnected() {
+		t.Fatalf("Disconnect() method was not called\n")
+	}
+}
+
+// openSSE opens an event stream on srv and returns it along with the url to post to
+func openSSE(t *testing.T, srv *httptest.Server) (*http.Response, *bufio.Reader, string) {
+	t.Helper()
+	res, err := http.Get(srv.URL + "/test/edd/sse")
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("unable to open event stream: %v %v\n", err, res)
+	}
+	var stream = bufio.NewReader(res.Body)
+	event, id := readSSE(t, stream)
+	if event != "edd:open" || len(id) == 0 {
+		t.Fatalf("unexpected first event %s %s\n", event, id)
+	}
+	return res, stream, srv.URL + "/test/edd/send?edd_conn=" + id
+}
+
+// postSSE posts msg to url and returns the status of the response
+func postSSE(t *testing.T, url, msg string) int {
+	t.Helper()
+	res, err := http.Post(url, "text/plain", strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("unable to post event: %s\n", err)
+	}
+	_ = res.Body.Close()
+	return res.StatusCode
+}
+
+func TestSSEMaxMessageSize(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	s.SetMaxMessageSize(128)
+	if err := s.Register(&TestChannel{}); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var srv = httptest.NewServer(s.Handler())
+	defer srv.Close()
+	defer func() { _ = s.Close(0) }()
+	res, stream, sendURL := openSSE(t, srv)
+	defer func() { _ = res.Body.Close() }()
+
+	var oversized = `{"channel":"test","name":"testRequest","body":"` + strings.Repeat("x", 4096) + `"}`
+	if status := postSSE(t, sendURL, oversized); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status %d for an oversized post, expecting %d\n", status, http.StatusRequestEntityTooLarge)
+	}
+	// the connection is still served
+	if status := postSSE(t, sendURL, `{"channel":"edd","name":"edd:channel:subscribe","body":{"channel":"test"}}`); status != http.StatusNoContent {
+		t.Fatalf("unexpected status %d, expecting %d\n", status, http.StatusNoContent)
+	}
+	var response = EventMessageTest{}
+	if _, data := readSSE(t, stream); json.Unmarshal([]byte(data), &response) != nil || response.Name != "edd:channel:subscribed" {
+		t.Fatalf("unexpected subscribe response %s\n", data)
+	}
+}
+
+func TestSSEConnectionId(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	if err := s.Register(&TestChannel{}); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var srv = httptest.NewServer(s.Handler())
+	defer srv.Close()
+	defer func() { _ = s.Close(0) }()
+	res, _, sendURL := openSSE(t, srv)
+	defer func() { _ = res.Body.Close() }()
+
+	var msg = `{"channel":"edd","name":"edd:channel:subscribe","body":{"channel":"test"}}`
+	for _, url := range []string{
+		srv.URL + "/test/edd/send",
+		srv.URL + "/test/edd/send?edd_conn=",
+		srv.URL + "/test/edd/send?edd_conn=unknown",
+		// the ids are matched as a whole
+		sendURL[:len(sendURL)-1],
+	} {
+		if status := postSSE(t, url, msg); status != http.StatusNotFound {
+			t.Fatalf("unexpected status %d posting to %s, expecting %d\n", status, url, http.StatusNotFound)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodPut, sendURL, strings.NewReader(msg))
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expecting method not allowed: %v %v\n", err, res)
+	}
+	// the id of a closed stream is not found anymore
+	req, _ = http.NewRequest(http.MethodDelete, sendURL, nil)
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusNoContent {
+		t.Fatalf("unable to close the connection: %v %v\n", err, res)
+	}
+	var deadline = time.Now().Add(time.Second)
+	for postSSE(t, sendURL, msg) != http.StatusNotFound {
+		if time.Now().After(deadline) {
+			t.Fatalf("the closed connection is still found\n")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
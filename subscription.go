@@ -0,0 +1,280 @@
+package eddwise
+
+import (
+	"strings"
+)
+
+// ChannelSubscribe is sent by the client on the SystemChannel to start receiving the events of a channel,
+// the channel challenges the client first when it requires an auth
+type ChannelSubscribe struct {
+	Channel string `json:"channel"`
+}
+
+func (*ChannelSubscribe) GetEventName() string {
+	return "edd:channel:subscribe"
+}
+
+func (*ChannelSubscribe) ProtocolAlias() string {
+	return "edd:channel:subscribe"
+}
+
+// ChannelUnsubscribe is sent by the client on the SystemChannel to leave a channel
+type ChannelUnsubscribe struct {
+	Channel string `json:"channel"`
+}
+
+func (*ChannelUnsubscribe) GetEventName() string {
+	return "edd:channel:unsubscribe"
+}
+
+func (*ChannelUnsubscribe) ProtocolAlias() string {
+	return "edd:channel:unsubscribe"
+}
+
+// ChannelSubscribed confirms a subscription, it replies to the ChannelSubscribe request
+type ChannelSubscribed struct {
+	Channel string `json:"channel"`
+}
+
+func (*ChannelSubscribed) GetEventName() string {
+	return "edd:channel:subscribed"
+}
+
+func (*ChannelSubscribed) ProtocolAlias() string {
+	return "edd:channel:subscribed"
+}
+
+// ChannelUnsubscribed confirms that the client left a channel, it replies to the ChannelUnsubscribe request
+type ChannelUnsubscribed struct {
+	Channel string `json:"channel"`
+}
+
+func (*ChannelUnsubscribed) GetEventName() string {
+	return "edd:channel:unsubscribed"
+}
+
+func (*ChannelUnsubscribed) ProtocolAlias() string {
+	return "edd:channel:unsubscribed"
+}
+
+// subscription is the state of a client on a channel, a pending one waits for the answer to the auth challenge
+// of the channel to confirm the subscribe request identified by requestId
+type subscription struct {
+	pending   bool
+	requestId uint64
+	nonces    map[string]string
+}
+
+// Subscribed reports whether the client subscribed to the channel, and passed its auth if any
+func (cc *ClientContextMap) Subscribed(channel string) bool {
+	sub, ok := cc.subscription(channel)
+	return ok && !sub.pending
+}
+
+// GetChannels returns the channels the client subscribed to
+func (cc *ClientContextMap) GetChannels() []string {
+	var ret = make([]string, 0)
+	cc.channels.Range(func(key, value any) bool {
+		if !value.(subscription).pending {
+			ret = append(ret, key.(string))
+		}
+		return true
+	})
+	return ret
+}
+
+func (cc *ClientContextMap) subscription(channel string) (subscription, bool) {
+	sub, ok := cc.channels.Load(channel)
+	if !ok {
+		return subscription{}, false
+	}
+	return sub.(subscription), true
+}
+
+func (cc *ClientContextMap) setSubscription(channel string, sub subscription) {
+	cc.channels.Store(channel, sub)
+}
+
+func (cc *ClientContextMap) delSubscription(channel string) {
+	cc.channels.Delete(channel)
+}
+
+// GetChannelClients returns the clients of the whole cluster subscribed to the channel
+func (s *ServerSocket) GetChannelClients(channel string, exclude ...uint64) []Client {
+	s.subscribersMx.RLock()
+	defer s.subscribersMx.RUnlock()
+	var subscribers = s.subscribers[channel]
+	var ret = make([]Client, 0, len(subscribers))
+for1:
+	for id, c := range subscribers {
+		for _, e := range exclude {
+			if e == id {
+				continue for1
+			}
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+func (s *ServerSocket) addSubscriber(channel string, client Client) {
+	client.setSubscription(channel, subscription{})
+	s.subscribersMx.Lock()
+	if s.subscribers[channel] == nil {
+		s.subscribers[channel] = map[uint64]Client{}
+	}
+	s.subscribers[channel][client.GetId()] = client
+	s.subscribersMx.Unlock()
+	if _, remote := client.(*RemoteClient); !remote && s.cluster != nil {
+		s.cluster.publishSubscription(clusterSubscribe, channel, client)
+	}
+}
+
+func (s *ServerSocket) removeSubscriber(channel string, client Client) {
+	client.delSubscription(channel)
+	s.subscribersMx.Lock()
+	delete(s.subscribers[channel], client.GetId())
+	if len(s.subscribers[channel]) == 0 {
+		delete(s.subscribers, channel)
+	}
+	s.subscribersMx.Unlock()
+	if _, remote := client.(*RemoteClient); !remote && s.cluster != nil {
+		s.cluster.publishSubscription(clusterUnsubscribe, channel, client)
+	}
+}
+
+// subscribe challenges the client if the channel requires an auth, the subscription then completes once
+// the client answers, see processAuthEvent
+func (s *ServerSocket) subscribe(ctx Context, event *EventMessage) error {
+	var req = &ChannelSubscribe{}
+	if err := event.DecodeBody(req); err != nil {
+		return WrapError(ErrCodeBadRequest, err)
+	}
+	ch, ok := s.RegisteredChannels[req.Channel]
+	if !ok {
+		return unknownChannelError(req.Channel)
+	}
+	var client = ctx.GetClient()
+	if client.Subscribed(ch.Alias()) {
+		return client.Reply(SystemChannel, event.Id, &ChannelSubscribed{Channel: ch.Alias()})
+	}
+	if upgradeAuthenticated(client) && len(s.channelAuthMethods(ch)) > 0 {
+		// the upgrade request already authenticated the client, see SetUpgradeAuth
+		if err := s.acceptAuth(ctx, ch); err != nil {
+			return asError(err, ErrCodeAuthFailed)
+		}
+		if err := client.Send(ch.Alias(), &AuthPass{Id: client.GetRawAuth().Id}); err != nil {
+			return err
+		}
+		return s.completeSubscription(ctx, ch, event.Id)
+	}
+	challenge, err := s.authChallenge(ctx, ch)
+	if err != nil {
+		return err
+	}
+	if challenge != nil {
+		client.setSubscription(ch.Alias(), subscription{pending: true, requestId: event.Id, nonces: challenge.Nonces})
+		return client.Send(ch.Alias(), challenge)
+	}
+	return s.completeSubscription(ctx, ch, event.Id)
+}
+
+// processAuthEvent handles the answer to the auth challenge sent on subscribe
+func (s *ServerSocket) processAuthEvent(ctx Context, ch ImplChannel, event *EventMessage) error {
+	var client = ctx.GetClient()
+	var sub, _ = client.subscription(ch.Alias())
+	if err := s.processAuth(ctx, ch, event, sub.nonces); err != nil {
+		client.delSubscription(ch.Alias())
+		s.metrics.AuthFailures.Inc()
+		s.logger.Info("client auth failed", clientLogArgs(client, "channel", ch.Alias(), "error", err)...)
+		return asError(err, ErrCodeAuthFailed)
+	}
+	if err := client.Send(ch.Alias(), &AuthPass{Id: client.GetRawAuth().Id}); err != nil {
+		return err
+	}
+	return s.completeSubscription(ctx, ch, sub.requestId)
+}
+
+// completeSubscription notifies the channel and the peers of the client, then confirms the subscription
+func (s *ServerSocket) completeSubscription(ctx Context, ch ImplChannel, requestId uint64) error {
+	var client = ctx.GetClient()
+	if connRecv, ok := ch.(ImplChannelConnected); ok {
+		if err := connRecv.Connected(client); err != nil {
+			client.delSubscription(ch.Alias())
+			s.logger.Info("client rejected on subscribe", clientLogArgs(client, "channel", ch.Alias(), "error", err)...)
+			_ = s.revokeChannelAuth(ctx, ch, client)
+			return asError(err, ErrCodeConnect).withOrigin(ch.Alias(), "")
+		}
+	}
+	s.addSubscriber(ch.Alias(), client)
+
+	//Auto broadcast Join
+	if _, ok := ch.(ImplConnManager); !ok {
+		if chUser, ok := ch.(ImplChannelWithUserJoin); ok {
+			_ = chUser.onJoin(ch, client, true)
+		}
+	}
+	//Auto broadcast RoomList
+	if chRoom, ok := ch.(ImplRoomManager); ok {
+		_ = chRoom.SendPublicRooms(client)
+	}
+	s.logger.Debug("client subscribed", clientLogArgs(client, "channel", ch.Alias())...)
+	return client.Reply(SystemChannel, requestId, &ChannelSubscribed{Channel: ch.Alias()})
+}
+
+func (s *ServerSocket) unsubscribe(ctx Context, event *EventMessage) error {
+	var req = &ChannelUnsubscribe{}
+	if err := event.DecodeBody(req); err != nil {
+		return WrapError(ErrCodeBadRequest, err)
+	}
+	ch, ok := s.RegisteredChannels[req.Channel]
+	if !ok {
+		return unknownChannelError(req.Channel)
+	}
+	var client = ctx.GetClient()
+	if sub, ok := client.subscription(ch.Alias()); ok {
+		if sub.pending {
+			client.delSubscription(ch.Alias())
+		} else {
+			s.leaveChannel(ctx, ch, client)
+		}
+	}
+	return client.Reply(SystemChannel, event.Id, &ChannelUnsubscribed{Channel: ch.Alias()})
+}
+
+// leaveChannel reverts completeSubscription, peers are notified that the client left
+func (s *ServerSocket) leaveChannel(ctx Context, ch ImplChannel, client Client) {
+	//Auto broadcast RoomLeft
+	if chRoom, ok := ch.(ImplRoomManager); ok {
+		_ = chRoom.RoomClientQuit(client)
+	}
+	//Auto broadcast Left
+	if _, ok := ch.(ImplConnManager); !ok {
+		if chUser, ok := ch.(ImplChannelWithUserLeft); ok {
+			_ = chUser.onLeft(ch, client)
+		}
+	}
+	if connRecv, ok := ch.(ImplChannelDisconnected); ok {
+		_ = connRecv.Disconnected(client)
+	}
+	s.removeSubscriber(ch.Alias(), client)
+	_ = s.revokeChannelAuth(ctx, ch, client)
+	s.logger.Debug("client unsubscribed", clientLogArgs(client, "channel", ch.Alias())...)
+}
+
+func unknownChannelError(channel string) *Error {
+	return NewError(ErrCodeUnknownChannel, "unknown channel %s", channel).withOrigin(channel, "")
+}
+
+// isAuthEvent reports whether the event answers an auth challenge
+func isAuthEvent(name string) bool {
+	return strings.HasPrefix(name, "edd:auth:")
+}
+
+// clientLogArgs returns the log attributes of the client, along with args
+func clientLogArgs(client Client, args ...interface{}) []interface{} {
+	if cs, ok := client.(*ClientSocket); ok {
+		return cs.logArgs(args...)
+	}
+	return append([]interface{}{"client_id", client.GetId()}, args...)
+}
@@ -0,0 +1,174 @@
+package eddwise
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// authTestChannel accepts the basic auth of the users whose password is "secret"
+type authTestChannel struct {
+	ConnManager
+	ChannelBroadcastUserJoinLeft
+	s Server
+}
+
+func (ch *authTestChannel) SetReceiver(ImplChannel) error      { return nil }
+func (ch *authTestChannel) Bind(s Server) error                { ch.s = s; return nil }
+func (ch *authTestChannel) GetServer() Server                  { return ch.s }
+func (ch *authTestChannel) Name() string                       { return "private" }
+func (ch *authTestChannel) Alias() string                      { return "private" }
+func (ch *authTestChannel) Route(Context, *EventMessage) error { return nil }
+
+func (ch *authTestChannel) OnBasicAuth(_ Context, ba *BasicAuth) (*Auth, error) {
+	if ba.Password != "secret" {
+		return nil, errors.New("wrong password")
+	}
+	return &Auth{Id: ba.Username}, nil
+}
+
+// expectJSON receives the next message of the pipe and checks its channel and name
+func expectJSON(t *testing.T, conn Conn, channel, name string) EventMessageTest {
+	t.Helper()
+	var msg = EventMessageTest{}
+	receiveJSON(t, conn, &msg)
+	if msg.Channel != channel || msg.Name != name {
+		t.Fatalf("unexpected message %s %s %s, expecting %s %s\n", msg.Channel, msg.Name, msg.Body, channel, name)
+	}
+	return msg
+}
+
+func expectErrorJSON(t *testing.T, conn Conn, code ErrorCode) {
+	t.Helper()
+	var msg = expectJSON(t, conn, ErrorsChannel, "error")
+	var e Error
+	if err := json.Unmarshal(msg.Body, &e); err != nil || e.Code != code {
+		t.Fatalf("unexpected error %s, expecting %s\n", msg.Body, code)
+	}
+}
+
+func TestChannelSubscription(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var ch, private = &TestChannel{}, &authTestChannel{}
+	for _, c := range []ImplChannel{ch, private} {
+		if err := s.Register(c); err != nil {
+			t.Fatalf("unable to register channel: %s\n", err)
+		}
+	}
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(serverConn, nil)
+	defer func() { _ = conn.Close() }()
+
+	var request = EventMessageToSend{Channel: "test", Name: "testRequest", Body: "important message"}
+	sendJSON(t, conn, request)
+	expectErrorJSON(t, conn, ErrCodeNotSubscribed)
+	if ch.GetConnected() {
+		t.Fatalf("Connected() called before subscribing\n")
+	}
+
+	subscribeJSON(t, conn, "test")
+	if !ch.GetConnected() || len(s.GetChannelClients("test")) != 1 || len(s.GetChannelClients("private")) != 0 {
+		t.Fatalf("unexpected subscribers after subscribing\n")
+	}
+	sendJSON(t, conn, request)
+	expectJSON(t, conn, "test", "testResponse")
+
+	sendJSON(t, conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:unsubscribe", Body: &ChannelUnsubscribe{Channel: "test"}})
+	expectJSON(t, conn, SystemChannel, "edd:channel:unsubscribed")
+	if !ch.GetDisconnected() || len(s.GetChannelClients("test")) != 0 {
+		t.Fatalf("unexpected subscribers after unsubscribing\n")
+	}
+	sendJSON(t, conn, request)
+	expectErrorJSON(t, conn, ErrCodeNotSubscribed)
+
+	// the auth is asked on subscribe, a failure keeps the connection open
+	var subscribe = EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: "private"}}
+	sendJSON(t, conn, subscribe)
+	expectJSON(t, conn, "private", "edd:auth:challenge")
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:basic", Body: &BasicAuth{Username: "user", Password: "wrong"}})
+	expectErrorJSON(t, conn, ErrCodeAuthFailed)
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:basic", Body: &BasicAuth{Username: "user", Password: "secret"}})
+	expectErrorJSON(t, conn, ErrCodeNotSubscribed)
+
+	sendJSON(t, conn, subscribe)
+	expectJSON(t, conn, "private", "edd:auth:challenge")
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:basic", Body: &BasicAuth{Username: "user", Password: "secret"}})
+	expectJSON(t, conn, "private", "edd:auth:pass")
+	expectJSON(t, conn, SystemChannel, "edd:channel:subscribed")
+	if ids := private.GetAuthorizedUserIds(); len(ids) != 1 || ids[0] != "user" || len(s.GetChannelClients("private")) != 1 {
+		t.Fatalf("unexpected authorized users %v\n", ids)
+	}
+	sendJSON(t, conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:unsubscribe", Body: &ChannelUnsubscribe{Channel: "private"}})
+	expectJSON(t, conn, SystemChannel, "edd:channel:unsubscribed")
+	if ids := private.GetAuthorizedUserIds(); len(ids) != 0 {
+		t.Fatalf("the auth was not revoked on unsubscribe: %v\n", ids)
+	}
+}
+
+// adminTestChannel accepts only the basic auth of root
+type adminTestChannel struct {
+	authTestChannel
+}
+
+func (ch *adminTestChannel) Name() string  { return "admin" }
+func (ch *adminTestChannel) Alias() string { return "admin" }
+
+func (ch *adminTestChannel) OnBasicAuth(ctx Context, ba *BasicAuth) (*Auth, error) {
+	if ba.Username != "root" {
+		return nil, errors.New("root only")
+	}
+	return ch.authTestChannel.OnBasicAuth(ctx, ba)
+}
+
+// subscribeAuthJSON subscribes to the channel answering its challenge with the basic auth
+func subscribeAuthJSON(t *testing.T, conn Conn, channel, username string) {
+	t.Helper()
+	sendJSON(t, conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: channel}})
+	expectJSON(t, conn, channel, "edd:auth:challenge")
+	sendJSON(t, conn, EventMessageToSend{Channel: channel, Name: "edd:auth:basic", Body: &BasicAuth{Username: username, Password: "secret"}})
+}
+
+func TestChannelSingleIdentity(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var admin, private = &adminTestChannel{}, &authTestChannel{}
+	for _, c := range []ImplChannel{admin, private} {
+		if err := s.Register(c); err != nil {
+			t.Fatalf("unable to register channel: %s\n", err)
+		}
+	}
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(serverConn, nil)
+	defer func() { _ = conn.Close() }()
+
+	subscribeAuthJSON(t, conn, "admin", "root")
+	expectSkippingUsers(t, conn, "admin", "edd:auth:pass")
+	expectJSON(t, conn, SystemChannel, "edd:channel:subscribed")
+
+	// authenticated as root on admin, the client cannot join private as another user
+	subscribeAuthJSON(t, conn, "private", "mallory")
+	expectErrorJSON(t, conn, ErrCodeAuthFailed)
+	if ids := admin.GetAuthorizedUserIds(); len(ids) != 1 || ids[0] != "root" || len(private.GetAuthorizedUserIds()) != 0 {
+		t.Fatalf("unexpected authorized users %v and %v\n", ids, private.GetAuthorizedUserIds())
+	}
+	subscribeAuthJSON(t, conn, "private", "root")
+	expectSkippingUsers(t, conn, "private", "edd:auth:pass")
+	expectJSON(t, conn, SystemChannel, "edd:channel:subscribed")
+
+	sendJSON(t, conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:unsubscribe", Body: &ChannelUnsubscribe{Channel: "admin"}})
+	expectSkippingUsers(t, conn, SystemChannel, "edd:channel:unsubscribed")
+	if ids := private.GetAuthorizedUserIds(); len(admin.GetAuthorizedUserIds()) != 0 || len(ids) != 1 || ids[0] != "root" {
+		t.Fatalf("unexpected authorized users %v and %v\n", admin.GetAuthorizedUserIds(), ids)
+	}
+
+	// the connection manager forgets the user the client joined as, even if its auth changed meanwhile
+	var cm = &ConnManager{}
+	cm.connManagerInit()
+	var client = &ClientSocket{id: 1}
+	cm.setAuth(client, &Auth{Id: "root"})
+	client.setRawAuth(&Auth{Id: "mallory"})
+	if cm.removeAuth(client.id) || len(cm.GetAuthorizedUserIds()) != 0 {
+		t.Fatalf("unexpected authorized users after removal %v\n", cm.GetAuthorizedUserIds())
+	}
+}
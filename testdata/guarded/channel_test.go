@@ -0,0 +1,115 @@
+package guarded
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/exelr/eddwise"
+)
+
+// testClient only records whether it was closed
+type testClient struct {
+	eddwise.ClientContextMap
+	closed bool
+}
+
+func (c *testClient) GetId() uint64                             { return 1 }
+func (c *testClient) Send(string, eddwise.Event) error          { return nil }
+func (c *testClient) Reply(string, uint64, eddwise.Event) error { return nil }
+func (c *testClient) SendJSON(interface{}) error                { return nil }
+func (c *testClient) Close() error                              { c.closed = true; return nil }
+func (c *testClient) Closed() bool                              { return c.closed }
+
+type chatChannel struct {
+	Chat
+}
+
+func (ch *chatChannel) OnMessage(eddwise.Context, *Message) error { return nil }
+
+// denyAdmin allows every policy but the admin role and the history:read scope
+func denyAdmin(_ *eddwise.Auth, p eddwise.Policy) bool {
+	if len(p.Roles) == 1 && p.Roles[0] == "admin" {
+		return false
+	}
+	for _, scope := range p.Scopes {
+		if scope == "history:read" {
+			return false
+		}
+	}
+	return true
+}
+
+// newSubscribedClient registers the channel on a new server and subscribes a client to it
+func newSubscribedClient(t *testing.T, ch eddwise.ImplChannel) (*eddwise.ServerSocket, eddwise.Context, *testClient) {
+	t.Helper()
+	var s = eddwise.NewServer()
+	s.SetLogger(eddwise.NopLogger{})
+	s.SetPolicyHook(denyAdmin)
+	s.SetRateLimitAction(eddwise.DisconnectOverLimit)
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var client = &testClient{}
+	var ctx = eddwise.NewDefaultContextFromBackground(s, client)
+	if err := s.ProcessEvent(ctx, []byte(`{"channel":"edd","name":"edd:channel:subscribe","body":{"channel":"`+ch.Alias()+`"}}`)); err != nil {
+		t.Fatalf("unable to subscribe: %s\n", err)
+	}
+	return s, ctx, client
+}
+
+// expectCode processes the event n times and expects each to fail with code, or to succeed with an empty code
+func expectCode(t *testing.T, s *eddwise.ServerSocket, ctx eddwise.Context, msg string, n int, code eddwise.ErrorCode) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		var err = s.ProcessEvent(ctx, []byte(msg))
+		var e *eddwise.Error
+		switch {
+		case code == "" && err != nil:
+			t.Fatalf("unexpected error processing %s (#%d): %s\n", msg, i, err)
+		case code != "" && (!errors.As(err, &e) || e.Code != code):
+			t.Fatalf("unexpected error processing %s (#%d): %v, expecting %s\n", msg, i, err, code)
+		}
+	}
+}
+
+func TestRouteGuards(t *testing.T) {
+	var s, ctx, client = newSubscribedClient(t, &chatChannel{})
+
+	// the forbidden events never spend the tokens of the channel, burst=40, nor the ones of the event
+	expectCode(t, s, ctx, `{"channel":"chat","name":"kick","body":{"user":"x"}}`, 50, eddwise.ErrCodeForbidden)
+	expectCode(t, s, ctx, `{"channel":"chat","name":"get_history","id":1,"body":{"limit":1}}`, 50, eddwise.ErrCodeForbidden)
+	// neither do the unknown ones
+	expectCode(t, s, ctx, `{"channel":"chat","name":"unknown","body":{}}`, 50, eddwise.ErrCodeUnknownEvent)
+	if client.closed {
+		t.Fatalf("the client was disconnected over a rate limit it did not exceed\n")
+	}
+
+	// message allows bursts of 5
+	expectCode(t, s, ctx, `{"channel":"chat","name":"message","body":{"text":"hi"}}`, 5, "")
+	expectCode(t, s, ctx, `{"channel":"chat","name":"message","body":{"text":"hi"}}`, 1, eddwise.ErrCodeRateLimited)
+	if !client.closed {
+		t.Fatalf("the client over the limit was not disconnected\n")
+	}
+}
+
+type lobbyChannel struct {
+	Lobby
+}
+
+func (ch *lobbyChannel) OnGetHistory(eddwise.Context, *GetHistory) (*History, error) {
+	return &History{}, nil
+}
+
+func TestRouteRefund(t *testing.T) {
+	var s, ctx, _ = newSubscribedClient(t, &lobbyChannel{})
+	var msg = `{"channel":"lobby","name":"get_history","id":1,"body":{"limit":1}}`
+
+	// the channel allows bursts of 2, the event of 3
+	expectCode(t, s, ctx, msg, 2, "")
+	expectCode(t, s, ctx, msg, 1, eddwise.ErrCodeRateLimited)
+	// the channel gets a token back, the event kept the one taken by the rejected request
+	time.Sleep(150 * time.Millisecond)
+	expectCode(t, s, ctx, msg, 1, "")
+	expectCode(t, s, ctx, msg, 1, eddwise.ErrCodeRateLimited)
+}
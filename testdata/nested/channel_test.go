@@ -0,0 +1,107 @@
+package nested
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/exelr/eddwise"
+)
+
+// testClient records the events sent to it, along with the id of the request they reply to
+type testClient struct {
+	eddwise.ClientContextMap
+	replies []testReply
+}
+
+type testReply struct {
+	channel string
+	id      uint64
+	event   eddwise.Event
+}
+
+func (c *testClient) GetId() uint64 { return 1 }
+func (c *testClient) Send(channel string, event eddwise.Event) error {
+	return c.Reply(channel, 0, event)
+}
+func (c *testClient) Reply(channel string, id uint64, event eddwise.Event) error {
+	c.replies = append(c.replies, testReply{channel: channel, id: id, event: event})
+	return nil
+}
+func (c *testClient) SendJSON(interface{}) error { return nil }
+func (c *testClient) Close() error               { return nil }
+func (c *testClient) Closed() bool               { return false }
+
+// mychanChannel keeps the coords it receives and answers xd with the client set in reply
+type mychanChannel struct {
+	Mychan
+	coords []*Coords
+	reply  *Client
+}
+
+func (ch *mychanChannel) OnCoords(_ eddwise.Context, coords *Coords) error {
+	ch.coords = append(ch.coords, coords)
+	return nil
+}
+
+func (ch *mychanChannel) OnXd(eddwise.Context, *Xd) (*Client, error) {
+	return ch.reply, nil
+}
+
+// newSubscribedClient registers the channel on a new server and subscribes a client to it
+func newSubscribedClient(t *testing.T, ch eddwise.ImplChannel) (*eddwise.ServerSocket, eddwise.Context, *testClient) {
+	t.Helper()
+	var s = eddwise.NewServer()
+	s.SetLogger(eddwise.NopLogger{})
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	var client = &testClient{}
+	var ctx = eddwise.NewDefaultContextFromBackground(s, client)
+	if err := s.ProcessEvent(ctx, []byte(`{"channel":"edd","name":"edd:channel:subscribe","body":{"channel":"`+ch.Alias()+`"}}`)); err != nil {
+		t.Fatalf("unable to subscribe: %s\n", err)
+	}
+	client.replies = nil
+	return s, ctx, client
+}
+
+func TestRetainedEvent(t *testing.T) {
+	var ch = &mychanChannel{}
+	var s, ctx, _ = newSubscribedClient(t, ch)
+	for _, msg := range []string{
+		`{"channel":"mychan","name":"coords","body":{"x":1,"y":2}}`,
+		`{"channel":"mychan","name":"coords","body":{"x":3,"y":4}}`,
+		// the body before the name is decoded by Route
+		`{"body":{"x":5,"y":6},"channel":"mychan","name":"coords"}`,
+		`{"body":{"x":7,"y":8},"channel":"mychan","name":"coords"}`,
+	} {
+		if err := s.ProcessEvent(ctx, []byte(msg)); err != nil {
+			t.Fatalf("unable to process %s: %s\n", msg, err)
+		}
+	}
+	if len(ch.coords) != 4 {
+		t.Fatalf("unexpected %d events handled, expecting 4\n", len(ch.coords))
+	}
+	for i, c := range ch.coords {
+		if c.X != 2*i+1 || c.Y != uint16(2*i+2) {
+			t.Fatalf("the retained event %d was mutated: %+v\n", i, c)
+		}
+	}
+}
+
+func TestReply(t *testing.T) {
+	var ch = &mychanChannel{reply: &Client{Lista: []int{1}}}
+	var s, ctx, client = newSubscribedClient(t, ch)
+	if err := s.ProcessEvent(ctx, []byte(`{"channel":"mychan","name":"xd","id":42,"body":{"xd":1}}`)); err != nil {
+		t.Fatalf("unable to process the request: %s\n", err)
+	}
+	if len(client.replies) != 1 || client.replies[0].id != 42 || client.replies[0].channel != "mychan" || client.replies[0].event != ch.reply {
+		t.Fatalf("unexpected replies %+v\n", client.replies)
+	}
+
+	// a handler returning no reply is an error of the server
+	ch.reply = nil
+	var e *eddwise.Error
+	if err := s.ProcessEvent(ctx, []byte(`{"channel":"mychan","name":"xd","id":43,"body":{"xd":1}}`)); !errors.As(err, &e) || e.Code != eddwise.ErrCodeInternal || e.RequestId != 43 {
+		t.Fatalf("expecting an internal error for request 43, got %v\n", err)
+	}
+}
@@ -0,0 +1,242 @@
+package eddwise
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+type TokenAuth struct {
+	Token string `json:"token"`
+}
+
+func (*TokenAuth) GetEventName() string {
+	return "edd:auth:token"
+}
+
+func (*TokenAuth) ProtocolAlias() string {
+	return "edd:auth:token"
+}
+
+// TokenClaims are the claims of a verified JWT, numbers are json.Number
+type TokenClaims map[string]interface{}
+
+// String returns a string claim, empty if missing or of another type
+func (tc TokenClaims) String(name string) string {
+	s, _ := tc[name].(string)
+	return s
+}
+
+// Time returns a NumericDate claim, such as exp
+func (tc TokenClaims) Time(name string) (time.Time, bool) {
+	n, ok := tc[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+}
+
+// Audience returns the aud claim, a single string or an array
+func (tc TokenClaims) Audience() []string {
+	switch aud := tc["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		var ret = make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	default:
+		return nil
+	}
+}
+
+// TokenVerifier verifies HS256 or RS256 JWTs locally: the signature, the expiry and, when set, the audience
+// and the issuer. The algorithm of the token must match the key of the verifier
+type TokenVerifier struct {
+	hmacKey []byte
+	rsaKey  *rsa.PublicKey
+
+	// Audience, if set, must be one of the aud of the token
+	Audience string
+	// Issuer, if set, must be the iss of the token
+	Issuer string
+	// IdClaim is mapped into Auth.Id, sub by default
+	IdClaim string
+	// Leeway tolerates the clock skew with the issuer on exp and nbf
+	Leeway time.Duration
+
+	now func() time.Time
+}
+
+func NewHS256Verifier(key []byte) *TokenVerifier {
+	return &TokenVerifier{hmacKey: key, IdClaim: "sub", now: time.Now}
+}
+
+func NewRS256Verifier(key *rsa.PublicKey) *TokenVerifier {
+	return &TokenVerifier{rsaKey: key, IdClaim: "sub", now: time.Now}
+}
+
+// ParseRSAPublicKey reads a PEM encoded PKIX or PKCS1 RSA public key
+func ParseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %T public key", key)
+	}
+	return rsaKey, nil
+}
+
+// Verify returns the claims of the token, errors wrap ErrInvalidToken or ErrTokenExpired
+func (v *TokenVerifier) Verify(token string) (TokenClaims, error) {
+	var parts = strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeTokenPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %s", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %s", ErrInvalidToken, err)
+	}
+	var signing = []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == "HS256" && v.hmacKey != nil:
+		var mac = hmac.New(sha256.New, v.hmacKey)
+		mac.Write(signing)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case header.Alg == "RS256" && v.rsaKey != nil:
+		var sum = sha256.Sum256(signing)
+		if err := rsa.VerifyPKCS1v15(v.rsaKey, crypto.SHA256, sum[:], sig); err != nil {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unexpected algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	var claims TokenClaims
+	if err := decodeTokenPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %s", ErrInvalidToken, err)
+	}
+	var now = v.now()
+	exp, ok := claims.Time("exp")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if now.After(exp.Add(v.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if nbf, ok := claims.Time("nbf"); ok && now.Add(v.Leeway).Before(nbf) {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if len(v.Audience) > 0 && !slices.Contains(claims.Audience(), v.Audience) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	if len(v.Issuer) > 0 && claims.String("iss") != v.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// Auth maps the claims into an Auth, the IdClaim is the id, the claims are the data and exp is the expiry
+func (v *TokenVerifier) Auth(claims TokenClaims) (*Auth, error) {
+	var id = claims.String(v.IdClaim)
+	if len(id) == 0 {
+		return nil, fmt.Errorf("%w: missing %s", ErrInvalidToken, v.IdClaim)
+	}
+	var auth = &Auth{Id: id, Data: map[string]interface{}(claims)}
+	auth.ExpiresAt, _ = claims.Time("exp")
+	return auth, nil
+}
+
+func decodeTokenPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	var dec = json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// ImplChannelTokenAuth enables edd:auth:token on a channel, see ChannelTokenAuth
+type ImplChannelTokenAuth interface {
+	TokenVerifier() *TokenVerifier
+	// OnTokenAuth runs the custom checks on the claims of a verified token, auth holds the mapped claims
+	OnTokenAuth(ctx Context, claims TokenClaims, auth *Auth) (*Auth, error)
+}
+
+// ChannelTokenAuth implements ImplChannelTokenAuth once embedded in a channel, the channel can override
+// OnTokenAuth to check the claims further
+type ChannelTokenAuth struct {
+	verifier *TokenVerifier
+}
+
+func (ta *ChannelTokenAuth) SetTokenVerifier(v *TokenVerifier) {
+	ta.verifier = v
+}
+
+func (ta *ChannelTokenAuth) TokenVerifier() *TokenVerifier {
+	return ta.verifier
+}
+
+func (ta *ChannelTokenAuth) OnTokenAuth(_ Context, _ TokenClaims, auth *Auth) (*Auth, error) {
+	return auth, nil
+}
+
+func tokenAuth(ctx Context, ch ImplChannelTokenAuth, ta *TokenAuth) (*Auth, error) {
+	var v = ch.TokenVerifier()
+	if v == nil {
+		return nil, errors.New("token verifier not set")
+	}
+	claims, err := v.Verify(ta.Token)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := v.Auth(claims)
+	if err != nil {
+		return nil, err
+	}
+	return ch.OnTokenAuth(ctx, claims, auth)
+}
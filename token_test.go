@@ -0,0 +1,127 @@
+package eddwise
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// signTestToken returns a JWT of the claims, signed with an HMAC key or an RSA private key
+func signTestToken(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	var signing = base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		var mac = hmac.New(sha256.New, k)
+		mac.Write([]byte(signing))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var sum = sha256.Sum256([]byte(signing))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			t.Fatalf("unable to sign token: %s\n", err)
+		}
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestTokenVerifier(t *testing.T) {
+	var secret = []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s\n", err)
+	}
+	var exp = time.Now().Add(time.Hour).Unix()
+	var hs = NewHS256Verifier(secret)
+	hs.Audience = "game"
+	var rs = NewRS256Verifier(&rsaKey.PublicKey)
+
+	var tests = []struct {
+		name     string
+		verifier *TokenVerifier
+		token    string
+		err      error
+	}{
+		{"hs256", hs, signTestToken(t, "HS256", secret, map[string]interface{}{"sub": "user", "aud": []string{"game", "chat"}, "exp": exp}), nil},
+		{"rs256", rs, signTestToken(t, "RS256", rsaKey, map[string]interface{}{"sub": "user", "exp": exp}), nil},
+		{"expired", hs, signTestToken(t, "HS256", secret, map[string]interface{}{"sub": "user", "aud": "game", "exp": time.Now().Add(-time.Minute).Unix()}), ErrTokenExpired},
+		{"no exp", hs, signTestToken(t, "HS256", secret, map[string]interface{}{"sub": "user", "aud": "game"}), ErrInvalidToken},
+		{"audience", hs, signTestToken(t, "HS256", secret, map[string]interface{}{"sub": "user", "aud": "chat", "exp": exp}), ErrInvalidToken},
+		{"signature", hs, signTestToken(t, "HS256", []byte("other"), map[string]interface{}{"sub": "user", "aud": "game", "exp": exp}), ErrInvalidToken},
+		{"alg mismatch", rs, signTestToken(t, "HS256", secret, map[string]interface{}{"sub": "user", "exp": exp}), ErrInvalidToken},
+		{"alg none", hs, signTestToken(t, "none", nil, map[string]interface{}{"sub": "user", "aud": "game", "exp": exp}), ErrInvalidToken},
+		{"malformed", hs, "token", ErrInvalidToken},
+	}
+	for _, test := range tests {
+		claims, err := test.verifier.Verify(test.token)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: unexpected error %v, expecting %v\n", test.name, err, test.err)
+		}
+		if err != nil {
+			continue
+		}
+		auth, err := test.verifier.Auth(claims)
+		if err != nil || auth.Id != "user" || auth.Data.(map[string]interface{})["sub"] != "user" {
+			t.Fatalf("%s: unexpected auth %v %v\n", test.name, auth, err)
+		}
+	}
+}
+
+// tokenTestChannel accepts the tokens of the users that are not banned
+type tokenTestChannel struct {
+	authTestChannel
+	ChannelTokenAuth
+}
+
+func (ch *tokenTestChannel) OnTokenAuth(_ Context, claims TokenClaims, auth *Auth) (*Auth, error) {
+	if claims["banned"] == true {
+		return nil, errors.New("banned")
+	}
+	return auth, nil
+}
+
+func TestChannelTokenAuth(t *testing.T) {
+	var secret = []byte("secret")
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var ch = &tokenTestChannel{}
+	ch.SetTokenVerifier(NewHS256Verifier(secret))
+	if err := s.Register(ch); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	if methods := s.ChannelAuthMethods(ch); len(methods) != 2 || methods[1] != "edd:auth:token" {
+		t.Fatalf("unexpected auth methods %v\n", methods)
+	}
+	var serverConn, conn = NewPipe()
+	go s.ServeConn(serverConn, nil)
+	defer func() { _ = conn.Close() }()
+
+	var exp = time.Now().Add(time.Hour).Unix()
+	var subscribe = EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: "private"}}
+	sendJSON(t, conn, subscribe)
+	expectJSON(t, conn, "private", "edd:auth:challenge")
+	var banned = signTestToken(t, "HS256", secret, map[string]interface{}{"sub": "user", "exp": exp, "banned": true})
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:token", Body: &TokenAuth{Token: banned}})
+	expectErrorJSON(t, conn, ErrCodeAuthFailed)
+
+	sendJSON(t, conn, subscribe)
+	expectJSON(t, conn, "private", "edd:auth:challenge")
+	var token = signTestToken(t, "HS256", secret, map[string]interface{}{"sub": "user", "exp": exp, "role": "admin"})
+	sendJSON(t, conn, EventMessageToSend{Channel: "private", Name: "edd:auth:token", Body: &TokenAuth{Token: token}})
+	expectJSON(t, conn, "private", "edd:auth:pass")
+	expectJSON(t, conn, SystemChannel, "edd:channel:subscribed")
+	var clients = s.GetChannelClients("private")
+	if len(clients) != 1 || clients[0].GetRawAuth().Id != "user" || clients[0].GetRawAuth().Data.(map[string]interface{})["role"] != "admin" {
+		t.Fatalf("unexpected auth after the token auth\n")
+	}
+}
@@ -0,0 +1,124 @@
+package eddwise
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FrameType tells how the payload of a frame is encoded, values match the websocket message types
+type FrameType int
+
+const (
+	TextFrame   FrameType = 1
+	BinaryFrame FrameType = 2
+)
+
+// Conn is a frame oriented connection between a client and the server. ReadFrame must return io.EOF
+// when the peer closed the connection on purpose, any other error is considered a connection loss.
+// Only one goroutine reads and only one goroutine writes at a time
+type Conn interface {
+	ReadFrame() (FrameType, []byte, error)
+	WriteFrame(FrameType, []byte) error
+	Close() error
+	RemoteAddr() string
+}
+
+// DeadlineConn is implemented by connections supporting read and write deadlines
+type DeadlineConn interface {
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+// PingConn is implemented by connections having their own ping/pong control messages
+type PingConn interface {
+	Ping(deadline time.Time) error
+	SetPongHandler(func())
+}
+
+var ErrPipeAborted = errors.New("pipe aborted")
+
+type pipeFrame struct {
+	ft   FrameType
+	data []byte
+}
+
+type pipeState struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+// PipeConn is one end of an in-memory connection, useful to run clients in tests without sockets
+type PipeConn struct {
+	in    <-chan pipeFrame
+	out   chan<- pipeFrame
+	state *pipeState
+	addr  string
+}
+
+var pipeCount uint64
+
+// NewPipe returns the two ends of an in-memory connection, a frame written on one end is read on the other
+func NewPipe() (*PipeConn, *PipeConn) {
+	var id = atomic.AddUint64(&pipeCount, 1)
+	var a, b = make(chan pipeFrame, 64), make(chan pipeFrame, 64)
+	var state = &pipeState{done: make(chan struct{})}
+	return &PipeConn{in: a, out: b, state: state, addr: fmt.Sprintf("pipe:%d:a", id)},
+		&PipeConn{in: b, out: a, state: state, addr: fmt.Sprintf("pipe:%d:b", id)}
+}
+
+func (p *PipeConn) ReadFrame() (FrameType, []byte, error) {
+	select {
+	case f := <-p.in:
+		return f.ft, f.data, nil
+	case <-p.state.done:
+		// deliver what was written before closing
+		select {
+		case f := <-p.in:
+			return f.ft, f.data, nil
+		default:
+			return 0, nil, p.state.err
+		}
+	}
+}
+
+func (p *PipeConn) WriteFrame(ft FrameType, data []byte) error {
+	var frame = pipeFrame{ft: ft, data: append([]byte(nil), data...)}
+	select {
+	case <-p.state.done:
+		return p.state.err
+	default:
+	}
+	select {
+	case p.out <- frame:
+		return nil
+	case <-p.state.done:
+		return p.state.err
+	}
+}
+
+// Close closes both ends, readers get io.EOF
+func (p *PipeConn) Close() error {
+	p.closeWith(io.EOF)
+	return nil
+}
+
+// Abort closes both ends as a lost connection would, readers get ErrPipeAborted
+func (p *PipeConn) Abort() {
+	p.closeWith(ErrPipeAborted)
+}
+
+func (p *PipeConn) closeWith(err error) {
+	p.state.once.Do(func() {
+		p.state.err = err
+		close(p.state.done)
+	})
+}
+
+func (p *PipeConn) RemoteAddr() string {
+	return p.addr
+}
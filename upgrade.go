@@ -0,0 +1,93 @@
+package eddwise
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const fiberAuthKey = "edd_auth"
+
+// UpgradeRequest is the HTTP request opening a websocket or a sse stream
+type UpgradeRequest struct {
+	Header  http.Header
+	Cookies map[string]string
+	Query   url.Values
+	// RemoteIP is the address of the peer, without the port
+	RemoteIP string
+}
+
+// UpgradeAuth authenticates a connection from its upgrade request. It returns the auth of the client, which then
+// subscribes to the channels requiring an auth without being challenged, or nil to let the channels challenge it.
+// An error rejects the request before the upgrade, with the status of an *UpgradeError or 401
+type UpgradeAuth func(r *UpgradeRequest) (*Auth, error)
+
+// UpgradeError rejects an upgrade request with an HTTP status
+type UpgradeError struct {
+	Status  int
+	Message string
+}
+
+func NewUpgradeError(status int, message string) *UpgradeError {
+	return &UpgradeError{Status: status, Message: message}
+}
+
+func (e *UpgradeError) Error() string {
+	return e.Message
+}
+
+// SetUpgradeAuth sets the hook authenticating the connections before their upgrade
+func (s *ServerSocket) SetUpgradeAuth(fn UpgradeAuth) {
+	s.upgradeAuth = fn
+}
+
+// authorizeUpgrade runs the UpgradeAuth hook on the request built by newRequest, if any
+func (s *ServerSocket) authorizeUpgrade(newRequest func() *UpgradeRequest) (*Auth, *UpgradeError) {
+	if s.upgradeAuth == nil {
+		return nil, nil
+	}
+	var r = newRequest()
+	auth, err := s.upgradeAuth(r)
+	if err != nil {
+		s.metrics.AuthFailures.Inc()
+		s.logger.Info("upgrade auth failed", "remote_addr", r.RemoteIP, "error", err)
+		var ue *UpgradeError
+		if !errors.As(err, &ue) {
+			ue = NewUpgradeError(http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		}
+		return nil, ue
+	}
+	return auth, nil
+}
+
+// upgradeAuthenticated reports whether the client was authenticated by its upgrade request
+func upgradeAuthenticated(client Client) bool {
+	cs, ok := client.(*ClientSocket)
+	return ok && cs.authedOnUpgrade
+}
+
+func httpUpgradeRequest(r *http.Request) *UpgradeRequest {
+	var cookies = map[string]string{}
+	for _, c := range r.Cookies() {
+		cookies[c.Name] = c.Value
+	}
+	var ip = r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return &UpgradeRequest{Header: r.Header, Cookies: cookies, Query: r.URL.Query(), RemoteIP: ip}
+}
+
+func fiberUpgradeRequest(c *fiber.Ctx) *UpgradeRequest {
+	var r = &UpgradeRequest{Header: http.Header{}, Cookies: map[string]string{}, Query: fiberQuery(c), RemoteIP: c.IP()}
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		r.Header.Add(string(key), string(value))
+	})
+	c.Request().Header.VisitAllCookie(func(key, value []byte) {
+		r.Cookies[string(key)] = string(value)
+	})
+	return r
+}
@@ -0,0 +1,82 @@
+package eddwise
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUpgradeAuth(t *testing.T) {
+	var s = NewServer()
+	s.SetLogger(NopLogger{})
+	var private = &authTestChannel{}
+	if err := s.Register(private); err != nil {
+		t.Fatalf("unable to register channel: %s\n", err)
+	}
+	s.SetUpgradeAuth(func(r *UpgradeRequest) (*Auth, error) {
+		switch r.Cookies["session"] {
+		case "":
+			// the channels challenge the client
+			return nil, nil
+		case "user-session":
+			if r.Header.Get("X-Client") != "test" || r.Query.Get("room") != "lobby" || len(r.RemoteIP) == 0 {
+				return nil, NewUpgradeError(http.StatusBadRequest, "unexpected request")
+			}
+			return &Auth{Id: "user"}, nil
+		case "banned":
+			return nil, NewUpgradeError(http.StatusForbidden, "banned")
+		default:
+			return nil, errors.New("unknown session")
+		}
+	})
+
+	s.CustomFiberApp(fiber.New(fiber.Config{DisableStartupMessage: true}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s\n", err)
+	}
+	s.initWS("/game")
+	go func() { _ = s.App.Listener(ln) }()
+	defer func() { _ = s.Close(time.Second) }()
+
+	var dial = func(session string) (Conn, int) {
+		var header = http.Header{"X-Client": {"test"}}
+		if len(session) > 0 {
+			header.Set("Cookie", "session="+session)
+		}
+		conn, res, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/game?room=lobby", header)
+		if err != nil {
+			if res == nil {
+				t.Fatalf("unable to dial: %s\n", err)
+			}
+			return nil, res.StatusCode
+		}
+		return &webSocketConn{conn}, res.StatusCode
+	}
+	if _, status := dial("banned"); status != http.StatusForbidden {
+		t.Fatalf("unexpected status %d, expecting %d\n", status, http.StatusForbidden)
+	}
+	if _, status := dial("unknown"); status != http.StatusUnauthorized {
+		t.Fatalf("unexpected status %d, expecting %d\n", status, http.StatusUnauthorized)
+	}
+
+	// the client authenticated on upgrade subscribes without being challenged
+	conn, _ := dial("user-session")
+	defer func() { _ = conn.Close() }()
+	sendJSON(t, conn, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: "private"}})
+	expectJSON(t, conn, "private", "edd:auth:pass")
+	expectJSON(t, conn, SystemChannel, "edd:channel:subscribed")
+	if ids := private.GetAuthorizedUserIds(); len(ids) != 1 || ids[0] != "user" {
+		t.Fatalf("unexpected authorized users %v\n", ids)
+	}
+
+	anonymous, _ := dial("")
+	defer func() { _ = anonymous.Close() }()
+	sendJSON(t, anonymous, EventMessageToSend{Channel: SystemChannel, Name: "edd:channel:subscribe", Body: &ChannelSubscribe{Channel: "private"}})
+	expectJSON(t, anonymous, "private", "edd:auth:challenge")
+}
@@ -64,7 +64,7 @@ func (chbjl *ChannelBroadcastUserJoinLeft) getOtherClientsIds(ch ImplChannel, c
 	if chAuth, ok := ch.(ImplConnManager); ok {
 		return chAuth.GetAuthorizedUserIds(c.GetRawAuth().Id)
 	}
-	var clients = ch.GetServer().GetClients(c.GetId())
+	var clients = ch.GetServer().GetChannelClients(ch.Alias(), c.GetId())
 	var ret []string
 	for _, c := range clients {
 		ret = append(ret, fmt.Sprint(c.GetId()))
@@ -79,7 +79,7 @@ func (chbjl *ChannelBroadcastUserJoinLeft) broadcast(ch ImplChannel, c Client, e
 		clients = chAuth.GetAuthorizedUserClients(c.GetRawAuth().Id)
 	} else {
 		event.SetUint64Id(c.GetId())
-		clients = ch.GetServer().GetClients(c.GetId())
+		clients = ch.GetServer().GetChannelClients(ch.Alias(), c.GetId())
 	}
 
 	if len(clients) > 0 {
@@ -0,0 +1,69 @@
+package eddwise
+
+import (
+	"io"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+var (
+	_ Conn         = (*webSocketConn)(nil)
+	_ DeadlineConn = (*webSocketConn)(nil)
+	_ PingConn     = (*webSocketConn)(nil)
+)
+
+// DefaultMaxMessageSize is the default limit of the size of the messages received from the clients
+const DefaultMaxMessageSize = 1 << 20
+
+// SetMaxMessageSize limits the size of the messages received from the clients: the websocket connections sending
+// larger messages are closed and the larger posts of the sse fallback are rejected with 413. Zero disables it
+func (s *ServerSocket) SetMaxMessageSize(size int64) {
+	s.maxMessageSize = size
+}
+
+// webSocketConn adapts a websocket connection, the one of the net/http handler and of the fiber adapter
+type webSocketConn struct {
+	*websocket.Conn
+}
+
+// newWebSocketConn adapts conn, applying the message size limit of the server
+func (s *ServerSocket) newWebSocketConn(conn *websocket.Conn) *webSocketConn {
+	if s.maxMessageSize > 0 {
+		conn.SetReadLimit(s.maxMessageSize)
+	}
+	return &webSocketConn{conn}
+}
+
+func (c *webSocketConn) ReadFrame() (FrameType, []byte, error) {
+	mt, data, err := c.Conn.ReadMessage()
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return 0, nil, io.EOF
+	}
+	return FrameType(mt), data, err
+}
+
+func (c *webSocketConn) WriteFrame(ft FrameType, data []byte) error {
+	return c.Conn.WriteMessage(int(ft), data)
+}
+
+func (c *webSocketConn) RemoteAddr() string {
+	return c.Conn.RemoteAddr().String()
+}
+
+func (c *webSocketConn) Ping(deadline time.Time) error {
+	return c.Conn.WriteControl(websocket.PingMessage, nil, deadline)
+}
+
+func (c *webSocketConn) SetPongHandler(h func()) {
+	c.Conn.SetPongHandler(func(string) error {
+		h()
+		return nil
+	})
+}
+
+// Close expires the read deadline too: fasthttp closes the hijacked connections of fiber only once the handler returned
+func (c *webSocketConn) Close() error {
+	_ = c.Conn.SetReadDeadline(time.Now())
+	return c.Conn.Close()
+}